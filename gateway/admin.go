@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// adminHandler serves the gateway's administrative API. It should only be reachable by operators.
+func (g *Gateway) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cache/purge", g.purgeCache)
+	mux.HandleFunc("/mirror/stats", g.mirrorStats)
+	return mux
+}
+
+// purgeCache removes cached responses by exact key (?key=serviceA:/serviceA/items?page=1,
+// including all its variants) or by key prefix (?prefix=serviceA: for a whole service).
+func (g *Gateway) purgeCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, prefix := r.URL.Query().Get("key"), r.URL.Query().Get("prefix")
+	if key == "" && prefix == "" {
+		http.Error(w, "key or prefix is required", http.StatusBadRequest)
+		return
+	}
+
+	g.lock.Lock()
+	store := g.cacheStore
+	g.lock.Unlock()
+
+	purged := 0
+	if store != nil {
+		if key != "" {
+			if store.Delete(key) {
+				purged++
+			}
+			purged += store.Purge(key + varySeparator)
+		}
+		if prefix != "" {
+			purged += store.Purge(prefix)
+		}
+	}
+	g.log.Sugar().Infof("Purged %d cached responses for key %q prefix %q", purged, key, prefix)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
+
+// mirrorStats reports, per mirrored service, how the responses of its shadow service
+// compared to its own.
+func (g *Gateway) mirrorStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := make(map[string]*MirrorStats)
+	g.lock.Lock()
+	for serviceName, service := range g.serviceRegistry {
+		if service.mirror != nil {
+			stats[serviceName] = service.mirror.stats.snapshot()
+		}
+	}
+	g.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAdminHandler_PurgeCache(t *testing.T) {
+	g := createTestGateway("")
+	g.cacheStore = NewMemoryCacheStore(defaultCacheMaxBytes)
+	for _, key := range []string{"serviceA:/serviceA/items?page=1", "serviceA:/serviceA/items?page=1" + varySeparator + "en\n", "serviceA:/serviceA/other", "serviceB:/serviceA/items"} {
+		g.cacheStore.Set(key, testEntry(key))
+	}
+	handler := g.adminHandler()
+
+	cases := []struct {
+		method string
+		query  url.Values
+		status int
+		body   string
+	}{
+		{"POST", url.Values{"key": {"serviceA:/serviceA/items?page=1"}}, http.StatusOK, `{"purged":2}` + "\n"},
+		{"DELETE", url.Values{"prefix": {"serviceA:"}}, http.StatusOK, `{"purged":1}` + "\n"},
+		{"POST", url.Values{}, http.StatusBadRequest, ""},
+		{"GET", url.Values{"prefix": {"/"}}, http.StatusMethodNotAllowed, ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/cache/purge?"+c.query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != c.status {
+			t.Errorf("Expected status %d for %s %s, got %d", c.status, c.method, c.query, rr.Code)
+		}
+		if c.body != "" && rr.Body.String() != c.body {
+			t.Errorf("Expected body %q for %s, got %q", c.body, c.query, rr.Body.String())
+		}
+	}
+
+	if _, ok := g.cacheStore.Get("serviceB:/serviceA/items"); !ok {
+		t.Errorf("Expected the responses of serviceB to remain cached")
+	}
+}
+
+func TestAdminHandler_MirrorStats(t *testing.T) {
+	g := createTestGateway("")
+	mirror, _ := NewMirror(&MirrorConfig{Service: "shadow", Percentage: 10}, g.log)
+	mirror.stats.record(func(s *MirrorStats) {
+		s.Mirrored, s.Matched, s.Mismatched = 3, 2, 1
+		s.Statuses["200/200"], s.Statuses["200/500"] = 2, 1
+	})
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{serviceName: "service1", mirror: mirror}
+	g.serviceRegistry["service2"] = &GatewayServiceConfig{serviceName: "service2"}
+
+	rr := httptest.NewRecorder()
+	g.adminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/mirror/stats", nil))
+	expected := `{"service1":{"shadow":"shadow","mirrored":3,"matched":2,"mismatched":1,"failed":0,"skipped":0,"dropped":0,"statuses":{"200/200":2,"200/500":1}}}` + "\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != expected {
+		t.Errorf("Expected %s, got %d %s", expected, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	g.adminHandler().ServeHTTP(rr, httptest.NewRequest("POST", "/mirror/stats", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
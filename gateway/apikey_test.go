@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	store, _ := NewConsumerStore([]ConsumerConfig{
+		{Name: "billing", Groups: []string{"internal"}, APIKeys: []string{sha256Hash("billing-key")}},
+		{Name: "reports", APIKeys: []string{sha256Hash("reports-key")}},
+	})
+	auth := NewAPIKeyAuthenticator(&APIKeyConfig{QueryParam: "api_key", Header: "X-Key", Consumers: []string{"billing"}}, store)
+
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("X-Key", "billing-key")
+	identity, err := auth.Authenticate(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if identity.Consumer != "billing" || identity.Groups[0] != "internal" {
+		t.Errorf("Unexpected identity: %+v", identity)
+	}
+	if value, ok := identity.Headers["X-Key"]; !ok || value != "" {
+		t.Errorf("Expected the key header to be stripped upstream")
+	}
+
+	req = httptest.NewRequest("GET", "/service1?z=1&api_key=billing-key&a=%2F+x&api%5Fkey=again", nil)
+	if _, err := auth.Authenticate(req); err != nil {
+		t.Errorf("Expected key in query parameter to be accepted, got %v", err)
+	}
+	if req.URL.RawQuery != "z=1&a=%2F+x" {
+		t.Errorf("Expected only the key to be removed from the query, got %q", req.URL.RawQuery)
+	}
+
+	if _, err := auth.Authenticate(httptest.NewRequest("GET", "/service1", nil)); err != ErrNoCredentials {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+	if _, err := auth.Authenticate(httptest.NewRequest("GET", "/service1?api_key=wrong", nil)); err == nil {
+		t.Errorf("Expected invalid key to be rejected")
+	}
+	if _, err := auth.Authenticate(httptest.NewRequest("GET", "/service1?api_key=reports-key", nil)); err == nil {
+		t.Errorf("Expected consumer outside the allowed list to be rejected")
+	}
+}
+
+func TestAPIKeyAuthenticator_DefaultHeader(t *testing.T) {
+	store, _ := NewConsumerStore([]ConsumerConfig{{Name: "billing", APIKeys: []string{sha256Hash("billing-key")}}})
+	auth := NewAPIKeyAuthenticator(&APIKeyConfig{}, store)
+
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set(defaultAPIKeyHeader, "billing-key")
+	if _, err := auth.Authenticate(req); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no credentials.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity describes the authenticated caller of a request.
+type Identity struct {
+	Subject string
+	// Consumer is the name of the configured consumer that owns the credentials, if any.
+	Consumer string
+	Groups   []string
+	Claims   map[string]interface{}
+	// Headers are added to the upstream request on behalf of the caller.
+	Headers map[string]string
+}
+
+// consumerIdentity returns the identity of a configured consumer.
+func consumerIdentity(consumer *ConsumerConfig) *Identity {
+	return &Identity{
+		Subject:  consumer.Name,
+		Consumer: consumer.Name,
+		Groups:   consumer.Groups,
+		Headers:  make(map[string]string),
+	}
+}
+
+// consumerAllowed reports whether consumer is in allowed. An empty list allows everyone.
+func consumerAllowed(consumer *ConsumerConfig, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, name := range allowed {
+		if name == consumer.Name {
+			return true
+		}
+	}
+	return false
+}
+
+type MultiAuthenticator struct {
+	authenticators []Authenticator
+}
+
+// NewMultiAuthenticator initializes a MultiAuthenticator accepting requests that any of
+// authenticators accepts, tried in order.
+func NewMultiAuthenticator(authenticators ...Authenticator) *MultiAuthenticator {
+	return &MultiAuthenticator{authenticators: authenticators}
+}
+
+// Authenticate returns the identity from the first authenticator that finds credentials.
+func (ma *MultiAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	for _, authenticator := range ma.authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if err != ErrNoCredentials {
+			return identity, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// Challenge lists the challenges of all authenticators.
+func (ma *MultiAuthenticator) Challenge() string {
+	challenges := make([]string, 0, len(ma.authenticators))
+	for _, authenticator := range ma.authenticators {
+		challenges = append(challenges, authenticator.Challenge())
+	}
+	return strings.Join(challenges, ", ")
+}
+
+type identityKey struct{}
+
+// withIdentity returns a copy of ctx carrying the caller's identity.
+func withIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// identityFromContext returns the caller's identity, or nil for anonymous requests.
+func identityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// newAuthenticator builds the authenticator for a service's auth config, checking API keys
+// and passwords against consumers.
+func (g *Gateway) newAuthenticator(config *AuthConfig, consumers *ConsumerStore) (Authenticator, error) {
+	var authenticators []Authenticator
+	if config.JWT != nil {
+		authenticator, err := NewJWTAuthenticator(config.JWT, g.log)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if config.APIKey != nil {
+		authenticators = append(authenticators, NewAPIKeyAuthenticator(config.APIKey, consumers))
+	}
+	if config.Basic != nil {
+		authenticators = append(authenticators, NewBasicAuthenticator(config.Basic, consumers))
+	}
+
+	switch len(authenticators) {
+	case 0:
+		return nil, errors.New("auth: no authentication method configured")
+	case 1:
+		return authenticators[0], nil
+	default:
+		return NewMultiAuthenticator(authenticators...), nil
+	}
+}
+
+// authenticate verifies the request against the service's authenticator.
+// It writes a 401 response and returns nil when authentication fails.
+func (g *Gateway) authenticate(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) *http.Request {
+	identity, err := service.authenticator.Authenticate(r)
+	if err != nil {
+		g.log.Sugar().Infof("Authentication failed for service %s: %v", service.serviceName, err)
+		w.Header().Set("WWW-Authenticate", service.authenticator.Challenge())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	return r.WithContext(withIdentity(r.Context(), identity))
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// defaultBasicRealm is used when the config does not name a realm.
+const defaultBasicRealm = "gateway"
+
+type BasicAuthenticator struct {
+	config    *BasicAuthConfig
+	consumers *ConsumerStore
+}
+
+// NewBasicAuthenticator initializes a BasicAuthenticator checking passwords against consumers.
+func NewBasicAuthenticator(config *BasicAuthConfig, consumers *ConsumerStore) *BasicAuthenticator {
+	return &BasicAuthenticator{
+		config:    config,
+		consumers: consumers,
+	}
+}
+
+// Authenticate checks the username and password from the Authorization header.
+func (ba *BasicAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	consumer, ok := ba.consumers.VerifyPassword(username, password)
+	if !ok {
+		return nil, errors.New("invalid username or password")
+	}
+	if !consumerAllowed(consumer, ba.config.Consumers) {
+		return nil, errors.New("consumer " + consumer.Name + " is not allowed")
+	}
+
+	// The password is only meant for the gateway
+	identity := consumerIdentity(consumer)
+	identity.Headers["Authorization"] = ""
+	return identity, nil
+}
+
+// Challenge returns the WWW-Authenticate header value for Basic authentication.
+func (ba *BasicAuthenticator) Challenge() string {
+	realm := ba.config.Realm
+	if realm == "" {
+		realm = defaultBasicRealm
+	}
+	return "Basic realm=" + strconv.Quote(realm)
+}
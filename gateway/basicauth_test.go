@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuthenticator(t *testing.T) {
+	store, _ := NewConsumerStore([]ConsumerConfig{
+		{Name: "ops", Groups: []string{"admin"}, Username: "alice", Password: bcryptHash(t, "password")},
+		{Name: "guest", Username: "bob", Password: argon2Hash("password")},
+	})
+	auth := NewBasicAuthenticator(&BasicAuthConfig{Realm: "internal", Consumers: []string{"ops"}}, store)
+
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.SetBasicAuth("alice", "password")
+	identity, err := auth.Authenticate(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if identity.Consumer != "ops" || identity.Groups[0] != "admin" {
+		t.Errorf("Unexpected identity: %+v", identity)
+	}
+	if value, ok := identity.Headers["Authorization"]; !ok || value != "" {
+		t.Errorf("Expected the Authorization header to be stripped upstream")
+	}
+
+	req.SetBasicAuth("alice", "wrong")
+	if _, err := auth.Authenticate(req); err == nil {
+		t.Errorf("Expected wrong password to be rejected")
+	}
+
+	req.SetBasicAuth("bob", "password")
+	if _, err := auth.Authenticate(req); err == nil {
+		t.Errorf("Expected consumer outside the allowed list to be rejected")
+	}
+
+	if _, err := auth.Authenticate(httptest.NewRequest("GET", "/service1", nil)); err != ErrNoCredentials {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+
+	if auth.Challenge() != `Basic realm="internal"` {
+		t.Errorf("Unexpected challenge %s", auth.Challenge())
+	}
+}
@@ -0,0 +1,474 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	// defaultCacheMaxEntrySize bounds a single cached response when no size is configured.
+	defaultCacheMaxEntrySize = 1 << 20
+	// defaultCacheRefreshTimeout bounds a background refresh of a service without a timeout
+	// limit, as it is detached from the request that found the entry stale.
+	defaultCacheRefreshTimeout = 30 * time.Second
+	// varySeparator joins a cache key and the request header values selecting a variant.
+	varySeparator = "\x00"
+)
+
+// cacheableStatuses are the response statuses stored by the cache.
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+type ResponseCache struct {
+	config       *CacheConfig
+	store        CacheStore
+	maxEntrySize int64
+	// inflight holds a channel per key being fetched, closed when the fetch completes.
+	inflight map[string]chan struct{}
+	mux      sync.Mutex
+	log      *log.Logger
+}
+
+// NewResponseCache initializes a ResponseCache keeping a service's responses in store.
+func NewResponseCache(config *CacheConfig, store CacheStore, log *log.Logger) *ResponseCache {
+	maxEntrySize := config.MaxEntrySize
+	if maxEntrySize <= 0 {
+		maxEntrySize = defaultCacheMaxEntrySize
+	}
+	return &ResponseCache{
+		config:       config,
+		store:        store,
+		maxEntrySize: maxEntrySize,
+		inflight:     make(map[string]chan struct{}),
+		log:          log,
+	}
+}
+
+// Cacheable reports whether r may be answered from the cache.
+func (rc *ResponseCache) Cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Range") != "" {
+		return false
+	}
+	_, noStore := parseCacheControl(r.Header.Values("Cache-Control"))["no-store"]
+	return !noStore
+}
+
+// cacheKey identifies the resource requested by r from service. The same path may reach
+// different services through split and match routes, so keys start with the service name,
+// followed by the host when the route matched on it: purging the prefix "serviceA:" clears
+// a whole service.
+func cacheKey(r *http.Request, service *GatewayServiceConfig, host string) string {
+	key := service.serviceName + ":"
+	if host != "" {
+		key += "//" + host
+	}
+	key += r.URL.Path
+	if r.URL.RawQuery != "" {
+		key += "?" + r.URL.RawQuery
+	}
+	return key
+}
+
+// variantKey returns the key of the variant of key selected by r's values of the vary headers.
+func variantKey(key string, r *http.Request, vary []string) string {
+	var variant strings.Builder
+	variant.WriteString(key + varySeparator)
+	for _, header := range vary {
+		variant.WriteString(strings.Join(r.Header.Values(header), ",") + "\n")
+	}
+	return variant.String()
+}
+
+// lookup returns the stored response matching r, following Vary to the right variant.
+func (rc *ResponseCache) lookup(key string, r *http.Request) (*CacheEntry, bool) {
+	entry, ok := rc.store.Get(key)
+	if !ok || len(entry.Vary) == 0 {
+		return entry, ok
+	}
+	return rc.store.Get(variantKey(key, r, entry.Vary))
+}
+
+// save stores entry as the response to r. Responses with a Vary header are stored as a variant,
+// with an entry under the plain key recording which headers select it.
+func (rc *ResponseCache) save(key string, r *http.Request, entry *CacheEntry) {
+	var vary []string
+	for _, value := range entry.Header.Values("Vary") {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				vary = append(vary, http.CanonicalHeaderKey(header))
+			}
+		}
+	}
+	if len(vary) == 0 {
+		entry.Key = key
+		rc.store.Set(key, entry)
+		return
+	}
+
+	rc.store.Set(key, &CacheEntry{Key: key, Vary: vary, Stored: entry.Stored})
+	entry.Key = variantKey(key, r, vary)
+	rc.store.Set(entry.Key, entry)
+}
+
+// begin registers a fetch of key. It returns nil if the caller should fetch, otherwise
+// a channel closed when the fetch already in progress completes.
+func (rc *ResponseCache) begin(key string) <-chan struct{} {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	if wait, ok := rc.inflight[key]; ok {
+		return wait
+	}
+	rc.inflight[key] = make(chan struct{})
+	return nil
+}
+
+// end completes the fetch of key registered by begin.
+func (rc *ResponseCache) end(key string) {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	close(rc.inflight[key])
+	delete(rc.inflight, key)
+}
+
+// freshness computes how long resp, fetched for r, may be served from the cache.
+// It reports false if the response must not be stored.
+func (rc *ResponseCache) freshness(r *http.Request, resp *http.Response, now time.Time) (stored, expires, staleUntil time.Time, ok bool) {
+	if !cacheableStatuses[resp.StatusCode] || resp.Header.Get("Set-Cookie") != "" || resp.Header.Get("Vary") == "*" {
+		return stored, expires, staleUntil, false
+	}
+	directives := parseCacheControl(resp.Header.Values("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return stored, expires, staleUntil, false
+	}
+	if _, ok := directives["private"]; ok {
+		return stored, expires, staleUntil, false
+	}
+	// A shared cache only keeps responses to authorized requests that explicitly allow it,
+	// and responses to callers the gateway identified when they are public
+	if identityFromContext(r.Context()) != nil {
+		if _, public := directives["public"]; !public {
+			return stored, expires, staleUntil, false
+		}
+	} else if r.Header.Get("Authorization") != "" {
+		_, public := directives["public"]
+		_, sMaxAge := directives["s-maxage"]
+		_, mustRevalidate := directives["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return stored, expires, staleUntil, false
+		}
+	}
+
+	_, noCache := directives["no-cache"]
+	var ttl time.Duration
+	switch {
+	case rc.config.TTL > 0:
+		ttl = rc.config.TTL
+	case noCache:
+		ttl = 0
+	case directives["s-maxage"] != "":
+		ttl = directiveSeconds(directives, "s-maxage")
+	case directives["max-age"] != "":
+		ttl = directiveSeconds(directives, "max-age")
+	case resp.Header.Get("Expires") != "":
+		expiresAt, err := http.ParseTime(resp.Header.Get("Expires"))
+		if err != nil {
+			return stored, expires, staleUntil, false
+		}
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		ttl = expiresAt.Sub(date)
+	default:
+		// Without explicit freshness the response is not stored
+		return stored, expires, staleUntil, false
+	}
+
+	age, _ := strconv.Atoi(resp.Header.Get("Age"))
+	stored = now.Add(-time.Duration(age) * time.Second)
+	expires = stored.Add(ttl)
+	if !expires.After(now) && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		// Expired on arrival and impossible to revalidate
+		return stored, expires, staleUntil, false
+	}
+
+	staleWhileRevalidate := rc.config.StaleWhileRevalidate
+	if directives["stale-while-revalidate"] != "" {
+		staleWhileRevalidate = directiveSeconds(directives, "stale-while-revalidate")
+	}
+	if _, ok := directives["must-revalidate"]; ok || noCache {
+		staleWhileRevalidate = 0
+	}
+	return stored, expires, expires.Add(staleWhileRevalidate), true
+}
+
+// parseCacheControl parses Cache-Control directives into a map of lowercased names to values.
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// directiveSeconds returns a delta-seconds directive as a duration, treating invalid values as zero.
+func directiveSeconds(directives map[string]string, name string) time.Duration {
+	seconds, err := strconv.Atoi(directives[name])
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// shareable reports whether a stored response with header may be served for r. Callers the
+// gateway identified only get responses marked public.
+func shareable(r *http.Request, header http.Header) bool {
+	if identityFromContext(r.Context()) == nil {
+		return true
+	}
+	_, public := parseCacheControl(header.Values("Cache-Control"))["public"]
+	return public
+}
+
+// serveCached answers r from the service's response cache. Fresh entries are served directly,
+// stale ones within stale-while-revalidate are served while refreshed in the background, and
+// concurrent misses for the same key wait for a single upstream request. host is set when
+// the route matched on the request's host.
+func (g *Gateway) serveCached(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig, host string) {
+	cache := service.cache
+	key := cacheKey(r, service, host)
+	requestDirectives := parseCacheControl(r.Header.Values("Cache-Control"))
+	_, noCache := requestDirectives["no-cache"]
+	revalidate := noCache || requestDirectives["max-age"] == "0"
+
+	for attempt := 0; ; attempt++ {
+		entry, ok := cache.lookup(key, r)
+		if ok && !shareable(r, entry.Header) {
+			ok = false
+		}
+		now := time.Now()
+		if ok && !revalidate && now.Before(entry.Expires) {
+			g.serveEntry(w, r, service, entry, "HIT")
+			return
+		}
+		if ok && !revalidate && now.Before(entry.StaleUntil) {
+			if cache.begin(key) == nil {
+				go g.refreshCache(r.Clone(context.WithoutCancel(r.Context())), service, key, entry)
+			}
+			g.serveEntry(w, r, service, entry, "STALE")
+			return
+		}
+		if !ok {
+			entry = nil
+		}
+
+		// Concurrent misses wait once for the first request to fill the cache
+		if r.Method == http.MethodGet && attempt == 0 {
+			if wait := cache.begin(key); wait != nil {
+				select {
+				case <-wait:
+					continue
+				case <-r.Context().Done():
+					g.waitFailed(w, service.serviceName, r.Context().Err())
+					return
+				}
+			}
+			defer cache.end(key)
+		}
+		g.fillCache(w, r, service, key, entry)
+		return
+	}
+}
+
+// fillCache fetches r from the upstream, revalidating stale if given, stores a cacheable
+// response and sends it to the client.
+func (g *Gateway) fillCache(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig, key string, stale *CacheEntry) {
+	fetch, err := g.fetchCacheable(r, service, key, stale)
+	if err != nil {
+		g.forwardFailed(w, service.serviceName, err)
+		return
+	}
+	if fetch.entry != nil {
+		status := "MISS"
+		if fetch.revalidated {
+			status = "REVALIDATED"
+		}
+		g.serveEntry(w, r, service, fetch.entry, status)
+		return
+	}
+
+	defer fetch.release()
+	defer fetch.resp.Body.Close()
+	w.Header().Set("X-Cache", "MISS")
+	g.writeResponse(w, r, service, fetch.resp)
+}
+
+// refreshCache revalidates a stale entry in the background.
+func (g *Gateway) refreshCache(r *http.Request, service *GatewayServiceConfig, key string, stale *CacheEntry) {
+	defer service.cache.end(key)
+
+	timeout := defaultCacheRefreshTimeout
+	if service.limits != nil && service.limits.timeout > 0 {
+		timeout = service.limits.timeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	fetch, err := g.fetchCacheable(r.WithContext(ctx), service, key, stale)
+	if err != nil {
+		g.log.Sugar().Infof("Failed to refresh cached response %s: %v", key, err)
+		return
+	}
+	if fetch.entry == nil {
+		// The upstream no longer allows the response to be cached
+		fetch.resp.Body.Close()
+		fetch.release()
+		service.cache.store.Delete(stale.Key)
+	}
+}
+
+// cacheFetch is the outcome of fetchCacheable: either a stored entry, or the upstream
+// response with the release function from forward when it could not be stored.
+type cacheFetch struct {
+	entry       *CacheEntry
+	revalidated bool
+	resp        *http.Response
+	release     func()
+}
+
+// fetchCacheable requests r from the upstream, asking it to revalidate stale if given,
+// and saves the response under key when it can be stored.
+func (g *Gateway) fetchCacheable(r *http.Request, service *GatewayServiceConfig, key string, stale *CacheEntry) (*cacheFetch, error) {
+	cache := service.cache
+	// The client's own validators are answered from the stored response, not by the upstream
+	req := r.Clone(r.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if stale != nil {
+		if etag := stale.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := stale.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, release, err := g.forward(req, service)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stale != nil && resp.StatusCode == http.StatusNotModified {
+		defer release()
+		defer resp.Body.Close()
+
+		// Refresh the stored headers, keeping the stored body
+		header := stale.Header.Clone()
+		for name, values := range resp.Header {
+			if name != "Content-Length" {
+				header[name] = values
+			}
+		}
+		refreshed := &http.Response{StatusCode: stale.Status, Header: header}
+		stored, expires, staleUntil, ok := cache.freshness(r, refreshed, now)
+		entry := &CacheEntry{Status: stale.Status, Header: header, Body: stale.Body, Stored: stored, Expires: expires, StaleUntil: staleUntil}
+		if ok {
+			cache.save(key, r, entry)
+		}
+		return &cacheFetch{entry: entry, revalidated: true}, nil
+	}
+
+	stored, expires, staleUntil, ok := cache.freshness(r, resp, now)
+	if !ok || r.Method != http.MethodGet {
+		return &cacheFetch{resp: resp, release: release}, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cache.maxEntrySize+1))
+	if err != nil {
+		resp.Body.Close()
+		release()
+		return nil, err
+	}
+	if int64(len(body)) > cache.maxEntrySize {
+		// Too large to store: send what was read followed by the rest of the body
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return &cacheFetch{resp: resp, release: release}, nil
+	}
+	resp.Body.Close()
+	release()
+
+	entry := &CacheEntry{Status: resp.StatusCode, Header: resp.Header.Clone(), Body: body, Stored: stored, Expires: expires, StaleUntil: staleUntil}
+	cache.save(key, r, entry)
+	return &cacheFetch{entry: entry}, nil
+}
+
+// serveEntry sends a stored response, answering the client's validators with 304 when they match.
+func (g *Gateway) serveEntry(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig, entry *CacheEntry, status string) {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	w.Header().Set("X-Cache", status)
+
+	resp := &http.Response{StatusCode: entry.Status, Header: header, ContentLength: int64(len(entry.Body)), Body: io.NopCloser(bytes.NewReader(entry.Body))}
+	if entry.Status == http.StatusOK && notModified(r, header) {
+		for name := range header {
+			switch name {
+			case "Cache-Control", "Date", "Etag", "Expires", "Vary", "Age", "Last-Modified":
+			default:
+				header.Del(name)
+			}
+		}
+		resp = &http.Response{StatusCode: http.StatusNotModified, Header: header, Body: http.NoBody}
+	}
+	g.writeResponse(w, r, service, resp)
+}
+
+// notModified reports whether the client's conditional headers match the stored response.
+func notModified(r *http.Request, header http.Header) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ifModifiedSince)
+}
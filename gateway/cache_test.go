@@ -0,0 +1,384 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// createCachingGateway returns a gateway whose service1 caches responses from handler.
+func createCachingGateway(t *testing.T, config *CacheConfig, handler http.HandlerFunc) *Gateway {
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	g := createTestGateway("")
+	g.cacheStore = NewMemoryCacheStore(defaultCacheMaxBytes)
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		cache:            NewResponseCache(config, g.cacheStore, g.log),
+	}
+	return g
+}
+
+// get sends a GET request through the gateway with the given header pairs.
+func get(g *Gateway, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, req)
+	return rr
+}
+
+func TestResponseCache_Freshness(t *testing.T) {
+	cache := NewResponseCache(&CacheConfig{StaleWhileRevalidate: 5 * time.Second}, NewMemoryCacheStore(defaultCacheMaxBytes), nil)
+	now := time.Now()
+
+	cases := []struct {
+		name      string
+		header    http.Header
+		status    int
+		auth      bool
+		storable  bool
+		ttl       time.Duration
+		staleTime time.Duration
+	}{
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, 200, false, true, time.Minute, 5 * time.Second},
+		{"s-maxage wins", http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, 200, false, true, 2 * time.Minute, 5 * time.Second},
+		{"age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, 200, false, true, 40 * time.Second, 5 * time.Second},
+		{"stale-while-revalidate", http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=30"}}, 200, false, true, time.Minute, 30 * time.Second},
+		{"must-revalidate", http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}, 200, false, true, time.Minute, 0},
+		{"expires", http.Header{"Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)}, "Date": {now.UTC().Format(http.TimeFormat)}}, 200, false, true, time.Hour, 5 * time.Second},
+		{"no-cache with validator", http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}}, 200, false, true, 0, 0},
+		{"no-cache without validator", http.Header{"Cache-Control": {"no-cache"}}, 200, false, false, 0, 0},
+		{"no freshness", http.Header{}, 200, false, false, 0, 0},
+		{"no-store", http.Header{"Cache-Control": {"max-age=60, no-store"}}, 200, false, false, 0, 0},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, 200, false, false, 0, 0},
+		{"set-cookie", http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, 200, false, false, 0, 0},
+		{"vary star", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, 200, false, false, 0, 0},
+		{"server error", http.Header{"Cache-Control": {"max-age=60"}}, 500, false, false, 0, 0},
+		{"authorized", http.Header{"Cache-Control": {"max-age=60"}}, 200, true, false, 0, 0},
+		{"authorized public", http.Header{"Cache-Control": {"public, max-age=60"}}, 200, true, true, time.Minute, 5 * time.Second},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		if c.auth {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		stored, expires, staleUntil, ok := cache.freshness(req, &http.Response{StatusCode: c.status, Header: c.header}, now)
+		if ok != c.storable {
+			t.Errorf("%s: Expected storable %v, got %v", c.name, c.storable, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if ttl := expires.Sub(now); ttl < c.ttl-time.Second || ttl > c.ttl+time.Second {
+			t.Errorf("%s: Expected ttl %v, got %v", c.name, c.ttl, ttl)
+		}
+		if stale := staleUntil.Sub(expires); stale != c.staleTime {
+			t.Errorf("%s: Expected stale-while-revalidate %v, got %v", c.name, c.staleTime, stale)
+		}
+		if stored.After(now) {
+			t.Errorf("%s: Expected stored time not after now", c.name)
+		}
+	}
+
+	identified := httptest.NewRequest("GET", "/service1", nil)
+	identified = identified.WithContext(withIdentity(identified.Context(), &Identity{Subject: "alice"}))
+	if _, _, _, ok := cache.freshness(identified, &http.Response{StatusCode: 200, Header: http.Header{"Cache-Control": {"max-age=60, s-maxage=60"}}}, now); ok {
+		t.Errorf("Expected responses to identified callers not to be stored without public")
+	}
+	if _, _, _, ok := cache.freshness(identified, &http.Response{StatusCode: 200, Header: http.Header{"Cache-Control": {"public, max-age=60"}}}, now); !ok {
+		t.Errorf("Expected public responses to identified callers to be stored")
+	}
+
+	override := NewResponseCache(&CacheConfig{TTL: 10 * time.Minute}, NewMemoryCacheStore(defaultCacheMaxBytes), nil)
+	_, expires, _, ok := override.freshness(httptest.NewRequest("GET", "/service1", nil), &http.Response{StatusCode: 200, Header: http.Header{"Cache-Control": {"max-age=5"}}}, now)
+	if !ok || expires.Sub(now) != 10*time.Minute {
+		t.Errorf("Expected the route ttl to override max-age, got %v", expires.Sub(now))
+	}
+}
+
+func TestRouteHandler_CacheHit(t *testing.T) {
+	var calls int32
+	g := createCachingGateway(t, &CacheConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("page " + r.URL.RawQuery))
+	})
+
+	first := get(g, "/service1?page=1")
+	second := get(g, "/service1?page=1")
+	other := get(g, "/service1?page=2")
+
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" || other.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected MISS, HIT, MISS, got %s, %s, %s", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"), other.Header().Get("X-Cache"))
+	}
+	if second.Body.String() != "page page=1" || second.Header().Get("Age") == "" {
+		t.Errorf("Expected the cached body with an Age header, got %q %v", second.Body.String(), second.Header())
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("Expected 2 upstream calls, got %d", calls)
+	}
+
+	notModified := get(g, "/service1?page=1", "If-None-Match", `"v1"`)
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Errorf("Expected 304 for a matching If-None-Match, got %d %q", notModified.Code, notModified.Body.String())
+	}
+
+	bypass := get(g, "/service1?page=1", "Cache-Control", "no-store")
+	if bypass.Header().Get("X-Cache") != "" || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Expected no-store requests to bypass the cache")
+	}
+}
+
+func TestRouteHandler_CacheRevalidation(t *testing.T) {
+	var calls, notModified int32
+	g := createCachingGateway(t, &CacheConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	})
+
+	get(g, "/service1")
+	rr := get(g, "/service1")
+	if rr.Header().Get("X-Cache") != "REVALIDATED" || rr.Body.String() != "body" || rr.Code != http.StatusOK {
+		t.Errorf("Expected the stored body after revalidation, got %d %q %s", rr.Code, rr.Body.String(), rr.Header().Get("X-Cache"))
+	}
+	if calls != 2 || notModified != 1 {
+		t.Errorf("Expected a conditional request answered with 304, got %d calls and %d 304s", calls, notModified)
+	}
+}
+
+func TestRouteHandler_CacheVary(t *testing.T) {
+	g := createCachingGateway(t, &CacheConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+
+	get(g, "/service1", "Accept-Language", "en")
+	get(g, "/service1", "Accept-Language", "fr")
+	en := get(g, "/service1", "Accept-Language", "en")
+	fr := get(g, "/service1", "Accept-Language", "fr")
+	if en.Body.String() != "en" || fr.Body.String() != "fr" || en.Header().Get("X-Cache") != "HIT" || fr.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected a cached variant per language, got %q (%s) and %q (%s)", en.Body.String(), en.Header().Get("X-Cache"), fr.Body.String(), fr.Header().Get("X-Cache"))
+	}
+}
+
+func TestRouteHandler_CacheRefreshTimeout(t *testing.T) {
+	var version int32
+	release := make(chan struct{})
+	defer close(release)
+	g := createCachingGateway(t, &CacheConfig{TTL: time.Millisecond, StaleWhileRevalidate: time.Minute}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&version, 1) > 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("1"))
+	})
+	limits, err := NewRequestLimits(&LimitsConfig{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g.serviceRegistry["service1"].limits = limits
+
+	get(g, "/service1")
+	time.Sleep(5 * time.Millisecond)
+	get(g, "/service1")
+	// A hanging refresh gives up after the timeout, letting the next stale hit refresh again
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&version) < 3 {
+		get(g, "/service1")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v := atomic.LoadInt32(&version); v < 3 {
+		t.Errorf("Expected a hanging refresh to time out, got %d upstream requests", v)
+	}
+}
+
+func TestRouteHandler_CacheWaiterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	g := createCachingGateway(t, &CacheConfig{TTL: time.Minute}, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+
+	go get(g, "/service1")
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("GET", "/service1", nil).WithContext(ctx))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status %d for a waiter that gave up, got %d", http.StatusGatewayTimeout, rr.Code)
+	}
+}
+
+func TestRouteHandler_CacheStaleWhileRevalidate(t *testing.T) {
+	var version int32
+	refreshed := make(chan struct{}, 1)
+	g := createCachingGateway(t, &CacheConfig{TTL: time.Millisecond, StaleWhileRevalidate: time.Minute}, func(w http.ResponseWriter, r *http.Request) {
+		v := atomic.AddInt32(&version, 1)
+		w.Write([]byte{byte('0' + v)})
+		if v == 2 {
+			refreshed <- struct{}{}
+		}
+	})
+
+	get(g, "/service1")
+	time.Sleep(5 * time.Millisecond)
+	stale := get(g, "/service1")
+	if stale.Header().Get("X-Cache") != "STALE" || stale.Body.String() != "1" {
+		t.Fatalf("Expected the stale response, got %q %s", stale.Body.String(), stale.Header().Get("X-Cache"))
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected a background refresh")
+	}
+	// Wait for the refreshed entry to be saved
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if entry, ok := g.cacheStore.Get("service1:/service1"); ok && string(entry.Body) == "2" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected the refreshed response to be cached")
+}
+
+func TestRouteHandler_CacheCoalescing(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	g := createCachingGateway(t, &CacheConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("shared"))
+	})
+
+	var wg sync.WaitGroup
+	results := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- get(g, "/service1").Body.String()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for body := range results {
+		if body != "shared" {
+			t.Errorf("Expected every request to get the shared response, got %q", body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected concurrent misses to share 1 upstream call, got %d", calls)
+	}
+}
+
+func TestRouteHandler_CacheLargeResponse(t *testing.T) {
+	payload := make([]byte, 64)
+	g := createCachingGateway(t, &CacheConfig{MaxEntrySize: 16}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(payload)
+	})
+
+	for i := 0; i < 2; i++ {
+		rr := get(g, "/service1")
+		if rr.Body.Len() != len(payload) || rr.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("Expected the full uncached response, got %d bytes %s", rr.Body.Len(), rr.Header().Get("X-Cache"))
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	service := &GatewayServiceConfig{serviceName: "serviceA"}
+	cases := []struct {
+		target string
+		host   string
+		key    string
+	}{
+		{"/serviceA/items?page=1", "", "serviceA:/serviceA/items?page=1"},
+		{"/serviceA/items", "", "serviceA:/serviceA/items"},
+		{"/export?format=csv", "reports.example.com", "serviceA://reports.example.com/export?format=csv"},
+	}
+	for _, c := range cases {
+		if key := cacheKey(httptest.NewRequest("GET", c.target, nil), service, c.host); key != c.key {
+			t.Errorf("Expected key %q for %s, got %q", c.key, c.target, key)
+		}
+	}
+}
+
+func TestRouteHandler_CacheKeyedByService(t *testing.T) {
+	g := createCachingGateway(t, &CacheConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("service1"))
+	})
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("service2"))
+	}))
+	defer other.Close()
+	g.serviceRegistry["service2"] = &GatewayServiceConfig{
+		serviceName:      "service2",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{other.URL}},
+		endpoints:        []string{other.URL},
+		cache:            NewResponseCache(&CacheConfig{}, g.cacheStore, g.log),
+	}
+	route, _ := NewRoute(&RouteConfig{Service: "service2", Match: MatchConfig{PathPrefix: "/service1", Headers: []ValueMatchConfig{{Name: "X-Version", Exact: "2"}}}})
+	g.routes = []*Route{route}
+
+	get(g, "/service1/items")
+	if rr := get(g, "/service1/items", "X-Version", "2"); rr.Body.String() != "service2" {
+		t.Errorf("Expected the response of service2 for the same path, got %q", rr.Body.String())
+	}
+	if rr := get(g, "/service1/items"); rr.Body.String() != "service1" || rr.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected the cached response of service1, got %q %s", rr.Body.String(), rr.Header().Get("X-Cache"))
+	}
+}
+
+func TestRouteHandler_CacheIdentifiedCaller(t *testing.T) {
+	var calls int32
+	g := createCachingGateway(t, &CacheConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("shared"))
+	})
+	get(g, "/service1/items")
+
+	req := httptest.NewRequest("GET", "/service1/items", nil)
+	req = req.WithContext(withIdentity(req.Context(), &Identity{Subject: "alice"}))
+	rr := httptest.NewRecorder()
+	g.serveCached(rr, req, g.serviceRegistry["service1"], "")
+	if rr.Header().Get("X-Cache") != "MISS" || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("Expected an identified caller not to get a shared entry without public, got %s after %d calls", rr.Header().Get("X-Cache"), calls)
+	}
+	if rr := get(g, "/service1/items"); rr.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected anonymous callers to keep the shared entry, got %s", rr.Header().Get("X-Cache"))
+	}
+}
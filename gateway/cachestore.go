@@ -0,0 +1,371 @@
+package gateway
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheMaxBytes bounds the response cache when no size is configured.
+const defaultCacheMaxBytes = 64 << 20
+
+// CacheEntry is a stored upstream response.
+type CacheEntry struct {
+	Key    string
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stored is when the response was received or last revalidated.
+	Stored time.Time
+	// Expires is when the response stops being fresh.
+	Expires time.Time
+	// StaleUntil is how long a stale response may still be served while it is revalidated.
+	StaleUntil time.Time
+	// Vary lists the request headers selecting between variants. Entries with Vary set
+	// only point to their variants and carry no response.
+	Vary []string
+}
+
+// size approximates the memory held by the entry.
+func (e *CacheEntry) size() int64 {
+	size := int64(len(e.Key) + len(e.Body))
+	for header, values := range e.Header {
+		size += int64(len(header))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	for _, header := range e.Vary {
+		size += int64(len(header))
+	}
+	return size
+}
+
+// newCacheStore creates the response cache store described by config.
+func newCacheStore(config CacheStoreConfig) (CacheStore, error) {
+	maxBytes := config.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxBytes
+	}
+
+	switch config.Type {
+	case "", "memory":
+		return NewMemoryCacheStore(maxBytes), nil
+	case "disk":
+		return NewDiskCacheStore(config.Dir, maxBytes)
+	default:
+		return nil, fmt.Errorf("unknown cache store type %q", config.Type)
+	}
+}
+
+// lruIndex tracks the size of stored keys and evicts the least recently used past maxBytes.
+type lruIndex struct {
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	size  int64
+	entry *CacheEntry
+}
+
+func newLRUIndex(maxBytes int64) *lruIndex {
+	return &lruIndex{maxBytes: maxBytes, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the item for key and marks it as recently used.
+func (l *lruIndex) get(key string) (*lruItem, bool) {
+	element, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(element)
+	return element.Value.(*lruItem), true
+}
+
+// add stores item and returns the keys evicted to make room for it.
+// Items larger than the whole index are not stored and are returned as evicted.
+func (l *lruIndex) add(item *lruItem) []string {
+	l.remove(item.key)
+	if item.size > l.maxBytes {
+		return []string{item.key}
+	}
+
+	l.items[item.key] = l.order.PushFront(item)
+	l.size += item.size
+	var evicted []string
+	for l.size > l.maxBytes {
+		oldest := l.order.Back().Value.(*lruItem)
+		l.remove(oldest.key)
+		evicted = append(evicted, oldest.key)
+	}
+	return evicted
+}
+
+func (l *lruIndex) remove(key string) bool {
+	element, ok := l.items[key]
+	if !ok {
+		return false
+	}
+	l.order.Remove(element)
+	delete(l.items, key)
+	l.size -= element.Value.(*lruItem).size
+	return true
+}
+
+// removePrefix removes all keys starting with prefix and returns them.
+func (l *lruIndex) removePrefix(prefix string) []string {
+	var removed []string
+	for key := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			l.remove(key)
+			removed = append(removed, key)
+		}
+	}
+	return removed
+}
+
+type MemoryCacheStore struct {
+	index  *lruIndex
+	closed bool
+	mux    sync.Mutex
+}
+
+// NewMemoryCacheStore initializes a MemoryCacheStore holding at most maxBytes of responses.
+func NewMemoryCacheStore(maxBytes int64) *MemoryCacheStore {
+	return &MemoryCacheStore{index: newLRUIndex(maxBytes)}
+}
+
+// Get returns the entry stored under key.
+func (ms *MemoryCacheStore) Get(key string) (*CacheEntry, bool) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	item, ok := ms.index.get(key)
+	if !ok {
+		return nil, false
+	}
+	return item.entry, true
+}
+
+// Set stores entry under key, evicting the least recently used entries past the size bound.
+func (ms *MemoryCacheStore) Set(key string, entry *CacheEntry) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if ms.closed {
+		return
+	}
+	ms.index.add(&lruItem{key: key, size: entry.size(), entry: entry})
+}
+
+// Delete removes the entry stored under key.
+func (ms *MemoryCacheStore) Delete(key string) bool {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	return ms.index.remove(key)
+}
+
+// Purge removes all entries whose key starts with prefix and returns how many were removed.
+func (ms *MemoryCacheStore) Purge(prefix string) int {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	return len(ms.index.removePrefix(prefix))
+}
+
+// Close releases the stored responses. Requests still in flight on a replaced store
+// no longer fill it.
+func (ms *MemoryCacheStore) Close() error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	ms.closed = true
+	ms.index = newLRUIndex(ms.index.maxBytes)
+	return nil
+}
+
+// DiskCacheStore keeps responses in files under a directory, one per key, so they survive restarts.
+// An in-memory index of keys and sizes bounds the directory by bytes.
+type DiskCacheStore struct {
+	dir    string
+	index  *lruIndex
+	closed bool
+	mux    sync.Mutex
+}
+
+// NewDiskCacheStore initializes a DiskCacheStore in dir, indexing the entries already there.
+func NewDiskCacheStore(dir string, maxBytes int64) (*DiskCacheStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("disk cache store requires a dir")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	ds := &DiskCacheStore{dir: dir, index: newLRUIndex(maxBytes)}
+	files, err := filepath.Glob(filepath.Join(dir, "*.entry"))
+	if err != nil {
+		return nil, err
+	}
+	// Index oldest first so the most recently written entries are the last to be evicted
+	type storedFile struct {
+		path    string
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var stored []storedFile
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		entry, err := readCacheEntry(path)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		stored = append(stored, storedFile{path: path, key: entry.Key, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].modTime.Before(stored[j].modTime) })
+	for _, file := range stored {
+		for _, evicted := range ds.index.add(&lruItem{key: file.key, size: file.size}) {
+			os.Remove(ds.path(evicted))
+		}
+	}
+	return ds, nil
+}
+
+// path returns the file holding key.
+func (ds *DiskCacheStore) path(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return filepath.Join(ds.dir, hex.EncodeToString(digest[:])+".entry")
+}
+
+// Get returns the entry stored under key.
+func (ds *DiskCacheStore) Get(key string) (*CacheEntry, bool) {
+	ds.mux.Lock()
+	_, ok := ds.index.get(key)
+	ds.mux.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	entry, err := readCacheEntry(ds.path(key))
+	if err != nil || entry.Key != key {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set stores entry under key, evicting the least recently used entries past the size bound.
+func (ds *DiskCacheStore) Set(key string, entry *CacheEntry) {
+	ds.mux.Lock()
+	closed := ds.closed
+	ds.mux.Unlock()
+	if closed {
+		return
+	}
+
+	entry.Key = key
+	size, err := writeCacheEntry(ds.dir, ds.path(key), entry)
+	if err != nil {
+		return
+	}
+
+	ds.mux.Lock()
+	defer ds.mux.Unlock()
+
+	if ds.closed {
+		return
+	}
+	for _, evicted := range ds.index.add(&lruItem{key: key, size: size}) {
+		os.Remove(ds.path(evicted))
+	}
+}
+
+// Delete removes the entry stored under key.
+func (ds *DiskCacheStore) Delete(key string) bool {
+	ds.mux.Lock()
+	defer ds.mux.Unlock()
+
+	if !ds.index.remove(key) {
+		return false
+	}
+	os.Remove(ds.path(key))
+	return true
+}
+
+// Purge removes all entries whose key starts with prefix and returns how many were removed.
+func (ds *DiskCacheStore) Purge(prefix string) int {
+	ds.mux.Lock()
+	defer ds.mux.Unlock()
+
+	removed := ds.index.removePrefix(prefix)
+	for _, key := range removed {
+		os.Remove(ds.path(key))
+	}
+	return len(removed)
+}
+
+// Close stops the store from writing or evicting files, which stay in place for a store
+// opened on the same directory.
+func (ds *DiskCacheStore) Close() error {
+	ds.mux.Lock()
+	defer ds.mux.Unlock()
+
+	ds.closed = true
+	ds.index = newLRUIndex(ds.index.maxBytes)
+	return nil
+}
+
+func readCacheEntry(path string) (*CacheEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entry CacheEntry
+	if err := gob.NewDecoder(file).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// writeCacheEntry atomically replaces the file at path with entry and returns its size.
+func writeCacheEntry(dir, path string, entry *CacheEntry) (int64, error) {
+	file, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(entry); err != nil {
+		file.Close()
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(file.Name(), path)
+}
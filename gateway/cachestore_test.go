@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testEntry(body string) *CacheEntry {
+	return &CacheEntry{
+		Status:  http.StatusOK,
+		Header:  http.Header{"Content-Type": {"text/plain"}},
+		Body:    []byte(body),
+		Stored:  time.Now(),
+		Expires: time.Now().Add(time.Minute),
+	}
+}
+
+func TestMemoryCacheStore_Eviction(t *testing.T) {
+	entrySize := testEntry("0123456789").size() + int64(len("/a"))
+	store := NewMemoryCacheStore(2 * entrySize)
+
+	store.Set("/a", testEntry("0123456789"))
+	store.Set("/b", testEntry("0123456789"))
+	// Reading /a makes /b the least recently used
+	if _, ok := store.Get("/a"); !ok {
+		t.Fatalf("Expected /a to be cached")
+	}
+	store.Set("/c", testEntry("0123456789"))
+
+	if _, ok := store.Get("/b"); ok {
+		t.Errorf("Expected /b to be evicted")
+	}
+	for _, key := range []string{"/a", "/c"} {
+		if _, ok := store.Get(key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+
+	store.Set("/huge", testEntry(string(make([]byte, 3*entrySize))))
+	if _, ok := store.Get("/huge"); ok {
+		t.Errorf("Expected an entry larger than the store not to be cached")
+	}
+}
+
+func TestMemoryCacheStore_Purge(t *testing.T) {
+	store := NewMemoryCacheStore(defaultCacheMaxBytes)
+	for _, key := range []string{"/serviceA/1", "/serviceA/2", "/serviceB/1"} {
+		store.Set(key, testEntry(key))
+	}
+
+	if purged := store.Purge("/serviceA/"); purged != 2 {
+		t.Errorf("Expected 2 purged entries, got %d", purged)
+	}
+	if _, ok := store.Get("/serviceB/1"); !ok {
+		t.Errorf("Expected /serviceB/1 to remain cached")
+	}
+	if !store.Delete("/serviceB/1") || store.Delete("/serviceB/1") {
+		t.Errorf("Expected Delete to report whether the key was cached")
+	}
+}
+
+func TestDiskCacheStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskCacheStore(dir, defaultCacheMaxBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.Set("/serviceA/1", testEntry("one"))
+	store.Set("/serviceA/2", testEntry("two"))
+	store.Set("/serviceB/1", testEntry("three"))
+	store.Purge("/serviceA/2")
+
+	// Entries survive a restart
+	reopened, err := NewDiskCacheStore(dir, defaultCacheMaxBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entry, ok := reopened.Get("/serviceA/1")
+	if !ok || !bytes.Equal(entry.Body, []byte("one")) || entry.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected /serviceA/1 to be read back from disk, got %+v", entry)
+	}
+	if _, ok := reopened.Get("/serviceA/2"); ok {
+		t.Errorf("Expected purged /serviceA/2 to stay purged")
+	}
+	if purged := reopened.Purge("/serviceB/"); purged != 1 {
+		t.Errorf("Expected 1 purged entry, got %d", purged)
+	}
+
+	// The size bound also applies to the directory
+	small, err := NewDiskCacheStore(t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	small.Set("/a", testEntry("a"))
+	if _, ok := small.Get("/a"); ok {
+		t.Errorf("Expected an entry larger than the store not to be cached")
+	}
+
+	if _, err := newCacheStore(CacheStoreConfig{Type: "disk"}); err == nil {
+		t.Errorf("Expected an error for a disk store without a dir")
+	}
+	if _, err := newCacheStore(CacheStoreConfig{Type: "tape"}); err == nil {
+		t.Errorf("Expected an error for an unknown store type")
+	}
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// clientAddress is the resolved origin of a request.
+type clientAddress struct {
+	ip string
+	// port is the client's source port when the client is the immediate peer.
+	port int
+	// forwardedFor is the X-Forwarded-For value passed upstream.
+	forwardedFor string
+}
+
+type clientAddressKey struct{}
+
+// clientIP returns the address of the client that sent the request, without the port.
+// Once resolveClientIP has run this is the real client behind any trusted proxies,
+// otherwise it is the immediate peer.
+func clientIP(r *http.Request) string {
+	if addr, ok := r.Context().Value(clientAddressKey{}).(*clientAddress); ok {
+		return addr.ip
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the address of the immediate peer, without the port.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// resolveClientIP returns a copy of r carrying its real client address.
+// Forwarding headers are only believed when the immediate peer is a trusted proxy;
+// the chain is then walked from the nearest hop until an untrusted address is found.
+func resolveClientIP(r *http.Request, trusted []*net.IPNet) *http.Request {
+	peer := peerIP(r)
+	addr := &clientAddress{ip: peer, forwardedFor: peer}
+	if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		addr.port, _ = strconv.Atoi(port)
+	}
+
+	if inNetworks(net.ParseIP(peer), trusted) {
+		hops := forwardedFor(r.Header)
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(hops[i])
+			if hop == nil {
+				// Obfuscated or malformed entries end the trustworthy part of the chain
+				break
+			}
+			addr.ip, addr.port = hop.String(), 0
+			if !inNetworks(hop, trusted) {
+				break
+			}
+		}
+		if len(hops) > 0 {
+			addr.forwardedFor = strings.Join(hops, ", ") + ", " + peer
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), clientAddressKey{}, addr))
+}
+
+// forwardedFor returns the client addresses recorded by proxies, nearest proxy last.
+// The standard Forwarded header is preferred over X-Forwarded-For.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				hop := ""
+				for _, pair := range strings.Split(element, ";") {
+					key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(key, "for") {
+						hop = stripPort(strings.Trim(val, `"`))
+					}
+				}
+				hops = append(hops, hop)
+			}
+		}
+		return hops
+	}
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, stripPort(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// stripPort removes the port and IPv6 brackets from a node identifier.
+func stripPort(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPFilter initializes an IPFilter from config.
+func NewIPFilter(config *IPFilterConfig) (*IPFilter, error) {
+	allow, err := parseCIDRs(config.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("ipFilter: allow: %w", err)
+	}
+	deny, err := parseCIDRs(config.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("ipFilter: deny: %w", err)
+	}
+	return &IPFilter{allow: allow, deny: deny}, nil
+}
+
+// Allowed reports whether ip may access the gateway. Deny entries take precedence,
+// and a non-empty allow list admits only the addresses it contains.
+func (f *IPFilter) Allowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if inNetworks(parsed, f.deny) {
+		return false
+	}
+	return len(f.allow) == 0 || inNetworks(parsed, f.allow)
+}
+
+// parseCIDRs parses a list of CIDRs; bare IP addresses are treated as single hosts.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// inNetworks reports whether ip is in any of networks.
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
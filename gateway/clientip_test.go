@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name         string
+		remoteAddr   string
+		header       string
+		value        string
+		ip           string
+		forwardedFor string
+	}{
+		{"direct client", "203.0.113.5:4000", "", "", "203.0.113.5", "203.0.113.5"},
+		{"untrusted peer spoofs header", "203.0.113.5:4000", "X-Forwarded-For", "198.51.100.1", "203.0.113.5", "203.0.113.5"},
+		{"trusted peer", "10.0.0.2:4000", "X-Forwarded-For", "198.51.100.1", "198.51.100.1", "198.51.100.1, 10.0.0.2"},
+		{"trusted chain", "10.0.0.2:4000", "X-Forwarded-For", "6.6.6.6, 198.51.100.1, 192.168.1.1", "198.51.100.1", "6.6.6.6, 198.51.100.1, 192.168.1.1, 10.0.0.2"},
+		{"forwarded header", "10.0.0.2:4000", "Forwarded", `for=198.51.100.1;proto=https, for="[2001:db8::1]:4711"`, "2001:db8::1", "198.51.100.1, 2001:db8::1, 10.0.0.2"},
+		{"obfuscated hop", "10.0.0.2:4000", "Forwarded", "for=unknown", "10.0.0.2", "unknown, 10.0.0.2"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		req.RemoteAddr = c.remoteAddr
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		req = resolveClientIP(req, trusted)
+		if ip := clientIP(req); ip != c.ip {
+			t.Errorf("%s: Expected client IP %s, got %s", c.name, c.ip, ip)
+		}
+
+		upstream, err := newUpstreamRequest(req, "http://upstream/service1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := upstream.Header.Get("X-Forwarded-For"); got != c.forwardedFor {
+			t.Errorf("%s: Expected X-Forwarded-For %q, got %q", c.name, c.forwardedFor, got)
+		}
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	filter, err := NewIPFilter(&IPFilterConfig{Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.0.0.66"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cases := map[string]bool{
+		"10.1.2.3":    true,
+		"10.0.0.66":   false,
+		"192.0.2.1":   false,
+		"2001:db8::1": true,
+		"garbage":     false,
+	}
+	for ip, allowed := range cases {
+		if got := filter.Allowed(ip); got != allowed {
+			t.Errorf("Expected Allowed(%s) to be %v, got %v", ip, allowed, got)
+		}
+	}
+
+	denyOnly, _ := NewIPFilter(&IPFilterConfig{Deny: []string{"192.0.2.0/24"}})
+	if !denyOnly.Allowed("198.51.100.1") || denyOnly.Allowed("192.0.2.9") {
+		t.Errorf("Expected deny-only filter to admit everything except denied networks")
+	}
+
+	if _, err := NewIPFilter(&IPFilterConfig{Allow: []string{"10.0.0.0/33"}}); err == nil {
+		t.Errorf("Expected an error for an invalid CIDR")
+	}
+}
+
+func TestRouteHandler_IPFilter(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-For")))
+	}))
+	defer upstream.Close()
+
+	g := createTestGateway("")
+	g.trustedProxies, _ = parseCIDRs([]string{"10.0.0.1"})
+	g.ipFilter, _ = NewIPFilter(&IPFilterConfig{Deny: []string{"203.0.113.0/24"}})
+	serviceFilter, _ := NewIPFilter(&IPFilterConfig{Allow: []string{"198.51.100.0/24"}})
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		ipFilter:         serviceFilter,
+	}
+
+	cases := []struct {
+		remoteAddr string
+		forwarded  string
+		status     int
+	}{
+		{"198.51.100.7:4000", "", http.StatusOK},
+		{"203.0.113.7:4000", "", http.StatusForbidden},
+		{"192.0.2.1:4000", "", http.StatusForbidden},
+		{"10.0.0.1:4000", "198.51.100.7", http.StatusOK},
+		{"10.0.0.1:4000", "203.0.113.7", http.StatusForbidden},
+		{"192.0.2.1:4000", "198.51.100.7", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		req.RemoteAddr = c.remoteAddr
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		rr := httptest.NewRecorder()
+		g.routeHandler(rr, req)
+		if rr.Code != c.status {
+			t.Errorf("Expected status %d for %s via %s, got %d", c.status, c.forwarded, c.remoteAddr, rr.Code)
+		}
+	}
+}
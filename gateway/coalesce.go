@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCoalesceKey collapses requests for the same path and query.
+	defaultCoalesceKey = "${path}?${query}"
+	// defaultCoalesceMaxResponseSize bounds the response buffered for waiters when none is configured.
+	defaultCoalesceMaxResponseSize = 1 << 20
+	// defaultCoalesceTimeout bounds a shared upstream request of a service without a timeout
+	// limit, as it is not cancelled when the leading client disconnects.
+	defaultCoalesceTimeout = 30 * time.Second
+)
+
+// sharedResponse is an upstream response fanned out to coalesced requests.
+type sharedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// coalescedCall is an upstream request shared by concurrent identical requests.
+type coalescedCall struct {
+	done chan struct{}
+	// Exactly one of resp and err is set when the response could be shared;
+	// both are nil when the waiters must send their own request.
+	resp *sharedResponse
+	err  error
+}
+
+type Coalescer struct {
+	key headerTemplate
+	// keyConfigured is set when the key was configured rather than defaulted
+	keyConfigured   bool
+	maxResponseSize int64
+	calls           map[string]*coalescedCall
+	mux             sync.Mutex
+}
+
+// NewCoalescer initializes a Coalescer from config.
+func NewCoalescer(config *CoalesceConfig) (*Coalescer, error) {
+	key := config.Key
+	if key == "" {
+		key = defaultCoalesceKey
+	}
+	template, err := parseHeaderTemplate(key)
+	if err != nil {
+		return nil, fmt.Errorf("coalesce: key: %w", err)
+	}
+
+	maxResponseSize := config.MaxResponseSize
+	if maxResponseSize <= 0 {
+		maxResponseSize = defaultCoalesceMaxResponseSize
+	}
+	return &Coalescer{key: template, keyConfigured: config.Key != "", maxResponseSize: maxResponseSize, calls: make(map[string]*coalescedCall)}, nil
+}
+
+// join returns the call in progress for key, or registers a new one and reports that the
+// caller leads it.
+func (c *Coalescer) join(key string) (*coalescedCall, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if call, ok := c.calls[key]; ok {
+		return call, false
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+// finish publishes the outcome of the call led for key to its waiters.
+func (c *Coalescer) finish(key string, call *coalescedCall, resp *sharedResponse, err error) {
+	c.mux.Lock()
+	delete(c.calls, key)
+	c.mux.Unlock()
+
+	call.resp, call.err = resp, err
+	close(call.done)
+}
+
+// callerSpecific reports whether r is sent on behalf of a caller, whose response may not be
+// shared with other callers.
+func callerSpecific(r *http.Request) bool {
+	return identityFromContext(r.Context()) != nil || r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// sharableVary reports whether a response varies on nothing but Accept-Encoding, which is
+// part of the coalescing key.
+func sharableVary(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" && !strings.EqualFold(field, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// privateResponse reports whether a response is meant for its caller alone.
+func privateResponse(header http.Header) bool {
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	_, private := directives["private"]
+	_, noStore := directives["no-store"]
+	return private || noStore || header.Get("Set-Cookie") != ""
+}
+
+// serveCoalesced forwards r unless an identical request is already in flight,
+// in which case it waits for that request's response.
+func (g *Gateway) serveCoalesced(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) {
+	coalescer := service.coalescer
+	// The default key does not tell callers apart, so their requests are sent on their own
+	if !coalescer.keyConfigured && callerSpecific(r) {
+		g.proxy(w, r, service)
+		return
+	}
+	// Endpoints may encode the body for the leader, so only callers accepting the same
+	// encodings share it
+	key := coalescer.key.render(r, service.serviceName) + varySeparator + r.Header.Get("Accept-Encoding")
+
+	call, leader := coalescer.join(key)
+	if !leader {
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			g.waitFailed(w, service.serviceName, r.Context().Err())
+			return
+		}
+		switch {
+		case call.err != nil:
+			g.forwardFailed(w, service.serviceName, call.err)
+		case call.resp != nil:
+			g.log.Sugar().Infof("Sharing coalesced response for %s on service %s", key, service.serviceName)
+			g.writeShared(w, r, service, call.resp)
+		default:
+			g.proxy(w, r, service)
+		}
+		return
+	}
+
+	// The shared request outlives the leader's client, so its disconnect does not fail the waiters
+	timeout := defaultCoalesceTimeout
+	if service.limits != nil && service.limits.timeout > 0 {
+		timeout = service.limits.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), timeout)
+	defer cancel()
+	resp, release, err := g.forward(r.WithContext(ctx), service)
+	if err != nil {
+		coalescer.finish(key, call, nil, err)
+		g.forwardFailed(w, service.serviceName, err)
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if !sharableVary(resp.Header) || privateResponse(resp.Header) {
+		// The response depends on request headers outside the key, or belongs to the leader
+		coalescer.finish(key, call, nil, nil)
+		g.writeResponse(w, r, service, resp)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, coalescer.maxResponseSize+1))
+	if err != nil || int64(len(body)) > coalescer.maxResponseSize {
+		// Waiters send their own requests rather than wait for a body that cannot be shared
+		coalescer.finish(key, call, nil, nil)
+		if err != nil {
+			g.forwardFailed(w, service.serviceName, err)
+			return
+		}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		g.writeResponse(w, r, service, resp)
+		return
+	}
+
+	shared := &sharedResponse{status: resp.StatusCode, header: resp.Header, body: body}
+	coalescer.finish(key, call, shared, nil)
+	g.writeShared(w, r, service, shared)
+}
+
+// writeShared sends a copy of a shared response.
+func (g *Gateway) writeShared(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig, shared *sharedResponse) {
+	g.writeResponse(w, r, service, &http.Response{
+		StatusCode:    shared.status,
+		Header:        shared.header.Clone(),
+		ContentLength: int64(len(shared.body)),
+		Body:          io.NopCloser(bytes.NewReader(shared.body)),
+	})
+}
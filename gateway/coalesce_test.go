@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// createCoalescingGateway returns a gateway whose service1 coalesces requests to handler.
+func createCoalescingGateway(t *testing.T, config *CoalesceConfig, handler http.HandlerFunc) *Gateway {
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	coalescer, err := NewCoalescer(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		coalescer:        coalescer,
+	}
+	return g
+}
+
+// concurrentGets sends n concurrent requests built by newRequest once the upstream is blocked,
+// then unblocks it and returns the responses.
+func concurrentGets(g *Gateway, n int, release chan struct{}, newRequest func(i int) *http.Request) []*httptest.ResponseRecorder {
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = httptest.NewRecorder()
+			g.routeHandler(responses[i], newRequest(i))
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return responses
+}
+
+func TestRouteHandler_Coalescing(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	g := createCoalescingGateway(t, &CoalesceConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"config":true}`))
+	})
+
+	responses := concurrentGets(g, 10, release, func(i int) *http.Request {
+		return httptest.NewRequest("GET", "/service1/config", nil)
+	})
+	for _, rr := range responses {
+		if rr.Code != http.StatusOK || rr.Body.String() != `{"config":true}` || rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Expected every request to get the shared response, got %d %q", rr.Code, rr.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 upstream call, got %d", calls)
+	}
+}
+
+func TestRouteHandler_CoalescingKeyTemplate(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	g := createCoalescingGateway(t, &CoalesceConfig{Key: "${path}|${header:X-Tenant}"}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte(r.Header.Get("X-Tenant")))
+	})
+
+	tenants := []string{"a", "b", "a", "b", "a", "b"}
+	responses := concurrentGets(g, len(tenants), release, func(i int) *http.Request {
+		req := httptest.NewRequest("GET", "/service1/config", nil)
+		req.Header.Set("X-Tenant", tenants[i])
+		return req
+	})
+	for i, rr := range responses {
+		if rr.Body.String() != tenants[i] {
+			t.Errorf("Expected tenant %s to get its own response, got %q", tenants[i], rr.Body.String())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected 1 upstream call per tenant, got %d", calls)
+	}
+}
+
+func TestRouteHandler_CoalescingLargeResponse(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	payload := make([]byte, 64)
+	g := createCoalescingGateway(t, &CoalesceConfig{MaxResponseSize: 16}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		w.Write(payload)
+	})
+
+	responses := concurrentGets(g, 3, release, func(i int) *http.Request {
+		return httptest.NewRequest("GET", "/service1/download", nil)
+	})
+	for _, rr := range responses {
+		if rr.Body.Len() != len(payload) {
+			t.Errorf("Expected the full response, got %d bytes", rr.Body.Len())
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Expected waiters to send their own requests for a response too large to share, got %d calls", calls)
+	}
+}
+
+func TestRouteHandler_CoalescingSeparatesCallers(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	g := createCoalescingGateway(t, &CoalesceConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			w.Write([]byte(auth))
+			return
+		}
+		w.Write([]byte(r.Header.Get("Accept-Encoding")))
+	})
+
+	// Two callers with credentials and two anonymous requests accepting different encodings
+	headers := []http.Header{
+		{"Authorization": {"Bearer a"}},
+		{"Authorization": {"Bearer b"}},
+		{"Accept-Encoding": {"gzip"}},
+		{"Accept-Encoding": {"identity"}},
+	}
+	responses := concurrentGets(g, len(headers), release, func(i int) *http.Request {
+		req := httptest.NewRequest("GET", "/service1/me", nil)
+		req.Header = headers[i]
+		return req
+	})
+	expected := []string{"Bearer a", "Bearer b", "gzip", "identity"}
+	for i, rr := range responses {
+		if rr.Body.String() != expected[i] {
+			t.Errorf("Expected request %d to get its own response %q, got %q", i, expected[i], rr.Body.String())
+		}
+	}
+	if calls != 4 {
+		t.Errorf("Expected 4 upstream calls, got %d", calls)
+	}
+}
+
+func TestRouteHandler_CoalescingVary(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	g := createCoalescingGateway(t, &CoalesceConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+
+	languages := []string{"en", "fr"}
+	responses := concurrentGets(g, len(languages), release, func(i int) *http.Request {
+		req := httptest.NewRequest("GET", "/service1/page", nil)
+		req.Header.Set("Accept-Language", languages[i])
+		return req
+	})
+	for i, rr := range responses {
+		if rr.Body.String() != languages[i] {
+			t.Errorf("Expected a response in %s, got %q", languages[i], rr.Body.String())
+		}
+	}
+}
+
+func TestRouteHandler_CoalescingPrivate(t *testing.T) {
+	for _, header := range []struct{ name, value string }{
+		{"Set-Cookie", "session=leader"},
+		{"Cache-Control", "private"},
+		{"Cache-Control", "no-store"},
+	} {
+		var calls int32
+		release := make(chan struct{})
+		g := createCoalescingGateway(t, &CoalesceConfig{}, func(w http.ResponseWriter, r *http.Request) {
+			call := atomic.AddInt32(&calls, 1)
+			if call == 1 {
+				<-release
+			}
+			w.Header().Set(header.name, header.value)
+			fmt.Fprintf(w, "caller %d", call)
+		})
+
+		responses := concurrentGets(g, 2, release, func(i int) *http.Request {
+			return httptest.NewRequest("GET", "/service1/profile", nil)
+		})
+		if responses[0].Body.String() == responses[1].Body.String() {
+			t.Errorf("Expected a response with %s: %s to reach only its caller, got %q twice", header.name, header.value, responses[0].Body.String())
+		}
+	}
+}
+
+func TestRouteHandler_CoalescingWaiterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	g := createCoalescingGateway(t, &CoalesceConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+
+	go g.routeHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/service1/config", nil))
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("GET", "/service1/config", nil).WithContext(ctx))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status %d for a waiter that gave up, got %d", http.StatusGatewayTimeout, rr.Code)
+	}
+}
+
+func TestRouteHandler_CoalescingLeaderDisconnect(t *testing.T) {
+	release := make(chan struct{})
+	g := createCoalescingGateway(t, &CoalesceConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("shared"))
+	})
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		g.routeHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/service1/config", nil).WithContext(ctx))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	waiter := httptest.NewRecorder()
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+		g.routeHandler(waiter, httptest.NewRequest("GET", "/service1/config", nil))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	disconnect()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-waiterDone
+	<-leaderDone
+
+	if waiter.Code != http.StatusOK || waiter.Body.String() != "shared" {
+		t.Errorf("Expected the waiter to get the shared response after the leader left, got %d %q", waiter.Code, waiter.Body.String())
+	}
+}
+
+func TestNewCoalescer_InvalidKey(t *testing.T) {
+	if _, err := NewCoalescer(&CoalesceConfig{Key: "${nope}"}); err == nil {
+		t.Errorf("Expected an error for an invalid key template")
+	}
+}
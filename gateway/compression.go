@@ -0,0 +1,206 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// defaultCompressionMinSize is the smallest response compressed when no minimum is configured.
+	defaultCompressionMinSize = 1024
+	// brotliLevel trades some ratio for speed, since responses are compressed on the fly.
+	brotliLevel = 5
+)
+
+// defaultCompressionAlgorithms lists the encodings used when none are configured, most preferred first.
+var defaultCompressionAlgorithms = []string{"br", "zstd", "gzip"}
+
+// defaultCompressibleTypes are compressed when no content types are configured.
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/x-ndjson",
+	"image/svg+xml",
+}
+
+// flushWriter is a compressing writer that can push buffered output to the client.
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type encoder struct {
+	pool sync.Pool
+	// reset points a pooled writer at a new destination.
+	reset func(flushWriter, io.Writer)
+}
+
+var encoders = map[string]*encoder{
+	"gzip": {
+		pool:  sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }},
+		reset: func(fw flushWriter, w io.Writer) { fw.(*gzip.Writer).Reset(w) },
+	},
+	"br": {
+		pool:  sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, brotliLevel) }},
+		reset: func(fw flushWriter, w io.Writer) { fw.(*brotli.Writer).Reset(w) },
+	},
+	"zstd": {
+		pool: sync.Pool{New: func() interface{} {
+			zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return zw
+		}},
+		reset: func(fw flushWriter, w io.Writer) { fw.(*zstd.Encoder).Reset(w) },
+	},
+}
+
+type Compressor struct {
+	algorithms   []string
+	minSize      int64
+	contentTypes []string
+}
+
+// NewCompressor initializes a Compressor from config.
+func NewCompressor(config *CompressionConfig) (*Compressor, error) {
+	compressor := &Compressor{
+		algorithms:   config.Algorithms,
+		minSize:      config.MinSize,
+		contentTypes: config.ContentTypes,
+	}
+	if len(compressor.algorithms) == 0 {
+		compressor.algorithms = defaultCompressionAlgorithms
+	}
+	for _, algorithm := range compressor.algorithms {
+		if _, ok := encoders[algorithm]; !ok {
+			return nil, fmt.Errorf("compression: unsupported algorithm %q, use br, zstd or gzip", algorithm)
+		}
+	}
+	if compressor.minSize <= 0 {
+		compressor.minSize = defaultCompressionMinSize
+	}
+	if len(compressor.contentTypes) == 0 {
+		compressor.contentTypes = defaultCompressibleTypes
+	}
+	return compressor, nil
+}
+
+// Negotiate returns the encoding for the response to r, or "" to send it as is.
+// Responses that are already encoded, too small, of other content types, or without a body are not compressed.
+// Responses of unknown length are compressed, since waiting for minSize bytes would stall streams.
+func (c *Compressor) Negotiate(r *http.Request, resp *http.Response) string {
+	if r.Method == http.MethodHead || resp.StatusCode < http.StatusOK ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return ""
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Range") != "" {
+		return ""
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < c.minSize {
+		return ""
+	}
+	if !c.compressible(resp.Header.Get("Content-Type")) {
+		return ""
+	}
+	return c.accepted(r.Header.Values("Accept-Encoding"))
+}
+
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range c.contentTypes {
+		if mediaType == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// accepted returns the most preferred configured algorithm that the client accepts.
+func (c *Compressor) accepted(acceptEncoding []string) string {
+	weights := make(map[string]float64)
+	for _, value := range acceptEncoding {
+		for _, element := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(element), ";")
+			weight := 1.0
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+					weight = parsed
+				}
+			}
+			weights[strings.ToLower(strings.TrimSpace(coding))] = weight
+		}
+	}
+
+	for _, algorithm := range c.algorithms {
+		weight, ok := weights[algorithm]
+		if !ok {
+			weight, ok = weights["*"]
+		}
+		if ok && weight > 0 {
+			return algorithm
+		}
+	}
+	return ""
+}
+
+// prepareCompressedHeaders adjusts the client response headers for a body compressed with encoding.
+func prepareCompressedHeaders(header http.Header, encoding string) {
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", encoding)
+	header.Add("Vary", "Accept-Encoding")
+	// The compressed body is a different representation, so strong validators no longer hold
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+// compressWriter compresses what is written to w with a pooled encoder. Flush pushes the
+// output compressed so far to the client, so streamed responses are flushed like plain ones.
+type compressWriter struct {
+	w   io.Writer
+	fw  flushWriter
+	enc *encoder
+}
+
+// newCompressWriter returns a compressWriter for encoding; release must be called when done.
+func newCompressWriter(w io.Writer, encoding string) *compressWriter {
+	enc := encoders[encoding]
+	fw := enc.pool.Get().(flushWriter)
+	enc.reset(fw, w)
+	return &compressWriter{w: w, fw: fw, enc: enc}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	return cw.fw.Write(p)
+}
+
+func (cw *compressWriter) Flush() {
+	if cw.fw.Flush() != nil {
+		return
+	}
+	if flush := flusherFor(cw.w); flush != nil {
+		flush()
+	}
+}
+
+// Close writes the end of the compressed stream.
+func (cw *compressWriter) Close() error {
+	return cw.fw.Close()
+}
+
+// release returns the encoder to its pool.
+func (cw *compressWriter) release() {
+	cw.enc.pool.Put(cw.fw)
+}
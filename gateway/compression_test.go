@@ -0,0 +1,316 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// decompress decodes body according to encoding.
+func decompress(t testing.TB, encoding string, body []byte) string {
+	t.Helper()
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to read gzip body: %v", err)
+		}
+		reader = gr
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to read zstd body: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return string(body)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress %s body: %v", encoding, err)
+	}
+	return string(decoded)
+}
+
+func TestCompressor_Negotiate(t *testing.T) {
+	compressor, err := NewCompressor(&CompressionConfig{Algorithms: []string{"zstd", "br", "gzip"}, MinSize: 100})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	response := func(contentType string, length int64, header ...string) *http.Response {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), ContentLength: length}
+		resp.Header.Set("Content-Type", contentType)
+		for i := 0; i+1 < len(header); i += 2 {
+			resp.Header.Set(header[i], header[i+1])
+		}
+		return resp
+	}
+
+	cases := []struct {
+		name           string
+		acceptEncoding string
+		resp           *http.Response
+		expected       string
+	}{
+		{"server preference", "gzip, br, zstd", response("application/json", 1000), "zstd"},
+		{"client subset", "gzip, br", response("text/html; charset=utf-8", 1000), "br"},
+		{"refused with q=0", "zstd;q=0, gzip;q=0.5", response("text/plain", 1000), "gzip"},
+		{"wildcard", "*", response("text/plain", 1000), "zstd"},
+		{"no accept-encoding", "", response("text/plain", 1000), ""},
+		{"too small", "gzip", response("text/plain", 99), ""},
+		{"unknown length", "gzip", response("text/event-stream", -1), "gzip"},
+		{"not compressible", "gzip", response("image/png", 1000), ""},
+		{"already encoded", "gzip", response("text/plain", 1000, "Content-Encoding", "br"), ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		if c.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", c.acceptEncoding)
+		}
+		if got := compressor.Negotiate(req, c.resp); got != c.expected {
+			t.Errorf("%s: Expected encoding %q, got %q", c.name, c.expected, got)
+		}
+	}
+
+	if _, err := NewCompressor(&CompressionConfig{Algorithms: []string{"lzma"}}); err == nil {
+		t.Errorf("Expected an error for an unsupported algorithm")
+	}
+}
+
+func TestRouteHandler_Compression(t *testing.T) {
+	payload := strings.Repeat(`{"message":"success"}`, 200)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(payload))
+	}))
+	defer upstream.Close()
+
+	g := createTestGateway("")
+	compressor, _ := NewCompressor(&CompressionConfig{})
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		compressor:       compressor,
+	}
+
+	for _, encoding := range []string{"gzip", "br", "zstd", ""} {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		rr := httptest.NewRecorder()
+		g.routeHandler(rr, req)
+
+		if got := rr.Header().Get("Content-Encoding"); got != encoding {
+			t.Errorf("Expected Content-Encoding %q, got %q", encoding, got)
+		}
+		if body := decompress(t, encoding, rr.Body.Bytes()); body != payload {
+			t.Errorf("Expected the %q body to decompress to the upstream payload", encoding)
+		}
+		if encoding != "" {
+			if rr.Body.Len() >= len(payload) {
+				t.Errorf("Expected the %s body to be smaller than %d bytes, got %d", encoding, len(payload), rr.Body.Len())
+			}
+			if got := rr.Header().Get("ETag"); got != `W/"v1"` {
+				t.Errorf("Expected a weak ETag, got %q", got)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Expected Vary: Accept-Encoding, got %q", got)
+			}
+		}
+	}
+}
+
+func TestRouteHandler_CompressedStreaming(t *testing.T) {
+	// Each event must reach the client as soon as it is written upstream
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	g := createTestGateway("")
+	compressor, _ := NewCompressor(&CompressionConfig{})
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		compressor:       compressor,
+	}
+	server := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/service1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read gzip stream: %v", err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := io.ReadAtLeast(gr, buf, len("data: first\n\n"))
+		received <- string(buf[:n])
+	}()
+	select {
+	case event := <-received:
+		if event != "data: first\n\n" {
+			t.Errorf("Expected the first event, got %q", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected the first event to be flushed before the stream ends")
+	}
+}
+
+// createCompressingGateway returns a gateway whose service1 compresses responses from upstreamURL.
+func createCompressingGateway(upstreamURL string, streaming *StreamingConfig) *Gateway {
+	g := createTestGateway("")
+	compressor, _ := NewCompressor(&CompressionConfig{MinSize: 1})
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstreamURL}},
+		endpoints:        []string{upstreamURL},
+		compressor:       compressor,
+		streaming:        streaming,
+	}
+	return g
+}
+
+func TestRouteHandler_CompressedFlushInterval(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"part":1}`))
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"part":2}`))
+	}))
+	defer upstream.Close()
+
+	// Without a flush interval the compressed body is left to the server to flush
+	for _, streaming := range []*StreamingConfig{nil, {FlushImmediately: true}} {
+		g := createCompressingGateway(upstream.URL, streaming)
+		req := httptest.NewRequest("GET", "/service1", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		g.routeHandler(rr, req)
+
+		if body := decompress(t, "gzip", rr.Body.Bytes()); body != `{"part":1}{"part":2}` {
+			t.Errorf("Expected the complete body, got %q", body)
+		}
+		if rr.Flushed != (streaming != nil) {
+			t.Errorf("Expected flushed to be %v with streaming %+v, got %v", streaming != nil, streaming, rr.Flushed)
+		}
+	}
+}
+
+func TestRouteHandler_CompressedUpstreamFailure(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte(`{"truncated":`))
+	}))
+	defer upstream.Close()
+	gateway := httptest.NewServer(http.HandlerFunc(createCompressingGateway(upstream.URL, nil).routeHandler))
+	defer gateway.Close()
+
+	// The abort surfaces on Do or on reading the body, depending on what was flushed
+	req, _ := http.NewRequest("GET", gateway.URL+"/service1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+	}
+	if err == nil {
+		t.Errorf("Expected the compressed response to be aborted when the upstream fails")
+	}
+}
+
+// benchmarkPayload is a typical JSON API response.
+var benchmarkPayload = []byte(strings.Repeat(`{"id":12345,"name":"service","tags":["a","b","c"],"enabled":true},`, 1000))
+
+func BenchmarkCopy_Plain(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkPayload)))
+	for i := 0; i < b.N; i++ {
+		if _, err := io.Copy(io.Discard, bytes.NewReader(benchmarkPayload)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkCopyCompressed(b *testing.B, encoding string) {
+	b.SetBytes(int64(len(benchmarkPayload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		compressed := newCompressWriter(io.Discard, encoding)
+		readErr, writeErr := copyResponse(compressed, bytes.NewReader(benchmarkPayload), 0)
+		if err := errors.Join(readErr, writeErr, compressed.Close()); err != nil {
+			b.Fatal(err)
+		}
+		compressed.release()
+	}
+}
+
+func BenchmarkCopy_Gzip(b *testing.B)   { benchmarkCopyCompressed(b, "gzip") }
+func BenchmarkCopy_Brotli(b *testing.B) { benchmarkCopyCompressed(b, "br") }
+func BenchmarkCopy_Zstd(b *testing.B)   { benchmarkCopyCompressed(b, "zstd") }
+
+// benchmarkRouteHandler proxies benchmarkPayload through routeHandler with the given Accept-Encoding.
+func benchmarkRouteHandler(b *testing.B, acceptEncoding string) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(benchmarkPayload)
+	}))
+	defer upstream.Close()
+
+	g := createTestGateway("")
+	compressor, _ := NewCompressor(&CompressionConfig{})
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		compressor:       compressor,
+	}
+	g.log = zap.NewNop()
+
+	b.SetBytes(int64(len(benchmarkPayload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		g.routeHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			b.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+	}
+}
+
+func BenchmarkRouteHandler_Plain(b *testing.B) { benchmarkRouteHandler(b, "identity") }
+func BenchmarkRouteHandler_Gzip(b *testing.B)  { benchmarkRouteHandler(b, "gzip") }
+func BenchmarkRouteHandler_Zstd(b *testing.B)  { benchmarkRouteHandler(b, "zstd") }
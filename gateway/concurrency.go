@@ -0,0 +1,162 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+var (
+	// ErrConcurrencyLimit is returned when the limit is reached and queueing is disabled.
+	ErrConcurrencyLimit = errors.New("concurrency limit reached")
+	// ErrQueueFull is returned when the limit is reached and the wait queue is full.
+	ErrQueueFull = errors.New("concurrency limit reached and wait queue is full")
+	// ErrQueueTimeout is returned when a queued request did not get a slot in time.
+	ErrQueueTimeout = errors.New("timed out waiting in concurrency queue")
+)
+
+// Defaults for adaptive concurrency when the config leaves them unset.
+const (
+	defaultAdaptiveBackoff = 0.9
+	defaultAdaptiveMin     = 1
+)
+
+type ConcurrencyLimiter struct {
+	limit        int
+	inFlight     int
+	waiters      []chan struct{}
+	maxQueue     int
+	queueTimeout time.Duration
+	adaptive     *AdaptiveConfig
+	mux          sync.Mutex
+	log          *log.Logger
+}
+
+// NewConcurrencyLimiter initializes a ConcurrencyLimiter allowing maxInFlight concurrent requests.
+// Up to maxQueue further requests wait for queueTimeout for a slot to free up.
+// If adaptive is set the limit moves between its bounds based on observed latency,
+// starting from and by default never exceeding maxInFlight.
+func NewConcurrencyLimiter(maxInFlight, maxQueue int, queueTimeout time.Duration, adaptive *AdaptiveConfig, log *log.Logger) *ConcurrencyLimiter {
+	if adaptive != nil && adaptive.MaxLimit <= 0 {
+		bounded := *adaptive
+		bounded.MaxLimit = maxInFlight
+		adaptive = &bounded
+	}
+	return &ConcurrencyLimiter{
+		limit:        maxInFlight,
+		maxQueue:     maxQueue,
+		queueTimeout: queueTimeout,
+		adaptive:     adaptive,
+		log:          log,
+	}
+}
+
+// Acquire takes a slot, waiting in the queue if the limit is reached.
+// Every successful Acquire must be paired with a Release.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	cl.mux.Lock()
+	if cl.inFlight < cl.limit {
+		cl.inFlight++
+		cl.mux.Unlock()
+		return nil
+	}
+	if cl.maxQueue == 0 {
+		cl.mux.Unlock()
+		return ErrConcurrencyLimit
+	}
+	if len(cl.waiters) >= cl.maxQueue {
+		cl.mux.Unlock()
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	cl.waiters = append(cl.waiters, ready)
+	cl.mux.Unlock()
+
+	var timeout <-chan time.Time
+	if cl.queueTimeout > 0 {
+		timer := time.NewTimer(cl.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	err := ErrQueueTimeout
+	select {
+	case <-ready:
+		return nil
+	case <-timeout:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	for i, waiter := range cl.waiters {
+		if waiter == ready {
+			cl.waiters = append(cl.waiters[:i], cl.waiters[i+1:]...)
+			return err
+		}
+	}
+	// The slot was handed over while we were giving up, so keep it.
+	return nil
+}
+
+// Release frees a slot and hands it to the next queued request.
+// latency and failed describe the upstream call and drive the adaptive limit.
+func (cl *ConcurrencyLimiter) Release(latency time.Duration, failed bool) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+
+	if cl.inFlight > 0 {
+		cl.inFlight--
+	}
+	if cl.adaptive != nil {
+		cl.adjustLimit(latency, failed)
+	}
+
+	for cl.inFlight < cl.limit && len(cl.waiters) > 0 {
+		ready := cl.waiters[0]
+		cl.waiters = cl.waiters[1:]
+		cl.inFlight++
+		close(ready)
+	}
+}
+
+// Limit returns the current concurrency limit.
+func (cl *ConcurrencyLimiter) Limit() int {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+
+	return cl.limit
+}
+
+// adjustLimit applies additive-increase/multiplicative-decrease to the limit:
+// slow or failed calls shrink it by the backoff ratio, healthy calls grow it by one.
+func (cl *ConcurrencyLimiter) adjustLimit(latency time.Duration, failed bool) {
+	minLimit, maxLimit := cl.adaptive.MinLimit, cl.adaptive.MaxLimit
+	if minLimit <= 0 {
+		minLimit = defaultAdaptiveMin
+	}
+
+	if failed || (cl.adaptive.LatencyThreshold > 0 && latency > cl.adaptive.LatencyThreshold) {
+		backoff := cl.adaptive.Backoff
+		if backoff <= 0 || backoff >= 1 {
+			backoff = defaultAdaptiveBackoff
+		}
+		limit := int(float64(cl.limit) * backoff)
+		if limit < minLimit {
+			limit = minLimit
+		}
+		if limit != cl.limit {
+			cl.log.Sugar().Debugf("ConcurrencyLimiter: Decreasing limit to %d (latency %v, failed %v)", limit, latency, failed)
+		}
+		cl.limit = limit
+		return
+	}
+
+	if cl.limit < maxLimit {
+		cl.limit++
+	}
+}
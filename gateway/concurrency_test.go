@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestConcurrencyLimitWithoutQueue(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	cl := NewConcurrencyLimiter(1, 0, 0, nil, logger)
+
+	if err := cl.Acquire(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := cl.Acquire(context.Background()); err != ErrConcurrencyLimit {
+		t.Errorf("Expected ErrConcurrencyLimit, but got %v", err)
+	}
+
+	cl.Release(0, false)
+	if err := cl.Acquire(context.Background()); err != nil {
+		t.Errorf("Expected slot to be free after release, but got %v", err)
+	}
+}
+
+func TestConcurrencyQueueHandover(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	cl := NewConcurrencyLimiter(1, 1, time.Second, nil, logger)
+	cl.Acquire(context.Background())
+
+	acquired := make(chan error)
+	go func() { acquired <- cl.Acquire(context.Background()) }()
+
+	// Wait for the goroutine to be queued, then a third request finds the queue full
+	for {
+		cl.mux.Lock()
+		queued := len(cl.waiters)
+		cl.mux.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := cl.Acquire(context.Background()); err != ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, but got %v", err)
+	}
+
+	cl.Release(0, false)
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected queued request to get the released slot, but got %v", err)
+	}
+	if cl.inFlight != 1 {
+		t.Errorf("Expected 1 request in flight, but got %d", cl.inFlight)
+	}
+}
+
+func TestConcurrencyQueueTimeout(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	cl := NewConcurrencyLimiter(1, 1, 10*time.Millisecond, nil, logger)
+	cl.Acquire(context.Background())
+
+	if err := cl.Acquire(context.Background()); err != ErrQueueTimeout {
+		t.Errorf("Expected ErrQueueTimeout, but got %v", err)
+	}
+	if len(cl.waiters) != 0 {
+		t.Errorf("Expected timed out request to leave the queue, but %d remain", len(cl.waiters))
+	}
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	adaptive := &AdaptiveConfig{MinLimit: 2, LatencyThreshold: 100 * time.Millisecond, Backoff: 0.5}
+	cl := NewConcurrencyLimiter(10, 0, 0, adaptive, logger)
+
+	cl.Acquire(context.Background())
+	cl.Release(200*time.Millisecond, false)
+	if cl.Limit() != 5 {
+		t.Errorf("Expected slow call to halve the limit to 5, but got %d", cl.Limit())
+	}
+
+	for i := 0; i < 3; i++ {
+		cl.Acquire(context.Background())
+		cl.Release(0, true)
+	}
+	if cl.Limit() != 2 {
+		t.Errorf("Expected failures to shrink the limit to the minimum 2, but got %d", cl.Limit())
+	}
+
+	for i := 0; i < 20; i++ {
+		cl.Acquire(context.Background())
+		cl.Release(10*time.Millisecond, false)
+	}
+	if cl.Limit() != 10 {
+		t.Errorf("Expected healthy calls to grow the limit back to 10, but got %d", cl.Limit())
+	}
+}
+
+func TestRouteHandler_Shed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	g := createTestGateway("")
+	limiter := NewConcurrencyLimiter(1, 0, 0, nil, g.log)
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+		endpoints:        []string{server.URL},
+		concurrency:      limiter,
+	}
+
+	// Occupy the only slot
+	limiter.Acquire(context.Background())
+
+	w := httptest.NewRecorder()
+	g.routeHandler(w, httptest.NewRequest("GET", "/service1", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status ServiceUnavailable, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), ErrConcurrencyLimit.Error()) {
+		t.Errorf("Expected shedding reason in body, got %q", w.Body.String())
+	}
+
+	limiter.Release(0, false)
+	w = httptest.NewRecorder()
+	g.routeHandler(w, httptest.NewRequest("GET", "/service1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", w.Code)
+	}
+	if limiter.inFlight != 0 {
+		t.Errorf("Expected slot to be released after the request, but %d in flight", limiter.inFlight)
+	}
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "go.uber.org/zap"
+)
+
+// consistentHashReplicas is the number of points each endpoint takes on the hash ring,
+// spreading keys evenly and moving few of them when endpoints change.
+const consistentHashReplicas = 100
+
+type ConsistentHash struct {
+	endpoints []string
+	ring      []uint32
+	owners    map[uint32]string
+	idx       int
+	mux       sync.RWMutex
+	log       *log.Logger
+}
+
+// NewConsistentHash initializes a ConsistentHash instance with given endpoints.
+func NewConsistentHash(endpoints []string, log *log.Logger) *ConsistentHash {
+	ch := &ConsistentHash{log: log}
+	ch.SetEndpoints(endpoints)
+	return ch
+}
+
+// EndpointFor returns the endpoint owning key on the hash ring, so the same key keeps
+// reaching the same endpoint. If there are no endpoints, it returns an empty string.
+func (ch *ConsistentHash) EndpointFor(key string) string {
+	ch.mux.RLock()
+	defer ch.mux.RUnlock()
+
+	if len(ch.ring) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i] >= hash })
+	if i == len(ch.ring) {
+		i = 0
+	}
+	endpoint := ch.owners[ch.ring[i]]
+	ch.log.Sugar().Debugf("ConsistentHash: Endpoint for key %s is: %s", key, endpoint)
+	return endpoint
+}
+
+// NextEndpoint returns the endpoints in turn for callers without a key.
+// If there are no endpoints, it returns an empty string.
+func (ch *ConsistentHash) NextEndpoint() string {
+	ch.mux.Lock()
+	defer ch.mux.Unlock()
+
+	if len(ch.endpoints) == 0 {
+		return ""
+	}
+	endpoint := ch.endpoints[ch.idx%len(ch.endpoints)]
+	ch.idx = (ch.idx + 1) % len(ch.endpoints)
+	return endpoint
+}
+
+// SetEndpoints rebuilds the hash ring for a new list of endpoints in a thread-safe manner.
+// Keys owned by endpoints that remain keep their endpoint.
+func (ch *ConsistentHash) SetEndpoints(endpoints []string) {
+	ring := make([]uint32, 0, len(endpoints)*consistentHashReplicas)
+	owners := make(map[uint32]string, len(endpoints)*consistentHashReplicas)
+	for _, endpoint := range endpoints {
+		for i := 0; i < consistentHashReplicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + endpoint))
+			if _, taken := owners[hash]; taken {
+				continue
+			}
+			owners[hash] = endpoint
+			ring = append(ring, hash)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+
+	ch.mux.Lock()
+	defer ch.mux.Unlock()
+	ch.endpoints = endpoints
+	ch.ring = ring
+	ch.owners = owners
+	ch.idx = 0
+}
+
+// nextEndpoint picks an endpoint of lb for a client, by key for load balancers that
+// support it.
+func nextEndpoint(lb LoadBalancer, key string) string {
+	if keyed, ok := lb.(KeyedLoadBalancer); ok {
+		return keyed.EndpointFor(key)
+	}
+	return lb.NextEndpoint()
+}
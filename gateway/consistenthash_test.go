@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestConsistentHash_SameKeySameEndpoint(t *testing.T) {
+	logger, _ := zap.NewProductionConfig().Build()
+	ch := NewConsistentHash([]string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53"}, logger)
+
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("192.0.2.%d", i)
+		endpoint := ch.EndpointFor(key)
+		if again := ch.EndpointFor(key); again != endpoint {
+			t.Errorf("Expected key %s to keep endpoint %s, got %s", key, endpoint, again)
+		}
+		counts[endpoint]++
+	}
+	for endpoint, count := range counts {
+		if count < 50 {
+			t.Errorf("Expected keys to spread across endpoints, %s got %d of 300", endpoint, count)
+		}
+	}
+	if len(counts) != 3 {
+		t.Errorf("Expected keys on all 3 endpoints, got %v", counts)
+	}
+}
+
+func TestConsistentHash_SetEndpointsMovesFewKeys(t *testing.T) {
+	logger, _ := zap.NewProductionConfig().Build()
+	ch := NewConsistentHash([]string{"a:53", "b:53", "c:53"}, logger)
+
+	before := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		before[key] = ch.EndpointFor(key)
+	}
+	ch.SetEndpoints([]string{"a:53", "b:53", "c:53", "d:53"})
+
+	moved := 0
+	for key, endpoint := range before {
+		if got := ch.EndpointFor(key); got != endpoint {
+			moved++
+			if got != "d:53" {
+				t.Errorf("Expected key %s to stay on %s or move to the new endpoint, got %s", key, endpoint, got)
+			}
+		}
+	}
+	if moved == 0 || moved > 150 {
+		t.Errorf("Expected about a quarter of the keys to move, got %d of 300", moved)
+	}
+}
+
+func TestConsistentHash_NoEndpoints(t *testing.T) {
+	logger, _ := zap.NewProductionConfig().Build()
+	ch := NewConsistentHash(nil, logger)
+	if endpoint := ch.EndpointFor("client"); endpoint != "" {
+		t.Errorf("Expected empty string, but got %s", endpoint)
+	}
+	if endpoint := ch.NextEndpoint(); endpoint != "" {
+		t.Errorf("Expected empty string, but got %s", endpoint)
+	}
+}
+
+func TestNextEndpoint_UsesKeyWhenSupported(t *testing.T) {
+	logger, _ := zap.NewProductionConfig().Build()
+	ch := NewConsistentHash([]string{"a:53", "b:53", "c:53"}, logger)
+	expected := ch.EndpointFor("192.0.2.1")
+	for i := 0; i < 5; i++ {
+		if got := nextEndpoint(ch, "192.0.2.1"); got != expected {
+			t.Errorf("Expected %s, but got %s", expected, got)
+		}
+	}
+
+	rr := NewRoundRobin([]string{"a:53", "b:53"}, logger)
+	if first, second := nextEndpoint(rr, "192.0.2.1"), nextEndpoint(rr, "192.0.2.1"); first == second {
+		t.Errorf("Expected round-robin to ignore the key, got %s twice", first)
+	}
+}
@@ -0,0 +1,186 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultCORSMethods are allowed when no methods are configured.
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+type CORSPolicy struct {
+	allowAll         bool
+	origins          map[string]bool
+	wildcards        [][2]string
+	patterns         []*regexp.Regexp
+	methods          []string
+	allowAllHeaders  bool
+	headers          []string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+// NewCORSPolicy initializes a CORSPolicy from config.
+// Origins are matched exactly, as "*" for any origin, or with a single "*" wildcard such as
+// "https://*.example.com"; originPatterns are regular expressions matched against the whole origin.
+func NewCORSPolicy(config *CORSConfig) (*CORSPolicy, error) {
+	policy := &CORSPolicy{
+		origins:          make(map[string]bool),
+		exposedHeaders:   strings.Join(config.ExposedHeaders, ", "),
+		allowCredentials: config.AllowCredentials,
+	}
+
+	for _, origin := range config.AllowedOrigins {
+		switch strings.Count(origin, "*") {
+		case 0:
+			policy.origins[strings.ToLower(origin)] = true
+		case 1:
+			if origin == "*" {
+				// Echoing every origin with credentials would let any site act as the user
+				if config.AllowCredentials {
+					return nil, fmt.Errorf(`cors: allowedOrigins "*" cannot be combined with allowCredentials`)
+				}
+				policy.allowAll = true
+				continue
+			}
+			prefix, suffix, _ := strings.Cut(strings.ToLower(origin), "*")
+			policy.wildcards = append(policy.wildcards, [2]string{prefix, suffix})
+		default:
+			return nil, fmt.Errorf("cors: origin %q has more than one wildcard", origin)
+		}
+	}
+	for _, pattern := range config.AllowedOriginPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("cors: origin pattern %q: %w", pattern, err)
+		}
+		policy.patterns = append(policy.patterns, re)
+	}
+
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	for _, method := range methods {
+		policy.methods = append(policy.methods, strings.ToUpper(method))
+	}
+	for _, header := range config.AllowedHeaders {
+		if header == "*" {
+			policy.allowAllHeaders = true
+			continue
+		}
+		policy.headers = append(policy.headers, http.CanonicalHeaderKey(header))
+	}
+	if config.MaxAge > 0 {
+		policy.maxAge = strconv.Itoa(int(config.MaxAge.Seconds()))
+	}
+	return policy, nil
+}
+
+// Handle applies the policy to r. Preflight requests are answered directly and Handle returns
+// false; for other requests the CORS response headers are set on w and Handle returns true.
+func (cp *CORSPolicy) Handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	requestMethod := r.Header.Get("Access-Control-Request-Method")
+	if r.Method == http.MethodOptions && origin != "" && requestMethod != "" {
+		cp.preflight(w, r, origin, requestMethod)
+		return false
+	}
+
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !cp.allowOrigin(origin) {
+		return true
+	}
+	cp.setOrigin(w, origin)
+	if cp.exposedHeaders != "" {
+		w.Header().Set("Access-Control-Expose-Headers", cp.exposedHeaders)
+	}
+	return true
+}
+
+// preflight answers an OPTIONS preflight request, rejecting disallowed origins, methods and headers with 403.
+func (cp *CORSPolicy) preflight(w http.ResponseWriter, r *http.Request, origin, requestMethod string) {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	requestHeaders := parseHeaderList(r.Header.Values("Access-Control-Request-Headers"))
+	if !cp.allowOrigin(origin) || !containsString(cp.methods, requestMethod) || !cp.allowHeaders(requestHeaders) {
+		http.Error(w, "CORS request not allowed", http.StatusForbidden)
+		return
+	}
+
+	cp.setOrigin(w, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(cp.methods, ", "))
+	if len(requestHeaders) > 0 {
+		// Echoing the requested headers also covers "*", which browsers ignore on credentialed requests
+		header.Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+	}
+	if cp.maxAge != "" {
+		header.Set("Access-Control-Max-Age", cp.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setOrigin sets the allowed origin, echoing it unless any origin may read the response.
+func (cp *CORSPolicy) setOrigin(w http.ResponseWriter, origin string) {
+	if cp.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if cp.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (cp *CORSPolicy) allowOrigin(origin string) bool {
+	if cp.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if cp.origins[lower] {
+		return true
+	}
+	for _, wildcard := range cp.wildcards {
+		if len(lower) > len(wildcard[0])+len(wildcard[1]) && strings.HasPrefix(lower, wildcard[0]) && strings.HasSuffix(lower, wildcard[1]) {
+			return true
+		}
+	}
+	for _, pattern := range cp.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cp *CORSPolicy) allowHeaders(requested []string) bool {
+	if cp.allowAllHeaders {
+		return true
+	}
+	for _, header := range requested {
+		if !containsString(cp.headers, header) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHeaderList splits comma-separated header names into canonical form.
+func parseHeaderList(values []string) []string {
+	var headers []string
+	for _, value := range values {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, http.CanonicalHeaderKey(header))
+			}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCORSPolicy_Origins(t *testing.T) {
+	policy, err := NewCORSPolicy(&CORSConfig{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []string{`https://preview-\d+\.example\.net`},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cases := map[string]bool{
+		"https://app.example.com":          true,
+		"https://APP.example.com":          true,
+		"https://evil.example.com":         false,
+		"https://a.b.example.org":          true,
+		"https://.example.org":             false,
+		"http://a.example.org":             false,
+		"https://preview-42.example.net":   true,
+		"https://preview-x.example.net":    false,
+		"https://preview-42.example.net.x": false,
+	}
+	for origin, allowed := range cases {
+		if got := policy.allowOrigin(origin); got != allowed {
+			t.Errorf("Expected allowOrigin(%s) to be %v, got %v", origin, allowed, got)
+		}
+	}
+
+	if _, err := NewCORSPolicy(&CORSConfig{AllowedOrigins: []string{"https://*.*.com"}}); err == nil {
+		t.Errorf("Expected an error for an origin with two wildcards")
+	}
+	if _, err := NewCORSPolicy(&CORSConfig{AllowedOriginPatterns: []string{"("}}); err == nil {
+		t.Errorf("Expected an error for an invalid origin pattern")
+	}
+	if _, err := NewCORSPolicy(&CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Errorf("Expected an error for any origin with credentials")
+	}
+}
+
+func TestCORSPolicy_Preflight(t *testing.T) {
+	policy, _ := NewCORSPolicy(&CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"get", "put"},
+		AllowedHeaders:   []string{"Authorization", "content-type"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/service1", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rr := httptest.NewRecorder()
+		if policy.Handle(rr, req) {
+			t.Errorf("Expected preflight to be answered by the policy")
+		}
+		return rr
+	}
+
+	rr := preflight("https://app.example.com", "PUT", "content-type, authorization")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", rr.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "Content-Type, Authorization",
+		"Access-Control-Max-Age":           "600",
+	}
+	for header, value := range expected {
+		if got := rr.Header().Get(header); got != value {
+			t.Errorf("Expected %s to be %q, got %q", header, value, got)
+		}
+	}
+
+	for _, rejected := range []*httptest.ResponseRecorder{
+		preflight("https://evil.example.com", "GET", ""),
+		preflight("https://app.example.com", "DELETE", ""),
+		preflight("https://app.example.com", "GET", "X-Debug"),
+	} {
+		if rejected.Code != http.StatusForbidden || rejected.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Expected rejected preflight with status 403 and no CORS headers, got %d %v", rejected.Code, rejected.Header())
+		}
+	}
+}
+
+func TestCORSPolicy_AnyOrigin(t *testing.T) {
+	policy, _ := NewCORSPolicy(&CORSConfig{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Request-Id"}})
+
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	rr := httptest.NewRecorder()
+	if !policy.Handle(rr, req) {
+		t.Fatalf("Expected a simple request to proceed")
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected Access-Control-Allow-Origin *, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-Id" {
+		t.Errorf("Expected Access-Control-Expose-Headers X-Request-Id, got %q", got)
+	}
+}
+
+func TestRouteHandler_CORS(t *testing.T) {
+	upstreamCalls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Request-Id", "abc")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	g := createTestGateway("")
+	cors, _ := NewCORSPolicy(&CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true})
+	authenticator, _ := NewJWTAuthenticator(&JWTConfig{Secret: "secret"}, g.log)
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		cors:             cors,
+		authenticator:    authenticator,
+	}
+
+	// The preflight is answered without credentials and never reaches the upstream
+	req := httptest.NewRequest("OPTIONS", "/service1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, req)
+	if rr.Code != http.StatusNoContent || upstreamCalls != 0 {
+		t.Errorf("Expected the gateway to answer the preflight with 204, got %d and %d upstream calls", rr.Code, upstreamCalls)
+	}
+
+	// Authentication failures remain readable by the browser
+	req = httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr = httptest.NewRecorder()
+	g.routeHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected 401 with CORS headers, got %d %v", rr.Code, rr.Header())
+	}
+
+	req = bearerRequest(signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}))
+	req.Header.Set("Origin", "https://app.example.com")
+	rr = httptest.NewRecorder()
+	g.routeHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Expected the gateway's CORS policy to replace the upstream's, got %q", got)
+	}
+	if got := rr.Header().Get("X-Request-Id"); got != "abc" {
+		t.Errorf("Expected upstream headers to be forwarded, got %q", got)
+	}
+}
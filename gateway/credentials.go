@@ -0,0 +1,196 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// maxVerifiedCredentials bounds the cache of credentials that passed a slow hash check.
+const maxVerifiedCredentials = 1024
+
+// loadSecrets reads the consumers defined in the secrets file at path.
+func loadSecrets(path string) ([]ConsumerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets SecretsConfig
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets.Consumers, nil
+}
+
+type ConsumerStore struct {
+	byKeyDigest map[string]*ConsumerConfig
+	byUsername  map[string]*ConsumerConfig
+	verified    map[string]*ConsumerConfig
+	mux         sync.Mutex
+}
+
+// NewConsumerStore initializes a ConsumerStore, rejecting credentials that are not hashed
+// with a supported scheme. API keys must be sha256 hashes so that a key is found with a single
+// lookup; checking an unknown key against every bcrypt or argon2id hash would let any caller
+// burn the gateway's CPU. Passwords may use bcrypt or argon2id as they are looked up by username.
+func NewConsumerStore(consumers []ConsumerConfig) (*ConsumerStore, error) {
+	store := &ConsumerStore{
+		byKeyDigest: make(map[string]*ConsumerConfig),
+		byUsername:  make(map[string]*ConsumerConfig),
+		verified:    make(map[string]*ConsumerConfig),
+	}
+
+	for i := range consumers {
+		consumer := &consumers[i]
+		if consumer.Name == "" {
+			return nil, errors.New("consumer without a name")
+		}
+		for _, hash := range consumer.APIKeys {
+			digest, ok := strings.CutPrefix(hash, "sha256:")
+			if !ok {
+				return nil, fmt.Errorf("consumer %s: api key: unsupported hash, use sha256", consumer.Name)
+			}
+			if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("consumer %s: api key: invalid sha256 hash", consumer.Name)
+			}
+			store.byKeyDigest[strings.ToLower(digest)] = consumer
+		}
+		if consumer.Username != "" {
+			if err := checkHash(consumer.Password); err != nil {
+				return nil, fmt.Errorf("consumer %s: password: %w", consumer.Name, err)
+			}
+			if _, exists := store.byUsername[consumer.Username]; exists {
+				return nil, fmt.Errorf("consumer %s: duplicate username %s", consumer.Name, consumer.Username)
+			}
+			store.byUsername[consumer.Username] = consumer
+		}
+	}
+	return store, nil
+}
+
+// VerifyAPIKey returns the consumer owning key.
+func (cs *ConsumerStore) VerifyAPIKey(key string) (*ConsumerConfig, bool) {
+	digest := sha256.Sum256([]byte(key))
+	consumer, ok := cs.byKeyDigest[hex.EncodeToString(digest[:])]
+	return consumer, ok
+}
+
+// VerifyPassword returns the consumer with the given username and password.
+func (cs *ConsumerStore) VerifyPassword(username, password string) (*ConsumerConfig, bool) {
+	consumer, ok := cs.byUsername[username]
+	if !ok {
+		return nil, false
+	}
+
+	digest := sha256.Sum256([]byte(username + ":" + password))
+	cacheKey := "basic:" + string(digest[:])
+	if cached, ok := cs.cached(cacheKey); ok {
+		return cached, true
+	}
+	if !verifyHash(consumer.Password, password) {
+		return nil, false
+	}
+	cs.remember(cacheKey, consumer)
+	return consumer, true
+}
+
+func (cs *ConsumerStore) cached(key string) (*ConsumerConfig, bool) {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+
+	consumer, ok := cs.verified[key]
+	return consumer, ok
+}
+
+// remember caches a successful slow hash check so repeat requests skip bcrypt/argon2.
+func (cs *ConsumerStore) remember(key string, consumer *ConsumerConfig) {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+
+	if len(cs.verified) >= maxVerifiedCredentials {
+		cs.verified = make(map[string]*ConsumerConfig)
+	}
+	cs.verified[key] = consumer
+}
+
+// checkHash validates that hash uses a supported encoding.
+func checkHash(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "sha256:"):
+		digest, err := hex.DecodeString(strings.TrimPrefix(hash, "sha256:"))
+		if err != nil || len(digest) != sha256.Size {
+			return errors.New("invalid sha256 hash")
+		}
+	case strings.HasPrefix(hash, "$argon2id$"):
+		if _, _, _, err := parseArgon2(hash); err != nil {
+			return err
+		}
+	default:
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return errors.New("unsupported hash, use bcrypt, argon2id or sha256")
+		}
+	}
+	return nil
+}
+
+// verifyHash reports whether secret matches hash.
+func verifyHash(hash, secret string) bool {
+	switch {
+	case strings.HasPrefix(hash, "sha256:"):
+		digest := sha256.Sum256([]byte(secret))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(digest[:])), []byte(strings.ToLower(hash[len("sha256:"):]))) == 1
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := parseArgon2(hash)
+		if err != nil {
+			return false
+		}
+		derived := argon2.IDKey([]byte(secret), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(derived, key) == 1
+	default:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+	}
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// parseArgon2 decodes a hash in the PHC format $argon2id$v=19$m=65536,t=3,p=4$salt$key.
+func parseArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptHash(t *testing.T, secret string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash secret: %v", err)
+	}
+	return string(hash)
+}
+
+func argon2Hash(secret string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(secret), salt, 1, 1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func sha256Hash(secret string) string {
+	digest := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(digest[:])
+}
+
+func TestVerifyHash(t *testing.T) {
+	hashes := map[string]string{
+		"bcrypt":   bcryptHash(t, "secret"),
+		"argon2id": argon2Hash("secret"),
+		"sha256":   sha256Hash("secret"),
+	}
+	for name, hash := range hashes {
+		if err := checkHash(hash); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if !verifyHash(hash, "secret") {
+			t.Errorf("%s: expected secret to match", name)
+		}
+		if verifyHash(hash, "wrong") {
+			t.Errorf("%s: expected wrong secret not to match", name)
+		}
+	}
+
+	if err := checkHash("plaintext"); err == nil {
+		t.Errorf("Expected plaintext credentials to be rejected")
+	}
+}
+
+func TestConsumerStore(t *testing.T) {
+	store, err := NewConsumerStore([]ConsumerConfig{
+		{Name: "fast", APIKeys: []string{sha256Hash("fast-key")}},
+		{Name: "slow", Groups: []string{"internal"}, APIKeys: []string{sha256Hash("slow-key")}, Username: "alice", Password: bcryptHash(t, "password")},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for key, expected := range map[string]string{"fast-key": "fast", "slow-key": "slow"} {
+		consumer, ok := store.VerifyAPIKey(key)
+		if !ok || consumer.Name != expected {
+			t.Errorf("Expected key %s to belong to %s, got %v", key, expected, consumer)
+		}
+	}
+	if _, ok := store.VerifyAPIKey("unknown"); ok {
+		t.Errorf("Expected unknown key to be rejected")
+	}
+
+	for i := 0; i < 2; i++ {
+		consumer, ok := store.VerifyPassword("alice", "password")
+		if !ok || consumer.Name != "slow" {
+			t.Errorf("Attempt %d: expected alice to authenticate as slow, got %v", i, consumer)
+		}
+	}
+	if _, ok := store.VerifyPassword("alice", "wrong"); ok {
+		t.Errorf("Expected wrong password to be rejected")
+	}
+	if len(store.verified) != 1 {
+		t.Errorf("Expected the slow hash check to be cached, got %d", len(store.verified))
+	}
+}
+
+func TestConsumerStore_Invalid(t *testing.T) {
+	cases := map[string][]ConsumerConfig{
+		"no name":            {{APIKeys: []string{sha256Hash("key")}}},
+		"plaintext key":      {{Name: "a", APIKeys: []string{"key"}}},
+		"bcrypt key":         {{Name: "a", APIKeys: []string{bcryptHash(t, "key")}}},
+		"argon2id key":       {{Name: "a", APIKeys: []string{argon2Hash("key")}}},
+		"short sha256 key":   {{Name: "a", APIKeys: []string{"sha256:abcd"}}},
+		"plaintext password": {{Name: "a", Username: "alice", Password: "password"}},
+		"duplicate username": {
+			{Name: "a", Username: "alice", Password: sha256Hash("a")},
+			{Name: "b", Username: "alice", Password: sha256Hash("b")},
+		},
+	}
+	for name, consumers := range cases {
+		if _, err := NewConsumerStore(consumers); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	content := `
+consumers:
+  - name: billing
+    groups: [internal]
+    apiKeys:
+      - ` + sha256Hash("billing-key") + `
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write secrets file: %v", err)
+	}
+
+	consumers, err := loadSecrets(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(consumers) != 1 || consumers[0].Name != "billing" || consumers[0].Groups[0] != "internal" {
+		t.Errorf("Unexpected consumers: %+v", consumers)
+	}
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	// defaultExtAuthzTimeout bounds a call to the authorization service when none is configured.
+	defaultExtAuthzTimeout = time.Second
+	// maxExtAuthzBody bounds the denial body relayed from the authorization service.
+	maxExtAuthzBody = 64 << 10
+	// maxExtAuthzCacheEntries bounds the decision cache.
+	maxExtAuthzCacheEntries = 4096
+)
+
+// AuthzDecision is the outcome of an external authorization check.
+type AuthzDecision struct {
+	Allowed bool
+	// Headers are copied onto the upstream request when the request is allowed, and onto
+	// the client's response when it is denied.
+	Headers http.Header
+	// Status and Body are relayed to the client when the request is denied.
+	Status int
+	Body   []byte
+}
+
+type cachedDecision struct {
+	decision *AuthzDecision
+	expires  time.Time
+}
+
+type ExtAuthzClient struct {
+	config *ExtAuthzConfig
+	client *http.Client
+	cache  map[string]cachedDecision
+	mux    sync.Mutex
+	log    *log.Logger
+}
+
+// NewExtAuthzClient initializes an ExtAuthzClient calling the authorization service in config.
+func NewExtAuthzClient(config *ExtAuthzConfig, log *log.Logger) (*ExtAuthzClient, error) {
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("extAuthz: unsupported url %q, only HTTP authorization services are supported", config.URL)
+	}
+	if config.FailureMode != "" && config.FailureMode != "open" && config.FailureMode != "closed" {
+		return nil, fmt.Errorf("extAuthz: unknown failure mode %q", config.FailureMode)
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultExtAuthzTimeout
+	}
+	return &ExtAuthzClient{
+		config: config,
+		client: &http.Client{
+			Timeout: timeout,
+			// Redirects, such as to a login page, are denials relayed to the client
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		cache: make(map[string]cachedDecision),
+		log:   log,
+	}, nil
+}
+
+// FailOpen reports whether requests are allowed when the authorization service is unavailable.
+func (ea *ExtAuthzClient) FailOpen() bool {
+	return ea.config.FailureMode == "open"
+}
+
+// Check asks the authorization service whether r may proceed.
+// The service receives the request's method and path, appended to the configured URL,
+// along with the configured headers. Any 2xx status allows the request; other
+// statuses below 500 deny it with the service's status, headers and body. Errors and
+// 5xx statuses are returned as errors.
+func (ea *ExtAuthzClient) Check(ctx context.Context, r *http.Request) (*AuthzDecision, error) {
+	key := ea.cacheKey(r)
+	if decision, ok := ea.cached(key); ok {
+		return decision, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, strings.TrimSuffix(ea.config.URL, "/")+r.URL.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range ea.config.ForwardHeaders {
+		if values := r.Header.Values(header); len(values) > 0 {
+			req.Header[http.CanonicalHeaderKey(header)] = values
+		}
+	}
+
+	resp, err := ea.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("authorization service returned status %d", resp.StatusCode)
+	}
+
+	decision := &AuthzDecision{
+		Allowed: resp.StatusCode >= 200 && resp.StatusCode < 300,
+		Status:  resp.StatusCode,
+		Headers: make(http.Header),
+	}
+	if decision.Allowed {
+		for _, header := range ea.config.UpstreamHeaders {
+			if values := resp.Header.Values(header); len(values) > 0 {
+				decision.Headers[http.CanonicalHeaderKey(header)] = values
+			}
+		}
+	} else {
+		decision.Headers = resp.Header.Clone()
+		// The relayed body may be cut short, so its length is recomputed when written
+		decision.Headers.Del("Content-Length")
+		decision.Body, _ = io.ReadAll(io.LimitReader(resp.Body, maxExtAuthzBody))
+	}
+
+	ea.remember(key, decision)
+	return decision, nil
+}
+
+// cacheKey identifies requests that get the same decision: same method, path and forwarded headers.
+func (ea *ExtAuthzClient) cacheKey(r *http.Request) string {
+	var key strings.Builder
+	key.WriteString(r.Method + " " + r.URL.Path)
+	for _, header := range ea.config.ForwardHeaders {
+		key.WriteString("\n" + strings.Join(r.Header.Values(header), ","))
+	}
+	return key.String()
+}
+
+func (ea *ExtAuthzClient) cached(key string) (*AuthzDecision, bool) {
+	if ea.config.CacheTTL <= 0 {
+		return nil, false
+	}
+
+	ea.mux.Lock()
+	defer ea.mux.Unlock()
+
+	entry, ok := ea.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.decision, true
+}
+
+func (ea *ExtAuthzClient) remember(key string, decision *AuthzDecision) {
+	if ea.config.CacheTTL <= 0 {
+		return
+	}
+
+	ea.mux.Lock()
+	defer ea.mux.Unlock()
+
+	if len(ea.cache) >= maxExtAuthzCacheEntries {
+		ea.cache = make(map[string]cachedDecision)
+	}
+	ea.cache[key] = cachedDecision{decision: decision, expires: time.Now().Add(ea.config.CacheTTL)}
+}
+
+// authorizeExternally runs the service's external authorization check.
+// It writes the denial or failure response and returns false when the request must not proceed.
+func (g *Gateway) authorizeExternally(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) bool {
+	authz := service.extAuthz
+	// Headers owned by the authorization service are never taken from the client
+	for _, header := range authz.config.UpstreamHeaders {
+		r.Header.Del(header)
+	}
+
+	decision, err := authz.Check(r.Context(), r)
+	if err != nil {
+		if authz.FailOpen() {
+			g.log.Sugar().Warnf("External authorization failed for service %s, allowing request: %v", service.serviceName, err)
+			return true
+		}
+		g.log.Sugar().Infof("External authorization failed for service %s: %v", service.serviceName, err)
+		http.Error(w, "Authorization service unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+
+	if !decision.Allowed {
+		g.log.Sugar().Infof("External authorization denied request for service %s with status %d", service.serviceName, decision.Status)
+		copyResponseHeaders(w.Header(), decision.Headers, service.cors != nil)
+		w.WriteHeader(decision.Status)
+		w.Write(decision.Body)
+		return false
+	}
+
+	for header, values := range decision.Headers {
+		r.Header[header] = values
+	}
+	return true
+}
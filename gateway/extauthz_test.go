@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newAuthzServer starts a stand-in authorization service allowing requests
+// with the header X-Token: good, redirecting requests without one to a login page
+// and denying everything else.
+func newAuthzServer(t *testing.T, calls *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if r.Header.Get("X-Token") == "" {
+			w.Header().Set("Location", "https://login.example.com/")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gateway"`)
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		if r.Header.Get("X-Token") != "good" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("denied " + r.Method + " " + r.URL.Path))
+			return
+		}
+		w.Header().Set("X-Tenant", "acme")
+		w.Header().Set("X-Internal", "secret")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newExtAuthzGateway(t *testing.T, config *ExtAuthzConfig, upstream http.HandlerFunc) *Gateway {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	g := createTestGateway("")
+	authz, err := NewExtAuthzClient(config, g.log)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+		endpoints:        []string{server.URL},
+		extAuthz:         authz,
+	}
+	return g
+}
+
+func TestRouteHandler_ExtAuthz(t *testing.T) {
+	var calls int32
+	authzServer := newAuthzServer(t, &calls)
+
+	var upstreamTenant, upstreamInternal string
+	g := newExtAuthzGateway(t, &ExtAuthzConfig{
+		URL:             authzServer.URL + "/check",
+		ForwardHeaders:  []string{"X-Token"},
+		UpstreamHeaders: []string{"X-Tenant"},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		upstreamTenant = r.Header.Get("X-Tenant")
+		upstreamInternal = r.Header.Get("X-Internal")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("X-Token", "good")
+	req.Header.Set("X-Tenant", "spoofed")
+	w := httptest.NewRecorder()
+	g.routeHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", w.Code)
+	}
+	if upstreamTenant != "acme" {
+		t.Errorf("Expected upstream X-Tenant acme, got %q", upstreamTenant)
+	}
+	if upstreamInternal != "" {
+		t.Errorf("Expected headers outside the allow list not to be copied, got %q", upstreamInternal)
+	}
+
+	req = httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("X-Token", "bad")
+	w = httptest.NewRecorder()
+	g.routeHandler(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden, got %d", w.Code)
+	}
+	if w.Body.String() != "denied GET /check/service1" || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Expected the denial body and headers to be relayed, got %q %v", w.Body.String(), w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	g.routeHandler(w, httptest.NewRequest("GET", "/service1", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://login.example.com/" || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected the redirect to be relayed, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRouteHandler_ExtAuthzFailureModes(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	upstream := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	for mode, expected := range map[string]int{"": http.StatusServiceUnavailable, "closed": http.StatusServiceUnavailable, "open": http.StatusOK} {
+		g := newExtAuthzGateway(t, &ExtAuthzConfig{URL: slow.URL, Timeout: 10 * time.Millisecond, FailureMode: mode}, upstream)
+		w := httptest.NewRecorder()
+		g.routeHandler(w, httptest.NewRequest("GET", "/service1", nil))
+		if w.Code != expected {
+			t.Errorf("Failure mode %q: expected status %d, got %d", mode, expected, w.Code)
+		}
+	}
+}
+
+func TestExtAuthzCache(t *testing.T) {
+	var calls int32
+	authzServer := newAuthzServer(t, &calls)
+
+	g := createTestGateway("")
+	authz, _ := NewExtAuthzClient(&ExtAuthzConfig{URL: authzServer.URL, ForwardHeaders: []string{"X-Token"}, CacheTTL: time.Minute}, g.log)
+
+	for _, token := range []string{"good", "good", "bad", "bad"} {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		req.Header.Set("X-Token", token)
+		decision, err := authz.Check(req.Context(), req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if decision.Allowed != (token == "good") {
+			t.Errorf("Token %s: unexpected decision %+v", token, decision)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected one call per distinct request, got %d", calls)
+	}
+}
+
+func TestNewExtAuthzClient_Invalid(t *testing.T) {
+	g := createTestGateway("")
+	if _, err := NewExtAuthzClient(&ExtAuthzConfig{URL: "grpc://authz:9000"}, g.log); err == nil {
+		t.Errorf("Expected error for non-HTTP authorization service")
+	}
+	if _, err := NewExtAuthzClient(&ExtAuthzConfig{URL: "http://authz", FailureMode: "sometimes"}, g.log); err == nil {
+		t.Errorf("Expected error for unknown failure mode")
+	}
+}
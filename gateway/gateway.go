@@ -2,15 +2,22 @@ package gateway
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "go.uber.org/zap"
 
 	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/http/httpguts"
 	"gopkg.in/yaml.v2"
 )
 
@@ -22,6 +29,33 @@ type Gateway struct {
 	configPath      string
 	serviceRegistry map[string]*GatewayServiceConfig
 	log             *log.Logger
+
+	rateLimitStore       RateLimitStore
+	rateLimitStoreConfig RateLimitStoreConfig
+	cacheStore           CacheStore
+	cacheStoreConfig     CacheStoreConfig
+
+	// proxyProtocol, tls, h2c and http3 are only read when the listeners start
+	proxyProtocol  *ProxyProtocolConfig
+	tls            *TLSConfig
+	h2c            bool
+	http3          *HTTP3Config
+	server         *ServerConfig
+	admin          *AdminConfig
+	trustedProxies []*net.IPNet
+	ipFilter       *IPFilter
+	// tcpProxies are the layer 4 listeners by name; their routes are reloaded in place
+	tcpProxies map[string]*TCPProxy
+	udpProxies map[string]*UDPProxy
+	// listening is set once Run has started the layer 4 listeners, fixing their names and addresses
+	listening bool
+	// grpcRoutes maps gRPC services and methods to the services they are routed to
+	grpcRoutes map[string]string
+	// routes are the match-based routes in priority order
+	routes []*Route
+
+	watcher     *fsnotify.Watcher
+	secretsPath string
 }
 
 // NewGateway creates a new Gateway instance.
@@ -59,6 +93,17 @@ func (gateway *Gateway) Run() {
 
 	defer watcher.Close()
 
+	gateway.lock.Lock()
+	gateway.watcher = watcher
+	secretsPath := gateway.secretsPath
+	proxyProtocol := gateway.proxyProtocol
+	tlsConfig, h2cEnabled, http3Config := gateway.tls, gateway.h2c, gateway.http3
+	serverConfig := gateway.server
+	tcpProxies, udpProxies := gateway.tcpProxies, gateway.udpProxies
+	gateway.listening = true
+	admin := gateway.admin
+	gateway.lock.Unlock()
+
 	go func() {
 		for {
 			select {
@@ -88,19 +133,84 @@ func (gateway *Gateway) Run() {
 	if err != nil {
 		gateway.log.Sugar().Fatalf("Failed to add watcher: %v", err)
 	}
+	// The secrets file is reloaded through the same path as the config file
+	if secretsPath != "" {
+		if err = watcher.Add(secretsPath); err != nil {
+			gateway.log.Sugar().Fatalf("Failed to add watcher: %v", err)
+		}
+	}
+
+	if admin != nil && admin.Address != "" {
+		go func() {
+			gateway.log.Sugar().Infof("Admin API listening on %s", admin.Address)
+			if err := http.ListenAndServe(admin.Address, gateway.adminHandler()); err != nil {
+				gateway.log.Sugar().Fatalf("Failed to start admin server: %v", err)
+			}
+		}()
+	}
+
+	for _, proxy := range tcpProxies {
+		listener, err := net.Listen("tcp", proxy.address)
+		if err != nil {
+			gateway.log.Sugar().Fatalf("Failed to listen: %v", err)
+		}
+		gateway.log.Sugar().Infof("TCP listener %s listening on %s", proxy.name, proxy.address)
+		go func(proxy *TCPProxy) {
+			if err := proxy.Serve(listener); err != nil {
+				gateway.log.Sugar().Fatalf("TCP listener %s failed: %v", proxy.name, err)
+			}
+		}(proxy)
+	}
+	for _, proxy := range udpProxies {
+		listener, err := net.ListenPacket("udp", proxy.address)
+		if err != nil {
+			gateway.log.Sugar().Fatalf("Failed to listen: %v", err)
+		}
+		gateway.log.Sugar().Infof("UDP listener %s listening on %s", proxy.name, proxy.address)
+		go func(proxy *UDPProxy) {
+			if err := proxy.Serve(listener); err != nil {
+				gateway.log.Sugar().Fatalf("UDP listener %s failed: %v", proxy.name, err)
+			}
+		}(proxy)
+	}
 
 	gateway.log.Sugar().Infof("API Gateway listening on :8080")
 	// Initialize a new mux router
 	mux := http.NewServeMux()
 	// Register the route handler
 	mux.HandleFunc("/", http.HandlerFunc(gateway.routeHandler))
-	if err = http.ListenAndServe(":8080", mux); err != nil {
+	listener, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		gateway.log.Sugar().Fatalf("Failed to listen: %v", err)
+	}
+	if proxyProtocol != nil {
+		if listener, err = newProxyProtocolListener(listener, proxyProtocol); err != nil {
+			gateway.log.Sugar().Fatalf("Failed to start server: %v", err)
+		}
+	}
+	// The HTTP/3 listener shares the route table and TLS config, and is advertised over TCP
+	var handler http.Handler = mux
+	if http3Config != nil {
+		server, err := newHTTP3Server(mux, tlsConfig, http3Config)
+		if err != nil {
+			gateway.log.Sugar().Fatalf("Failed to start HTTP/3 server: %v", err)
+		}
+		go func() {
+			if err := gateway.serveHTTP3(server, http3Config); err != nil {
+				gateway.log.Sugar().Fatalf("Failed to start HTTP/3 server: %v", err)
+			}
+		}()
+		handler = advertiseHTTP3(mux, server)
+	}
+	if err = gateway.serve(listener, handler, tlsConfig, h2cEnabled, serverConfig); err != nil {
 		gateway.log.Sugar().Fatalf("Failed to start server: %v", err)
 	}
 }
 
-// loadConfig loads the configuration from the config file.
-func (g *Gateway) loadConfig() error {
+// loadConfig loads the configuration from the config file. The new configuration is built
+// and validated in full before it replaces the running one in a single step, so a rejected
+// reload leaves the gateway as it was.
+func (g *Gateway) loadConfig() (err error) {
 	data, err := os.ReadFile(g.configPath)
 	if err != nil {
 		return err
@@ -112,35 +222,396 @@ func (g *Gateway) loadConfig() error {
 		return err
 	}
 
-	// Update the service registry with the new configuration
 	g.lock.Lock()
 	defer g.lock.Unlock()
 
+	// Keep the existing store across reloads so counters survive unrelated config changes
+	rateLimitStore := g.rateLimitStore
+	var createdRateLimitStore RateLimitStore
+	defer func() {
+		if closer, ok := createdRateLimitStore.(io.Closer); ok && err != nil {
+			closer.Close()
+		}
+	}()
+	if rateLimitStore == nil || config.RateLimitStore != g.rateLimitStoreConfig {
+		if createdRateLimitStore, err = newRateLimitStore(config.RateLimitStore); err != nil {
+			return err
+		}
+		rateLimitStore = createdRateLimitStore
+	}
+
+	// Likewise cached responses survive reloads that leave the cache store unchanged
+	cacheStore := g.cacheStore
+	var createdCacheStore CacheStore
+	defer func() {
+		if closer, ok := createdCacheStore.(io.Closer); ok && err != nil {
+			closer.Close()
+		}
+	}()
+	if cacheStore == nil || config.CacheStore != g.cacheStoreConfig {
+		if createdCacheStore, err = newCacheStore(config.CacheStore); err != nil {
+			return fmt.Errorf("cacheStore: %w", err)
+		}
+		cacheStore = createdCacheStore
+	}
+
+	trustedProxies, err := parseCIDRs(config.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trustedProxies: %w", err)
+	}
+	if config.HTTP3 != nil && config.TLS == nil {
+		return fmt.Errorf("http3: tls must be configured")
+	}
+
+	if g.listening {
+		running, configured := make(map[string]string), make(map[string]string)
+		for name, proxy := range g.tcpProxies {
+			running[name] = proxy.address
+		}
+		for name, tcpConfig := range config.TCP {
+			configured[name] = tcpConfig.Address
+		}
+		if err := checkListeners("tcp", running, configured); err != nil {
+			return err
+		}
+		running, configured = make(map[string]string), make(map[string]string)
+		for name, proxy := range g.udpProxies {
+			running[name] = proxy.address
+		}
+		for name, udpConfig := range config.UDP {
+			configured[name] = udpConfig.Address
+		}
+		if err := checkListeners("udp", running, configured); err != nil {
+			return err
+		}
+	}
+	tcpProxies := make(map[string]*TCPProxy)
+	for name, tcpConfig := range config.TCP {
+		proxy, err := NewTCPProxy(name, &tcpConfig, g.log)
+		if err != nil {
+			return err
+		}
+		tcpProxies[name] = proxy
+	}
+	udpProxies := make(map[string]*UDPProxy)
+	for name, udpConfig := range config.UDP {
+		proxy, err := NewUDPProxy(name, &udpConfig, g.log)
+		if err != nil {
+			return err
+		}
+		udpProxies[name] = proxy
+	}
+
+	var ipFilter *IPFilter
+	if config.IPFilter != nil {
+		if ipFilter, err = NewIPFilter(config.IPFilter); err != nil {
+			return err
+		}
+	}
+
+	consumers := config.Consumers
+	if config.SecretsFile != "" {
+		secrets, err := loadSecrets(config.SecretsFile)
+		if err != nil {
+			return fmt.Errorf("loading secrets file: %w", err)
+		}
+		consumers = append(consumers, secrets...)
+	}
+	consumerStore, err := NewConsumerStore(consumers)
+	if err != nil {
+		return err
+	}
+
+	var routes []*Route
+	for i := range config.Routes {
+		route, err := NewRoute(&config.Routes[i])
+		if err != nil {
+			return err
+		}
+		if _, exists := config.Services[route.service]; !exists {
+			return fmt.Errorf("route to %s: unknown service", route.service)
+		}
+		routes = append(routes, route)
+	}
+	sortRoutes(routes)
+
+	serviceRegistry := make(map[string]*GatewayServiceConfig)
+	grpcRoutes := make(map[string]string)
 	for serviceName, serviceConfig := range config.Services {
-		var lb LoadBalancer
-		switch serviceConfig.LoadBalancer {
-		case "round-robin":
-			lb = NewRoundRobin(serviceConfig.Endpoints, g.log)
-		case "least-connections":
-			lb = NewLeastConnections(serviceConfig.Endpoints, g.log)
-		default:
-			lb = nil
+		lb := newLoadBalancer(serviceConfig.LoadBalancer, serviceConfig.Endpoints, g.log)
+		service := NewGatewayServiceConfig(serviceName, lb, serviceConfig.Endpoints)
+		if rl := serviceConfig.RateLimit; rl != nil && rl.Requests > 0 && rl.Period > 0 {
+			service.rateLimiter = NewRateLimiter(rateLimitStore, rl.Requests, rl.Period, g.log)
+		}
+		if serviceConfig.Auth != nil {
+			authenticator, err := g.newAuthenticator(serviceConfig.Auth, consumerStore)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.authenticator = authenticator
+		}
+		if serviceConfig.OIDC != nil {
+			oidc, err := NewOIDCHandler(serviceConfig.OIDC, g.log)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.oidc = oidc
+		}
+		if serviceConfig.ProxyProtocol != "" {
+			version, err := proxyProtocolVersion(serviceConfig.ProxyProtocol)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.client = newProxyProtocolClient(version)
 		}
+		if serviceConfig.Protocol != "" {
+			if serviceConfig.ProxyProtocol != "" {
+				return fmt.Errorf("service %s: protocol %s cannot be combined with proxyProtocol", serviceName, serviceConfig.Protocol)
+			}
+			client, err := newUpstreamClient(serviceConfig.Protocol)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.client = client
+		}
+		service.grpcWeb = serviceConfig.GRPCWeb
+		if serviceConfig.Transcoding != nil {
+			transcoder, err := NewTranscoder(serviceConfig.Transcoding)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.transcoder = transcoder
+		}
+		for _, route := range serviceConfig.GRPCServices {
+			route, err := parseGRPCRoute(route)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			if other, exists := grpcRoutes[route]; exists {
+				return fmt.Errorf("service %s: grpcServices: %s is already routed to service %s", serviceName, route, other)
+			}
+			grpcRoutes[route] = serviceName
+		}
+		if serviceConfig.Cache != nil {
+			service.cache = NewResponseCache(serviceConfig.Cache, cacheStore, g.log)
+		}
+		service.streaming = serviceConfig.Streaming
+		if serviceConfig.Limits != nil {
+			limits, err := NewRequestLimits(serviceConfig.Limits)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.limits = limits
+		}
+		if serviceConfig.Split != nil {
+			if len(serviceConfig.Endpoints) > 0 {
+				return fmt.Errorf("service %s: split cannot be combined with endpoints", serviceName)
+			}
+			split, err := NewTrafficSplit(serviceConfig.Split)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			for _, target := range split.Services() {
+				if other, exists := config.Services[target]; !exists || other.Split != nil {
+					return fmt.Errorf("service %s: split: %q is not a service with endpoints", serviceName, target)
+				}
+			}
+			service.split = split
+		}
+		if serviceConfig.Mirror != nil {
+			if _, exists := config.Services[serviceConfig.Mirror.Service]; !exists || serviceConfig.Mirror.Service == serviceName {
+				return fmt.Errorf("service %s: mirror: unknown shadow service %q", serviceName, serviceConfig.Mirror.Service)
+			}
+			mirror, err := NewMirror(serviceConfig.Mirror, g.log)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			if old, exists := g.serviceRegistry[serviceName]; exists {
+				mirror.keepStats(old.mirror)
+			}
+			service.mirror = mirror
+		}
+		if serviceConfig.Upgrade != nil {
+			service.upgrade = NewUpgradeProxy(serviceConfig.Upgrade)
+		}
+		if serviceConfig.Coalesce != nil {
+			coalescer, err := NewCoalescer(serviceConfig.Coalesce)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.coalescer = coalescer
+		}
+		if serviceConfig.Compression != nil {
+			compressor, err := NewCompressor(serviceConfig.Compression)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.compressor = compressor
+		}
+		if serviceConfig.Headers != nil {
+			headers, err := NewHeaderRules(serviceName, serviceConfig.Headers)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.headers = headers
+		}
+		if serviceConfig.CORS != nil {
+			cors, err := NewCORSPolicy(serviceConfig.CORS)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.cors = cors
+		}
+		if serviceConfig.IPFilter != nil {
+			ipFilter, err := NewIPFilter(serviceConfig.IPFilter)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.ipFilter = ipFilter
+		}
+		if serviceConfig.Authorization != nil {
+			policy, err := NewAuthorizationPolicy(serviceConfig.Authorization, g.log)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.policy = policy
+		}
+		if serviceConfig.ExtAuthz != nil {
+			extAuthz, err := NewExtAuthzClient(serviceConfig.ExtAuthz, g.log)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", serviceName, err)
+			}
+			service.extAuthz = extAuthz
+		}
+		if cc := serviceConfig.Concurrency; cc != nil {
+			if cc.MaxInFlight > 0 {
+				service.concurrency = NewConcurrencyLimiter(cc.MaxInFlight, cc.QueueSize, cc.QueueTimeout, cc.Adaptive, g.log)
+			}
+			if cc.MaxEndpointInFlight > 0 {
+				service.endpointLimits = make(map[string]*ConcurrencyLimiter)
+				for _, endpoint := range serviceConfig.Endpoints {
+					service.endpointLimits[endpoint] = NewConcurrencyLimiter(cc.MaxEndpointInFlight, cc.QueueSize, cc.QueueTimeout, cc.Adaptive, g.log)
+				}
+			}
+		}
+		serviceRegistry[serviceName] = service
+	}
 
-		g.serviceRegistry[serviceName] = NewGatewayServiceConfig(serviceName, lb, serviceConfig.Endpoints)
+	// Everything is valid: swap the new configuration in
+	if createdRateLimitStore != nil {
+		if closer, ok := g.rateLimitStore.(io.Closer); ok {
+			closer.Close()
+		}
+		g.rateLimitStore = createdRateLimitStore
+		g.rateLimitStoreConfig = config.RateLimitStore
+	}
+	if createdCacheStore != nil {
+		if closer, ok := g.cacheStore.(io.Closer); ok {
+			closer.Close()
+		}
+		g.cacheStore = createdCacheStore
+		g.cacheStoreConfig = config.CacheStore
+	}
+	g.trustedProxies = trustedProxies
+	g.proxyProtocol = config.ProxyProtocol
+	g.tls = config.TLS
+	g.h2c = config.H2C
+	g.http3 = config.HTTP3
+	g.server = config.Server
+	// Running listeners keep serving with the reloaded routes
+	for name, proxy := range tcpProxies {
+		if existing, exists := g.tcpProxies[name]; exists {
+			existing.reload(proxy)
+			tcpProxies[name] = existing
+		}
+	}
+	g.tcpProxies = tcpProxies
+	for name, proxy := range udpProxies {
+		if existing, exists := g.udpProxies[name]; exists {
+			existing.reload(proxy)
+			udpProxies[name] = existing
+		}
+	}
+	g.udpProxies = udpProxies
+	g.admin = config.Admin
+	g.ipFilter = ipFilter
+	g.watchSecrets(config.SecretsFile)
+	// Connections kept alive by replaced clients are closed once idle
+	for _, old := range g.serviceRegistry {
+		if old.client != nil {
+			old.client.CloseIdleConnections()
+		}
 	}
+	g.serviceRegistry = serviceRegistry
+	g.grpcRoutes = grpcRoutes
+	g.routes = routes
 
 	return nil
 }
 
+// checkListeners rejects a reload that adds, removes or moves layer 4 listeners, given the
+// addresses by name of the running and the configured ones. Listeners are only started
+// when the gateway starts, so such changes need a restart.
+func checkListeners(kind string, running, configured map[string]string) error {
+	for name, address := range configured {
+		current, exists := running[name]
+		if !exists {
+			return fmt.Errorf("%s: listener %s cannot be added without a restart", kind, name)
+		}
+		if address != current {
+			return fmt.Errorf("%s: listener %s cannot move from %s to %s without a restart", kind, name, current, address)
+		}
+	}
+	for name := range running {
+		if _, exists := configured[name]; !exists {
+			return fmt.Errorf("%s: listener %s cannot be removed without a restart", kind, name)
+		}
+	}
+	return nil
+}
+
+// newLoadBalancer returns the named load balancer over endpoints, or nil for unknown names.
+func newLoadBalancer(name string, endpoints []string, log *log.Logger) LoadBalancer {
+	switch name {
+	case "round-robin":
+		return NewRoundRobin(endpoints, log)
+	case "least-connections":
+		return NewLeastConnections(endpoints, log)
+	case "consistent-hash":
+		return NewConsistentHash(endpoints, log)
+	default:
+		return nil
+	}
+}
+
+// watchSecrets points the file watcher at a new secrets file path. It must be called with the lock held.
+func (g *Gateway) watchSecrets(path string) {
+	if path == g.secretsPath {
+		return
+	}
+	if g.watcher != nil {
+		if g.secretsPath != "" {
+			g.watcher.Remove(g.secretsPath)
+		}
+		if path != "" {
+			if err := g.watcher.Add(path); err != nil {
+				g.log.Sugar().Errorf("Failed to watch secrets file %s: %v", path, err)
+			}
+		}
+	}
+	g.secretsPath = path
+}
+
 // updateServiceConfig updates the service configuration.
 func (g *Gateway) updateServiceConfig(chan string) {
 	msg := <-g.watcherChan
 	g.log.Sugar().Infof("Received update event for file: %s", msg)
 
+	// A rejected reload leaves the running configuration in place
 	err := g.loadConfig()
 	if err != nil {
-		g.log.Sugar().Fatalf("Failed to load config: %v", err)
+		g.log.Sugar().Errorf("Failed to reload config, keeping the running configuration: %v", err)
+		return
 	}
 
 	g.log.Sugar().Infof("Updated Service Registry: %+v\n", g.serviceRegistry)
@@ -148,47 +619,467 @@ func (g *Gateway) updateServiceConfig(chan string) {
 
 func (g *Gateway) routeHandler(w http.ResponseWriter, r *http.Request) {
 	// Log when the request is received
-	g.log.Sugar().Infof("Received request: %s %s", r.Method, r.URL.Path) // Construct the URL and perform the HTTP request
-	serviceName := strings.TrimPrefix(r.URL.Path, "/")
-	g.log.Sugar().Infof("Service name: %s", serviceName)
+	g.lock.Lock()
+	trustedProxies, ipFilter := g.trustedProxies, g.ipFilter
+	g.lock.Unlock()
+
+	r = withRequestID(resolveClientIP(r, trustedProxies))
+	if isGRPC(r) || isGRPCWeb(r) {
+		grpcWriter := &grpcResponseWriter{ResponseWriter: w, contentType: r.Header.Get("Content-Type")}
+		defer grpcWriter.finish()
+		w = grpcWriter
+	}
+	g.log.Sugar().Infof("Received request: %s %s from %s", r.Method, r.URL.Path, clientIP(r)) // Construct the URL and perform the HTTP request
+	if ipFilter != nil && !g.filterClient(w, r, ipFilter) {
+		return
+	}
 
-	// check if the service exists in the service registry. If exists, fetch the service from the registry.
-	// If not, fetch the service from the load balancer.
-	service, exists := g.serviceRegistry[serviceName]
+	route, service, exists := g.matchRoute(r)
 	if !exists {
+		service, exists = g.lookupService(r.URL.Path)
+	}
+	if !exists {
+		service, exists = g.matchServicePrefix(r.URL.Path)
+	}
+	if !exists {
+		serviceName := strings.TrimPrefix(r.URL.Path, "/")
 		g.log.Sugar().Infof("Service not found: %s", serviceName)
 		http.Error(w, "Service not found", http.StatusNotFound)
 		return
 	}
+	serviceName := service.serviceName
+	g.log.Sugar().Infof("Service name: %s", serviceName)
+	// The path prefix that led to the service, kept when a split picks another service
+	prefix := "/" + serviceName
+	if route != nil {
+		prefix = route.pathPrefix
+	}
+
+	// Errors written by the gateway from here on carry the service's response headers too
+	if service.headers != nil {
+		w = &responseRulesWriter{ResponseWriter: w, r: r, rules: service.headers}
+	}
 
-	if service.loadBalancerType == nil {
+	if service.loadBalancerType == nil && service.split == nil {
 		g.log.Sugar().Infof("Load balancer not found for service: %s", serviceName)
 		http.Error(w, "Load balancer not found", http.StatusServiceUnavailable)
 		return
 	}
 
-	g.log.Sugar().Infof("Service found: %s with endpoints %v", serviceName, service.endpoints)
-	url := service.loadBalancerType.NextEndpoint() + r.URL.Path
-	g.log.Sugar().Infof("Forwarding request to: %s\n", url)
-	resp, err := http.Get(url)
+	if service.ipFilter != nil && !g.filterClient(w, r, service.ipFilter) {
+		return
+	}
+
+	// Preflights carry no credentials, so they are answered before authentication
+	if service.cors != nil && !service.cors.Handle(w, r) {
+		return
+	}
+
+	if service.oidc != nil {
+		if r = service.oidc.Handle(w, r, prefix); r == nil {
+			return
+		}
+	}
+
+	if service.authenticator != nil {
+		if r = g.authenticate(w, r, service); r == nil {
+			return
+		}
+	}
+	// Response rules of later errors may render the caller's claims
+	if rw, ok := w.(*responseRulesWriter); ok {
+		rw.r = r
+	}
+
+	if service.policy != nil && !g.authorize(w, r, service) {
+		return
+	}
+
+	if service.extAuthz != nil && !g.authorizeExternally(w, r, service) {
+		return
+	}
+
+	if service.rateLimiter != nil && !g.allowRequest(w, r, service) {
+		return
+	}
+
+	if service.split != nil {
+		split := service
+		if service, exists = g.splitRoute(r, split); !exists || service.loadBalancerType == nil {
+			g.log.Sugar().Infof("Split route %s: chosen service is unavailable", split.serviceName)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if upgradeType(r.Header) != "" {
+		if service.upgrade != nil {
+			g.serveUpgrade(w, r, service)
+			return
+		}
+		// Services without upgrade support get a plain HTTP request
+		r.Header.Del("Upgrade")
+	}
+
+	// Limits apply past this point, as upgrade tunnels are bounded by their own timeouts
+	if service.limits != nil {
+		var cancel context.CancelFunc
+		if r, cancel = g.applyLimits(w, r, service); r == nil {
+			return
+		}
+		defer cancel()
+	}
+
+	// A copy of the request is sent to the shadow service after the primary response
+	if service.mirror != nil && service.mirror.Sample() {
+		var send func()
+		w, r, send = g.startMirror(w, r, service)
+		defer send()
+	}
+
+	if service.grpcWeb && isGRPCWeb(r) {
+		g.serveGRPCWeb(w, r, service)
+		return
+	}
+	// The endpoints only speak gRPC, so any other call must be transcoded
+	if service.transcoder != nil && !isGRPC(r) && !isGRPCWeb(r) {
+		g.serveTranscoded(w, r, service, prefix)
+		return
+	}
+
+	if service.cache != nil && service.cache.Cacheable(r) {
+		g.serveCached(w, r, service, route.matchedHost(r))
+		return
+	}
+	if service.coalescer != nil && r.Method == http.MethodGet {
+		g.serveCoalesced(w, r, service)
+		return
+	}
+
+	g.proxy(w, r, service)
+}
+
+// proxy forwards r to the service and copies the response to the client.
+func (g *Gateway) proxy(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) {
+	resp, release, err := g.forward(r, service)
 	if err != nil {
-		// Log if the service is unavailable
-		g.log.Sugar().Infof("Error fetching from service: %v", err)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		g.forwardFailed(w, service.serviceName, err)
 		return
 	}
+	defer release()
 	defer resp.Body.Close()
 
+	g.writeResponse(w, r, service, resp)
+}
+
+// errBadUpstreamRequest reports a client request that cannot be forwarded.
+var errBadUpstreamRequest = errors.New("invalid upstream request")
+
+// forward sends r to one of the service's endpoints, within the service's concurrency limits.
+// The returned release function must be called once the response body has been consumed.
+func (g *Gateway) forward(r *http.Request, service *GatewayServiceConfig) (*http.Response, func(), error) {
+	// upstreamFailed feeds the adaptive concurrency limits once the request completes
+	upstreamFailed := false
+	// Latency is measured to the response headers so that long-lived streams and tunnels
+	// hold their concurrency slot without skewing the adaptive limits
+	var responded time.Time
+	latency := func(start time.Time) time.Duration {
+		if responded.IsZero() {
+			return time.Since(start)
+		}
+		return responded.Sub(start)
+	}
+	var releases []func()
+	release := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+
+	// The upstream request is aborted once its response body idles past the service's limit
+	cancel := context.CancelFunc(func() {})
+	if service.limits != nil && service.limits.idleTimeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(r.Context())
+		r = r.WithContext(ctx)
+		releases = append(releases, cancel)
+	}
+
+	if limiter := service.concurrency; limiter != nil {
+		if err := limiter.Acquire(r.Context()); err != nil {
+			release()
+			return nil, nil, err
+		}
+		start := time.Now()
+		releases = append(releases, func() { limiter.Release(latency(start), upstreamFailed) })
+	}
+
+	g.log.Sugar().Infof("Service found: %s with endpoints %v", service.serviceName, service.endpoints)
+	endpoint := nextEndpoint(service.loadBalancerType, clientIP(r))
+	if releaser, ok := service.loadBalancerType.(EndpointReleaser); ok {
+		releases = append(releases, func() { releaser.ReleaseEndpoint(endpoint) })
+	}
+	if limiter, ok := service.endpointLimits[endpoint]; ok {
+		if err := limiter.Acquire(r.Context()); err != nil {
+			release()
+			return nil, nil, err
+		}
+		start := time.Now()
+		releases = append(releases, func() { limiter.Release(latency(start), upstreamFailed) })
+	}
+
+	url := endpoint + r.URL.Path
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	if identity := identityFromContext(r.Context()); identity != nil {
+		g.log.Sugar().Infof("Forwarding request to: %s for consumer %q subject %q\n", url, identity.Consumer, identity.Subject)
+	} else {
+		g.log.Sugar().Infof("Forwarding request to: %s\n", url)
+	}
+	req, err := newUpstreamRequest(r, url)
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("%w: %v", errBadUpstreamRequest, err)
+	}
+	if service.headers != nil {
+		service.headers.ApplyRequest(req.Header, r)
+	}
+
+	client := http.DefaultClient
+	if service.client != nil {
+		client = service.client
+	}
+	resp, err := client.Do(req)
+	responded = time.Now()
+	if err != nil {
+		upstreamFailed = true
+		release()
+		if requestBodyIdled(r) && !errors.Is(err, errRequestBodyIdle) {
+			err = fmt.Errorf("%w: %v", errRequestBodyIdle, err)
+		}
+		return nil, nil, err
+	}
+	upstreamFailed = resp.StatusCode >= http.StatusInternalServerError
+	if service.limits != nil {
+		if err := service.limits.limitResponse(resp, cancel); err != nil {
+			resp.Body.Close()
+			release()
+			return nil, nil, err
+		}
+	}
+
 	// Log the response status code
 	g.log.Sugar().Infof("Received response: %d", resp.StatusCode)
+	return resp, release, nil
+}
+
+// forwardFailed writes the response for a request forward could not complete.
+func (g *Gateway) forwardFailed(w http.ResponseWriter, serviceName string, err error) {
+	switch {
+	case errors.Is(err, ErrConcurrencyLimit), errors.Is(err, ErrQueueFull), errors.Is(err, ErrQueueTimeout):
+		g.shedRequest(w, serviceName, err)
+	case errors.Is(err, errBadUpstreamRequest):
+		g.log.Sugar().Infof("Error creating upstream request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+	case errors.As(err, new(*http.MaxBytesError)):
+		g.log.Sugar().Infof("Request body for service %s too large: %v", serviceName, err)
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errRequestBodyIdle):
+		g.log.Sugar().Infof("Request body for service %s timed out: %v", serviceName, err)
+		http.Error(w, "Request body timeout: no data received from the client in time", http.StatusRequestTimeout)
+	case errors.Is(err, errResponseTooLarge):
+		g.log.Sugar().Infof("Response from service %s too large: %v", serviceName, err)
+		http.Error(w, "Response too large", http.StatusBadGateway)
+	case errors.Is(err, context.DeadlineExceeded):
+		g.log.Sugar().Infof("Service %s timed out: %v", serviceName, err)
+		http.Error(w, fmt.Sprintf("Gateway timeout: service %s did not respond within its configured timeout", serviceName), http.StatusGatewayTimeout)
+	default:
+		// Log if the service is unavailable
+		g.log.Sugar().Infof("Error fetching from service: %v", err)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+// waitFailed answers a request whose context ended while it waited on an upstream request
+// sent for another.
+func (g *Gateway) waitFailed(w http.ResponseWriter, serviceName string, err error) {
+	g.log.Sugar().Infof("Gave up waiting on service %s: %v", serviceName, err)
+	http.Error(w, fmt.Sprintf("Gateway timeout: service %s did not respond in time", serviceName), http.StatusGatewayTimeout)
+}
+
+// writeResponse copies the upstream response to the client, applying the service's
+// header rules and compression.
+func (g *Gateway) writeResponse(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig, resp *http.Response) {
+	copyResponseHeaders(w.Header(), resp.Header, service.cors != nil)
+	applyResponseRules(w, r, service)
+
+	encoding := ""
+	if service.compressor != nil {
+		if encoding = service.compressor.Negotiate(r, resp); encoding != "" {
+			prepareCompressedHeaders(w.Header(), encoding)
+		}
+	}
 
 	// Copy the response body to the client
 	w.WriteHeader(resp.StatusCode)
-	w.Header().Set("Content-Type", "application/json")
-	_, err = io.Copy(w, resp.Body)
+	var body io.Writer = w
+	if encoding != "" {
+		compressed := newCompressWriter(w, encoding)
+		defer compressed.release()
+		body = compressed
+	}
+	readErr, writeErr := copyResponse(body, resp.Body, flushInterval(resp, service.streaming))
+	if compressed, ok := body.(*compressWriter); ok && readErr == nil && writeErr == nil {
+		writeErr = compressed.Close()
+	}
+	switch {
+	case writeErr != nil || r.Context().Err() != nil:
+		// Returning closes the upstream response, which cancels the upstream request
+		g.log.Sugar().Infof("Client disconnected from service %s: %v", service.serviceName, errors.Join(writeErr, r.Context().Err()))
+	case readErr != nil:
+		// The status has been sent, so abort the response rather than let it look complete
+		g.log.Sugar().Infof("Error reading response from service %s: %v", service.serviceName, readErr)
+		panic(http.ErrAbortHandler)
+	}
+	copyTrailers(w.Header(), resp.Trailer)
+}
+
+// lookupService finds the service named by a request path without its leading slash, or
+// the service a gRPC method path is routed to.
+func (g *Gateway) lookupService(path string) (*GatewayServiceConfig, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	serviceName := strings.TrimPrefix(path, "/")
+	if service, exists := g.serviceRegistry[serviceName]; exists {
+		return service, true
+	}
+	// gRPC calls are routed by their /package.Service/Method path
+	if name, exists := g.grpcRoutes[serviceName]; exists {
+		service, exists := g.serviceRegistry[name]
+		return service, exists
+	}
+	if grpcService, _, found := strings.Cut(serviceName, "/"); found {
+		if name, exists := g.grpcRoutes[grpcService]; exists {
+			service, exists := g.serviceRegistry[name]
+			return service, exists
+		}
+	}
+	return nil, false
+}
+
+// allowRequest applies the service's rate limit to the request, keyed by the authenticated
+// consumer or subject and falling back to the client address for anonymous requests.
+// It sets the rate limit headers and writes a 429 response when the limit is exceeded.
+func (g *Gateway) allowRequest(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) bool {
+	caller := clientIP(r)
+	if identity := identityFromContext(r.Context()); identity != nil {
+		if identity.Consumer != "" {
+			caller = "consumer:" + identity.Consumer
+		} else if identity.Subject != "" {
+			caller = "subject:" + identity.Subject
+		}
+	}
+	key := service.serviceName + ":" + caller
+	allowed, remaining, reset := service.rateLimiter.Allow(r.Context(), key)
+
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(service.rateLimiter.requests, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	if !allowed {
+		g.log.Sugar().Infof("Rate limit exceeded for %s", key)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// hopHeaders are meaningful only for a single connection and are not forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// copyResponseHeaders copies the upstream response's end-to-end headers to the client response.
+// When the gateway applies a CORS policy, the upstream's own CORS headers are dropped.
+func copyResponseHeaders(dst, src http.Header, cors bool) {
+	for header, values := range src {
+		if containsString(hopHeaders, header) || (cors && strings.HasPrefix(header, "Access-Control-")) {
+			continue
+		}
+		if header == "Vary" {
+			dst[header] = append(dst[header], values...)
+			continue
+		}
+		dst[header] = values
+	}
+}
+
+// copyTrailers sends the upstream response's trailers, such as grpc-status, to the client.
+// It must be called once the response body has been copied.
+func copyTrailers(dst, trailer http.Header) {
+	for header, values := range trailer {
+		dst[http.TrailerPrefix+header] = values
+	}
+}
+
+// newUpstreamRequest builds the request forwarded to url from the client's request,
+// carrying over its method, body and end-to-end headers plus any identity headers.
+func newUpstreamRequest(r *http.Request, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, url, r.Body)
 	if err != nil {
-		// Log an error if there's a problem copying the response
-		g.log.Sugar().Infof("Error writing response: %v", err)
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
+		return nil, err
+	}
+	req.ContentLength = r.ContentLength
+
+	req.Header = r.Header.Clone()
+	for _, header := range hopHeaders {
+		req.Header.Del(header)
 	}
+	// gRPC endpoints expect the client to announce trailer support
+	if httpguts.HeaderValuesContainsToken(r.Header["Te"], "trailers") {
+		req.Header.Set("Te", "trailers")
+	}
+	// Upgrade requests keep the headers asking the endpoint to switch protocols
+	if upgradeType(r.Header) != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+	}
+	if addr, ok := r.Context().Value(clientAddressKey{}).(*clientAddress); ok {
+		req.Header.Set("X-Forwarded-For", addr.forwardedFor)
+	}
+
+	if identity := identityFromContext(r.Context()); identity != nil {
+		for header, value := range identity.Headers {
+			req.Header.Del(header)
+			if value != "" {
+				req.Header.Set(header, value)
+			}
+		}
+	}
+	return req, nil
+}
+
+// filterClient rejects requests from client addresses the filter does not allow.
+func (g *Gateway) filterClient(w http.ResponseWriter, r *http.Request, filter *IPFilter) bool {
+	if filter.Allowed(clientIP(r)) {
+		return true
+	}
+	g.log.Sugar().Infof("Client %s is not allowed to access %s", clientIP(r), r.URL.Path)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// shedRequest rejects a request that could not get a concurrency slot.
+func (g *Gateway) shedRequest(w http.ResponseWriter, serviceName string, err error) {
+	g.log.Sugar().Infof("Shedding request for service %s: %v", serviceName, err)
+	http.Error(w, "Service overloaded: "+err.Error(), http.StatusServiceUnavailable)
 }
@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -163,3 +165,190 @@ func TestRouteHandler_NonExistentService(t *testing.T) {
 		t.Errorf("Expected status NotFound, got %d", resp.StatusCode)
 	}
 }
+
+// Test loadConfig with rate limits and a redis store
+func TestLoadConfig_RateLimit(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "config.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	configContent := `
+rateLimitStore:
+  type: redis
+  address: localhost:6379
+services:
+  service1:
+    endpoints:
+      - http://localhost:8081
+    loadBalancer: round-robin
+    rateLimit:
+      requests: 10
+      period: 1m
+  service2:
+    endpoints:
+      - http://localhost:8082
+    loadBalancer: round-robin
+`
+	_, err = tmpFile.WriteString(configContent)
+	if err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	g := createTestGateway(tmpFile.Name())
+
+	err = g.loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+
+	if _, ok := g.rateLimitStore.(*RedisRateLimitStore); !ok {
+		t.Errorf("Expected a redis rate limit store, got %T", g.rateLimitStore)
+	}
+
+	rl := g.serviceRegistry["service1"].rateLimiter
+	if rl == nil || rl.requests != 10 || rl.period != time.Minute {
+		t.Errorf("Expected service1 to be limited to 10 requests per minute, got %+v", rl)
+	}
+
+	if g.serviceRegistry["service2"].rateLimiter != nil {
+		t.Errorf("Expected service2 to have no rate limit")
+	}
+}
+
+// Test loadConfig picks up consumers from the secrets file on every reload
+func TestLoadConfig_SecretsFile(t *testing.T) {
+	dir := t.TempDir()
+	secretsPath := filepath.Join(dir, "secrets.yaml")
+	configPath := filepath.Join(dir, "config.yaml")
+
+	configContent := `
+secretsFile: ` + secretsPath + `
+services:
+  service1:
+    endpoints:
+      - http://localhost:8081
+    loadBalancer: round-robin
+    auth:
+      apiKey:
+        header: X-API-Key
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	writeSecrets := func(key string) {
+		content := "consumers:\n  - name: tool\n    apiKeys:\n      - " + sha256Hash(key) + "\n"
+		if err := os.WriteFile(secretsPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write secrets file: %v", err)
+		}
+	}
+
+	g := createTestGateway(configPath)
+	writeSecrets("old-key")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if g.secretsPath != secretsPath {
+		t.Errorf("Expected secrets path %s, got %s", secretsPath, g.secretsPath)
+	}
+
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("X-API-Key", "old-key")
+	if _, err := g.serviceRegistry["service1"].authenticator.Authenticate(req); err != nil {
+		t.Errorf("Expected old key to be accepted, got %v", err)
+	}
+
+	writeSecrets("new-key")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during reload: %v", err)
+	}
+	if _, err := g.serviceRegistry["service1"].authenticator.Authenticate(req); err == nil {
+		t.Errorf("Expected rotated out key to be rejected")
+	}
+	req.Header.Set("X-API-Key", "new-key")
+	if _, err := g.serviceRegistry["service1"].authenticator.Authenticate(req); err != nil {
+		t.Errorf("Expected new key to be accepted, got %v", err)
+	}
+}
+
+// Test routeHandler routes sub-paths by their first segment
+func TestLoadConfig_RejectedReloadKeepsState(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+
+	g := createTestGateway(configPath)
+	writeConfig("trustedProxies: [10.0.0.0/8]\nservices:\n  service1:\n    endpoints: [http://localhost:8081]\n    loadBalancer: round-robin\n  service2:\n    endpoints: [http://localhost:8082]\n    loadBalancer: round-robin\n")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	service1 := g.serviceRegistry["service1"]
+
+	// The http3 check fails after the trusted proxies and services are parsed
+	writeConfig("trustedProxies: [192.168.0.0/16]\nhttp3:\n  address: \":8443\"\nservices:\n  service1:\n    endpoints: [http://localhost:9091]\n    loadBalancer: round-robin\n")
+	if err := g.loadConfig(); err == nil {
+		t.Fatalf("Expected http3 without tls to be rejected")
+	}
+	if len(g.trustedProxies) != 1 || g.trustedProxies[0].String() != "10.0.0.0/8" || g.http3 != nil {
+		t.Errorf("Expected the running settings to be kept, got trusted proxies %v and http3 %+v", g.trustedProxies, g.http3)
+	}
+	if g.serviceRegistry["service1"] != service1 || g.serviceRegistry["service2"] == nil {
+		t.Errorf("Expected the running services to be kept")
+	}
+
+	// An accepted reload replaces the whole registry, dropping removed services
+	writeConfig("services:\n  service1:\n    endpoints: [http://localhost:9091]\n    loadBalancer: round-robin\n")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if _, exists := g.serviceRegistry["service2"]; exists || len(g.trustedProxies) != 0 {
+		t.Errorf("Expected service2 and the trusted proxies to be removed")
+	}
+}
+
+func TestLoadConfig_ReplacedCacheStoreClosed(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+
+	g := createTestGateway(configPath)
+	writeConfig("cacheStore:\n  maxBytes: 1048576\nservices:\n  service1:\n    endpoints: [http://localhost:8081]\n    loadBalancer: round-robin\n")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	old := g.cacheStore
+	old.Set("service1:/item", testEntry("cached"))
+
+	// A rejected reload keeps the running store
+	writeConfig("cacheStore:\n  maxBytes: 2097152\nhttp3:\n  address: \":8443\"\nservices:\n  service1:\n    endpoints: [http://localhost:8081]\n    loadBalancer: round-robin\n")
+	if err := g.loadConfig(); err == nil {
+		t.Fatalf("Expected http3 without tls to be rejected")
+	}
+	if _, ok := g.cacheStore.Get("service1:/item"); g.cacheStore != old || !ok {
+		t.Errorf("Expected the running cache store to be kept")
+	}
+
+	// An accepted reload closes the store it replaces
+	writeConfig("cacheStore:\n  maxBytes: 2097152\nservices:\n  service1:\n    endpoints: [http://localhost:8081]\n    loadBalancer: round-robin\n")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if g.cacheStore == old {
+		t.Fatalf("Expected a new cache store")
+	}
+	old.Set("service1:/other", testEntry("late"))
+	if _, ok := old.Get("service1:/item"); ok {
+		t.Errorf("Expected the replaced store to release its entries")
+	}
+	if _, ok := old.Get("service1:/other"); ok {
+		t.Errorf("Expected the replaced store to ignore late writes")
+	}
+}
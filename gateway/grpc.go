@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// isGRPC reports whether r is a gRPC call, as opposed to gRPC-Web or plain HTTP.
+func isGRPC(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// parseGRPCRoute validates a grpcServices entry: a fully qualified service name,
+// optionally followed by a method.
+func parseGRPCRoute(route string) (string, error) {
+	route = strings.TrimPrefix(route, "/")
+	service, method, hasMethod := strings.Cut(route, "/")
+	if service == "" || strings.ContainsAny(service, " /") || (hasMethod && (method == "" || strings.Contains(method, "/"))) {
+		return "", fmt.Errorf("grpcServices: invalid service %q, use package.Service or package.Service/Method", route)
+	}
+	return route, nil
+}
+
+// grpcCode maps the status of a gateway-generated error to a gRPC status code, following
+// the gRPC HTTP to gRPC status code mapping.
+func grpcCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
+// encodeGRPCMessage percent-encodes a grpc-message value.
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// maxGRPCMessage bounds the error message carried in grpc-message.
+const maxGRPCMessage = 1024
+
+// grpcResponseWriter turns error responses written for a gRPC or gRPC-Web call into
+// trailers-only responses, so clients see a status such as UNAVAILABLE instead of an HTTP
+// error page.
+type grpcResponseWriter struct {
+	http.ResponseWriter
+	// contentType is the call's content type, which the error response answers with
+	contentType string
+	wroteHeader bool
+	// status is the HTTP status of an intercepted error response
+	status  int
+	message bytes.Buffer
+}
+
+func (gw *grpcResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	if status == http.StatusOK {
+		gw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	gw.status = status
+}
+
+func (gw *grpcResponseWriter) Write(p []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.status == 0 {
+		return gw.ResponseWriter.Write(p)
+	}
+	if room := maxGRPCMessage - gw.message.Len(); room > 0 {
+		gw.message.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (gw *grpcResponseWriter) Flush() {
+	if gw.status == 0 {
+		if !gw.wroteHeader {
+			gw.WriteHeader(http.StatusOK)
+		}
+		http.NewResponseController(gw.ResponseWriter).Flush()
+	}
+}
+
+func (gw *grpcResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// finish writes the gRPC status for an intercepted error response.
+func (gw *grpcResponseWriter) finish() {
+	if gw.status == 0 {
+		return
+	}
+	header := gw.Header()
+	header.Del("Content-Length")
+	header.Del("X-Content-Type-Options")
+	header.Set("Content-Type", gw.contentType)
+	header.Set("Grpc-Status", strconv.Itoa(int(grpcCode(gw.status))))
+	header.Set("Grpc-Message", encodeGRPCMessage(strings.TrimSpace(gw.message.String())))
+	gw.ResponseWriter.WriteHeader(http.StatusOK)
+}
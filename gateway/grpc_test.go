@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startHealthServer starts a gRPC health server that counts its calls and names itself in a trailer.
+func startHealthServer(t *testing.T, name string) (string, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		calls.Add(1)
+		grpc.SetTrailer(ctx, metadata.Pairs("x-served-by", name))
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return "http://" + listener.Addr().String(), calls
+}
+
+// createGRPCGateway routes the health service to endpoints over h2c and serves the gateway with h2c enabled.
+func createGRPCGateway(t *testing.T, endpoints ...string) (*Gateway, string) {
+	client, err := newUpstreamClient("h2c")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g := createTestGateway("")
+	g.serviceRegistry["health"] = &GatewayServiceConfig{
+		serviceName:      "health",
+		loadBalancerType: NewRoundRobin(endpoints, g.log),
+		endpoints:        endpoints,
+		client:           client,
+	}
+	g.grpcRoutes = map[string]string{"grpc.health.v1.Health": "health"}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go g.serve(listener, http.HandlerFunc(g.routeHandler), nil, true, nil)
+	t.Cleanup(func() { listener.Close() })
+	return g, listener.Addr().String()
+}
+
+func dialGateway(t *testing.T, address string) *grpc.ClientConn {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial the gateway: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPC_ProxiesOverH2C(t *testing.T) {
+	endpoint, _ := startHealthServer(t, "backend-1")
+	_, address := createGRPCGateway(t, endpoint)
+	client := healthpb.NewHealthClient(dialGateway(t, address))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var trailer metadata.MD
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected SERVING, got %v", resp.Status)
+	}
+	if got := trailer.Get("x-served-by"); len(got) != 1 || got[0] != "backend-1" {
+		t.Errorf("Expected the upstream trailer to be propagated, got %v", trailer)
+	}
+
+	// Errors from the endpoint arrive as trailers too
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound from the endpoint, got %v", err)
+	}
+}
+
+func TestGRPC_BalancesPerRequest(t *testing.T) {
+	first, firstCalls := startHealthServer(t, "backend-1")
+	second, secondCalls := startHealthServer(t, "backend-2")
+	_, address := createGRPCGateway(t, first, second)
+	client := healthpb.NewHealthClient(dialGateway(t, address))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// All calls share one client connection, yet each is balanced on its own
+	for i := 0; i < 4; i++ {
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if firstCalls.Load() != 2 || secondCalls.Load() != 2 {
+		t.Errorf("Expected calls to alternate between endpoints, got %d and %d", firstCalls.Load(), secondCalls.Load())
+	}
+}
+
+func TestGRPC_GatewayErrorsUseGRPCStatus(t *testing.T) {
+	// Nothing listens on the endpoint once its listener is closed
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	listener.Close()
+	g, address := createGRPCGateway(t, "http://"+listener.Addr().String())
+	g.grpcRoutes["grpc.reflection.v1.ServerReflection"] = "missing"
+	conn := dialGateway(t, address)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable || status.Convert(err).Message() != "Service unavailable" {
+		t.Errorf("Expected Unavailable for an unreachable endpoint, got %v", err)
+	}
+
+	err = conn.Invoke(ctx, "/unknown.Service/Method", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Expected Unimplemented for an unrouted service, got %v", err)
+	}
+}
+
+func TestLookupService_GRPCRoutes(t *testing.T) {
+	g := createTestGateway("")
+	g.serviceRegistry["greeter"] = &GatewayServiceConfig{serviceName: "greeter"}
+	g.serviceRegistry["admin"] = &GatewayServiceConfig{serviceName: "admin"}
+	g.grpcRoutes = map[string]string{
+		"helloworld.Greeter":          "greeter",
+		"helloworld.Greeter/Shutdown": "admin",
+	}
+
+	cases := map[string]string{
+		"/helloworld.Greeter/SayHello": "greeter",
+		"/helloworld.Greeter/Shutdown": "admin",
+		"/greeter":                     "greeter",
+	}
+	for path, expected := range cases {
+		service, exists := g.lookupService(path)
+		if !exists || service.serviceName != expected {
+			t.Errorf("Expected %s to route to %s, got %v", path, expected, service)
+		}
+	}
+	if _, exists := g.lookupService("/helloworld.Farewell/SayBye"); exists {
+		t.Errorf("Expected an unrouted gRPC service not to be found")
+	}
+}
+
+func TestParseGRPCRoute(t *testing.T) {
+	for _, route := range []string{"helloworld.Greeter", "helloworld.Greeter/SayHello", "/helloworld.Greeter"} {
+		if _, err := parseGRPCRoute(route); err != nil {
+			t.Errorf("Unexpected error for %q: %v", route, err)
+		}
+	}
+	for _, route := range []string{"", "/", "helloworld.Greeter/", "a/b/c", "hello world"} {
+		if _, err := parseGRPCRoute(route); err == nil {
+			t.Errorf("Expected an error for %q", route)
+		}
+	}
+}
+
+func TestEncodeGRPCMessage(t *testing.T) {
+	if got := encodeGRPCMessage("Service overloaded: 100% busy\n"); got != "Service overloaded: 100%25 busy%0A" {
+		t.Errorf("Expected the message to be percent-encoded, got %q", got)
+	}
+}
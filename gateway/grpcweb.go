@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+	// grpcWebTrailerFlag marks the frame carrying the trailers at the end of a gRPC-Web response
+	grpcWebTrailerFlag = 0x80
+)
+
+// isGRPCWeb reports whether r is a gRPC-Web call, in binary or base64 text encoding.
+func isGRPCWeb(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// serveGRPCWeb translates a gRPC-Web call into a gRPC call to the service and the gRPC
+// response back into gRPC-Web, moving the trailers into the response body where browsers
+// can read them.
+func (g *Gateway) serveGRPCWeb(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) {
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+	// The message format, such as +proto, carries over to the gRPC call
+	format := strings.TrimPrefix(strings.TrimPrefix(contentType, grpcWebTextContentType), grpcWebContentType)
+
+	req := r.Clone(r.Context())
+	req.Header.Set("Content-Type", "application/grpc"+format)
+	req.Header.Set("Te", "trailers")
+	req.Header.Del("Content-Length")
+	if text {
+		req.Body = io.NopCloser(base64.NewDecoder(base64.StdEncoding, r.Body))
+		req.ContentLength = -1
+	}
+
+	resp, release, err := g.forward(req, service)
+	if err != nil {
+		g.forwardFailed(w, service.serviceName, err)
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	copyResponseHeaders(w.Header(), resp.Header, service.cors != nil)
+	applyResponseRules(w, r, service)
+	w.Header().Del("Content-Length")
+	if text {
+		w.Header().Set("Content-Type", grpcWebTextContentType+format)
+	} else {
+		w.Header().Set("Content-Type", grpcWebContentType+format)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	var body io.Writer = w
+	if text {
+		body = &grpcWebTextWriter{w: w}
+	}
+	readErr, writeErr := copyResponse(body, resp.Body, -1)
+	if err := errors.Join(readErr, writeErr); err != nil {
+		g.log.Sugar().Infof("Error proxying gRPC-Web response from service %s: %v", service.serviceName, err)
+		return
+	}
+	// Trailers-only responses already carried their status in the headers
+	if len(resp.Trailer) > 0 {
+		if _, err := body.Write(grpcWebTrailerFrame(resp.Trailer)); err != nil {
+			g.log.Sugar().Infof("Error writing gRPC-Web trailers: %v", err)
+		}
+	}
+}
+
+// grpcWebTrailerFrame encodes trailers as the final frame of a gRPC-Web response.
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	names := make([]string, 0, len(trailer))
+	for name := range trailer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var block bytes.Buffer
+	for _, name := range names {
+		for _, value := range trailer[name] {
+			block.WriteString(strings.ToLower(name) + ": " + value + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	return append(frame, block.Bytes()...)
+}
+
+// grpcWebTextWriter base64-encodes each write on its own, so every flushed chunk can be
+// decoded as soon as the browser receives it.
+type grpcWebTextWriter struct {
+	w http.ResponseWriter
+}
+
+func (tw *grpcWebTextWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(tw.w, base64.StdEncoding.EncodeToString(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (tw *grpcWebTextWriter) Flush() {
+	http.NewResponseController(tw.w).Flush()
+}
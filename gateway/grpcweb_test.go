@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func createGRPCWebGateway(t *testing.T, endpoint string) *httptest.Server {
+	client, _ := newUpstreamClient("h2c")
+	g := createTestGateway("")
+	g.serviceRegistry["health"] = &GatewayServiceConfig{
+		serviceName:      "health",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{endpoint}},
+		endpoints:        []string{endpoint},
+		client:           client,
+		grpcWeb:          true,
+	}
+	g.grpcRoutes = map[string]string{"grpc.health.v1.Health": "health"}
+	server := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// grpcWebCall sends a health check as a gRPC-Web call and returns the response with its decoded body.
+func grpcWebCall(t *testing.T, url, contentType string, request *healthpb.HealthCheckRequest) (*http.Response, []byte) {
+	payload, _ := proto.Marshal(request)
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+	var body io.Reader = bytes.NewReader(frame)
+	if strings.HasPrefix(contentType, grpcWebTextContentType) {
+		body = strings.NewReader(base64.StdEncoding.EncodeToString(frame))
+	}
+
+	req, _ := http.NewRequest("POST", url+"/grpc.health.v1.Health/Check", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	if strings.HasPrefix(contentType, grpcWebTextContentType) {
+		// Every chunk is padded on its own, so decode one quantum at a time
+		var decoded []byte
+		for i := 0; i+4 <= len(data); i += 4 {
+			quantum, err := base64.StdEncoding.DecodeString(string(data[i : i+4]))
+			if err != nil {
+				t.Fatalf("Invalid base64 response: %v", err)
+			}
+			decoded = append(decoded, quantum...)
+		}
+		data = decoded
+	}
+	return resp, data
+}
+
+// grpcWebFrames splits a gRPC-Web response body into its message and trailers.
+func grpcWebFrames(t *testing.T, data []byte) (*healthpb.HealthCheckResponse, string) {
+	message := &healthpb.HealthCheckResponse{}
+	var trailers string
+	for len(data) >= 5 {
+		flag, length := data[0], binary.BigEndian.Uint32(data[1:5])
+		if int(length) > len(data)-5 {
+			t.Fatalf("Truncated gRPC-Web frame")
+		}
+		if flag&grpcWebTrailerFlag != 0 {
+			trailers = string(data[5 : 5+length])
+		} else if err := proto.Unmarshal(data[5:5+length], message); err != nil {
+			t.Fatalf("Invalid message: %v", err)
+		}
+		data = data[5+length:]
+	}
+	return message, trailers
+}
+
+func TestRouteHandler_GRPCWeb(t *testing.T) {
+	endpoint, _ := startHealthServer(t, "backend-1")
+	gateway := createGRPCWebGateway(t, endpoint)
+
+	for _, contentType := range []string{"application/grpc-web+proto", "application/grpc-web-text"} {
+		resp, data := grpcWebCall(t, gateway.URL, contentType, &healthpb.HealthCheckRequest{})
+		if got := resp.Header.Get("Content-Type"); got != contentType {
+			t.Errorf("Expected Content-Type %s, got %q", contentType, got)
+		}
+		message, trailers := grpcWebFrames(t, data)
+		if message.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Expected SERVING over %s, got %v", contentType, message.Status)
+		}
+		if !strings.Contains(trailers, "grpc-status: 0\r\n") || !strings.Contains(trailers, "x-served-by: backend-1\r\n") {
+			t.Errorf("Expected the trailers in the body over %s, got %q", contentType, trailers)
+		}
+	}
+
+	// Trailers-only responses keep their status in the headers
+	resp, _ := grpcWebCall(t, gateway.URL, "application/grpc-web+proto", &healthpb.HealthCheckRequest{Service: "missing"})
+	if got := resp.Header.Get("Grpc-Status"); got != "5" {
+		t.Errorf("Expected grpc-status 5 in the headers, got %q", got)
+	}
+}
+
+func TestRouteHandler_GRPCWebGatewayError(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	listener.Close()
+	gateway := createGRPCWebGateway(t, "http://"+listener.Addr().String())
+
+	resp, data := grpcWebCall(t, gateway.URL, "application/grpc-web+proto", &healthpb.HealthCheckRequest{})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Grpc-Status") != "14" || len(data) != 0 {
+		t.Errorf("Expected a trailers-only UNAVAILABLE response, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/grpc-web+proto" {
+		t.Errorf("Expected Content-Type application/grpc-web+proto, got %q", got)
+	}
+}
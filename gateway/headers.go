@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+type requestIDKey struct{}
+
+// withRequestID returns a copy of r carrying its request ID: the client's X-Request-Id if present,
+// otherwise a random one.
+func withRequestID(r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-Id")
+	if id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		id = hex.EncodeToString(b)
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// requestID returns the ID assigned to the request by withRequestID.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// templatePart is a literal or a variable of a header value template.
+type templatePart struct {
+	literal  string
+	variable string
+	arg      string
+}
+
+type headerTemplate []templatePart
+
+// parseHeaderTemplate parses a header value with ${...} variables: ${client_ip}, ${request_id},
+// ${service}, ${method}, ${path}, ${query}, ${header:<name>}, ${claim:<name>} and ${env:<name>}.
+func parseHeaderTemplate(value string) (headerTemplate, error) {
+	var template headerTemplate
+	for value != "" {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			template = append(template, templatePart{literal: value})
+			break
+		}
+		if start > 0 {
+			template = append(template, templatePart{literal: value[:start]})
+		}
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated variable in %q", value)
+		}
+
+		variable, arg, _ := strings.Cut(value[start+2:start+end], ":")
+		switch variable {
+		case "client_ip", "request_id", "service", "method", "path", "query":
+		case "header", "claim", "env":
+			if arg == "" {
+				return nil, fmt.Errorf("variable %s requires a name", variable)
+			}
+		default:
+			return nil, fmt.Errorf("unknown variable %q", variable)
+		}
+		template = append(template, templatePart{variable: variable, arg: arg})
+		value = value[start+end+1:]
+	}
+	return template, nil
+}
+
+// render expands the template for the request r to service.
+func (ht headerTemplate) render(r *http.Request, service string) string {
+	var value strings.Builder
+	for _, part := range ht {
+		switch part.variable {
+		case "":
+			value.WriteString(part.literal)
+		case "client_ip":
+			value.WriteString(clientIP(r))
+		case "request_id":
+			value.WriteString(requestID(r))
+		case "service":
+			value.WriteString(service)
+		case "method":
+			value.WriteString(r.Method)
+		case "path":
+			value.WriteString(r.URL.Path)
+		case "query":
+			value.WriteString(r.URL.RawQuery)
+		case "header":
+			value.WriteString(strings.Join(r.Header.Values(part.arg), ","))
+		case "claim":
+			if identity := identityFromContext(r.Context()); identity != nil {
+				claim, _ := claimString(identity.Claims[part.arg])
+				value.WriteString(claim)
+			}
+		case "env":
+			value.WriteString(os.Getenv(part.arg))
+		}
+	}
+	return value.String()
+}
+
+type headerRule struct {
+	name  string
+	value headerTemplate
+}
+
+type headerRuleSet struct {
+	remove []string
+	set    []headerRule
+	add    []headerRule
+}
+
+type HeaderRules struct {
+	service  string
+	request  *headerRuleSet
+	response *headerRuleSet
+}
+
+// NewHeaderRules initializes the header rules of service from config.
+func NewHeaderRules(service string, config *HeadersConfig) (*HeaderRules, error) {
+	request, err := newHeaderRuleSet(config.Request)
+	if err != nil {
+		return nil, fmt.Errorf("headers: request: %w", err)
+	}
+	response, err := newHeaderRuleSet(config.Response)
+	if err != nil {
+		return nil, fmt.Errorf("headers: response: %w", err)
+	}
+	return &HeaderRules{service: service, request: request, response: response}, nil
+}
+
+func newHeaderRuleSet(config *HeaderRulesConfig) (*headerRuleSet, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	set, err := newHeaderRuleList(config.Set)
+	if err != nil {
+		return nil, err
+	}
+	add, err := newHeaderRuleList(config.Add)
+	if err != nil {
+		return nil, err
+	}
+	rules := &headerRuleSet{set: set, add: add}
+	for _, name := range config.Remove {
+		rules.remove = append(rules.remove, http.CanonicalHeaderKey(name))
+	}
+	return rules, nil
+}
+
+// newHeaderRuleList parses templated header values, sorted by name so rules apply in a stable order.
+func newHeaderRuleList(headers map[string]string) ([]headerRule, error) {
+	var rules []headerRule
+	for name, value := range headers {
+		template, err := parseHeaderTemplate(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		rules = append(rules, headerRule{name: http.CanonicalHeaderKey(name), value: template})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].name < rules[j].name })
+	return rules, nil
+}
+
+// ApplyRequest applies the request rules to the upstream request built from r.
+func (hr *HeaderRules) ApplyRequest(upstream http.Header, r *http.Request) {
+	hr.request.apply(upstream, r, hr.service)
+}
+
+// ApplyResponse applies the response rules to the headers returned to the client of r.
+func (hr *HeaderRules) ApplyResponse(header http.Header, r *http.Request) {
+	hr.response.apply(header, r, hr.service)
+}
+
+// responseRulesWriter applies a service's response header rules to the responses the
+// gateway writes itself, such as errors, as upstream responses apply them when copied.
+type responseRulesWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	rules   *HeaderRules
+	applied bool
+}
+
+func (rw *responseRulesWriter) WriteHeader(status int) {
+	if !rw.applied {
+		rw.applied = true
+		rw.rules.ApplyResponse(rw.Header(), rw.r)
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRulesWriter) Write(b []byte) (int, error) {
+	if !rw.applied {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseRulesWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// applyResponseRules applies the response header rules of service to the response written
+// to w, in place of the rules of the service the request was first routed to.
+func applyResponseRules(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) {
+	for inner := w; inner != nil; {
+		if rw, ok := inner.(*responseRulesWriter); ok {
+			rw.applied = true
+			break
+		}
+		unwrapper, ok := inner.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		inner = unwrapper.Unwrap()
+	}
+	if service.headers != nil {
+		service.headers.ApplyResponse(w.Header(), r)
+	}
+}
+
+// apply removes, then sets, then adds headers.
+func (rs *headerRuleSet) apply(header http.Header, r *http.Request, service string) {
+	if rs == nil {
+		return
+	}
+	for _, name := range rs.remove {
+		header.Del(name)
+	}
+	for _, rule := range rs.set {
+		header.Set(rule.name, rule.value.render(r, service))
+	}
+	for _, rule := range rs.add {
+		header.Add(rule.name, rule.value.render(r, service))
+	}
+}
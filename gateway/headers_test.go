@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHeaderTemplate(t *testing.T) {
+	t.Setenv("GATEWAY_REGION", "eu-west-1")
+
+	req := httptest.NewRequest("GET", "/service1?a=1", nil)
+	req.RemoteAddr = "192.0.2.1:4000"
+	req.Header.Set("X-Request-Id", "req-1")
+	req = withRequestID(resolveClientIP(req, nil))
+	req = req.WithContext(withIdentity(req.Context(), &Identity{Claims: map[string]interface{}{"tenant": "acme", "roles": []interface{}{"a", "b"}}}))
+
+	cases := map[string]string{
+		"plain":                           "plain",
+		"${client_ip}":                    "192.0.2.1",
+		"id=${request_id};svc=${service}": "id=req-1;svc=service1",
+		"${claim:tenant}/${claim:roles}":  "acme/a,b",
+		"${claim:missing}":                "",
+		"${env:GATEWAY_REGION}-gateway":   "eu-west-1-gateway",
+		"${client_ip}${client_ip}":        "192.0.2.1192.0.2.1",
+		"${method} ${path}?${query}":      "GET /service1?a=1",
+		"${header:X-Request-Id}":          "req-1",
+	}
+	for value, expected := range cases {
+		template, err := parseHeaderTemplate(value)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", value, err)
+		}
+		if got := template.render(req, "service1"); got != expected {
+			t.Errorf("Expected %q to render as %q, got %q", value, expected, got)
+		}
+	}
+
+	for _, invalid := range []string{"${client_ip", "${unknown}", "${claim}", "${env:}", "${header}"} {
+		if _, err := parseHeaderTemplate(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	first := withRequestID(httptest.NewRequest("GET", "/service1", nil))
+	second := withRequestID(httptest.NewRequest("GET", "/service1", nil))
+	if requestID(first) == "" || requestID(first) == requestID(second) {
+		t.Errorf("Expected unique generated request IDs, got %q and %q", requestID(first), requestID(second))
+	}
+}
+
+func TestRouteHandler_HeaderRules(t *testing.T) {
+	var upstreamHeaders http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeaders = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Server", "internal/1.0")
+		w.Header().Set("X-Debug", "trace")
+		w.Write([]byte("<p>ok</p>"))
+	}))
+	defer upstream.Close()
+
+	rules, err := NewHeaderRules("service1", &HeadersConfig{
+		Request: &HeaderRulesConfig{
+			Set:    map[string]string{"X-Tenant": "${claim:tenant}", "X-Request-Id": "${request_id}"},
+			Add:    map[string]string{"X-Service": "${service}"},
+			Remove: []string{"cookie"},
+		},
+		Response: &HeaderRulesConfig{
+			Set:    map[string]string{"X-Request-Id": "${request_id}"},
+			Add:    map[string]string{"X-Served-By": "gateway"},
+			Remove: []string{"Server", "X-Debug"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+		headers:          rules,
+	}
+
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Tenant", "spoofed")
+	req.Header.Set("X-Service", "client")
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, req)
+
+	if got := upstreamHeaders.Get("X-Tenant"); got != "" {
+		t.Errorf("Expected X-Tenant to be overwritten without a claim, got %q", got)
+	}
+	if got := upstreamHeaders.Values("X-Service"); len(got) != 2 || got[1] != "service1" {
+		t.Errorf("Expected X-Service to be added, got %v", got)
+	}
+	if got := upstreamHeaders.Get("Cookie"); got != "" {
+		t.Errorf("Expected Cookie to be removed, got %q", got)
+	}
+	id := upstreamHeaders.Get("X-Request-Id")
+	if id == "" || rr.Header().Get("X-Request-Id") != id {
+		t.Errorf("Expected the same request ID upstream and in the response, got %q and %q", id, rr.Header().Get("X-Request-Id"))
+	}
+	if rr.Header().Get("Server") != "" || rr.Header().Get("X-Debug") != "" {
+		t.Errorf("Expected internal headers to be removed, got %v", rr.Header())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/html" {
+		t.Errorf("Expected the upstream Content-Type text/html, got %q", got)
+	}
+	if got := rr.Header().Values("X-Served-By"); len(got) != 1 {
+		t.Errorf("Expected the response rules to be applied once, got %v", got)
+	}
+
+	// Errors raised by the gateway carry the response headers too
+	upstream.Close()
+	rr = httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("GET", "/service1", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Values("X-Served-By"); len(got) != 1 || rr.Header().Get("X-Request-Id") == "" {
+		t.Errorf("Expected the response rules on the gateway's error, got %v", rr.Header())
+	}
+}
@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// defaultHTTP3Address shares the TCP listener's port number over UDP.
+const defaultHTTP3Address = ":8080"
+
+// newHTTP3Server returns an HTTP/3 server for handler using the listener's TLS config.
+func newHTTP3Server(handler http.Handler, tlsConfig *TLSConfig, config *HTTP3Config) (*http3.Server, error) {
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("http3: tls must be configured")
+	}
+	serverTLS, err := newTLSConfig(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(serverTLS),
+		Port:      config.AdvertisedPort,
+	}, nil
+}
+
+// serveHTTP3 listens on the configured UDP address and serves HTTP/3 until the server closes.
+func (g *Gateway) serveHTTP3(server *http3.Server, config *HTTP3Config) error {
+	address := config.Address
+	if address == "" {
+		address = defaultHTTP3Address
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return fmt.Errorf("http3: %w", err)
+	}
+	g.log.Sugar().Infof("API Gateway listening for HTTP/3 on %s", conn.LocalAddr())
+	return server.Serve(conn)
+}
+
+// advertiseHTTP3 announces the HTTP/3 listener with an Alt-Svc header on responses served
+// over TCP, so clients can switch to QUIC for later requests.
+func advertiseHTTP3(handler http.Handler, server *http3.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			// Nothing is announced until the HTTP/3 listener is up
+			server.SetQUICHeaders(w.Header())
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
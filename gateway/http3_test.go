@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// waitForAltSvc returns the Alt-Svc header once the HTTP/3 server is listening.
+func waitForAltSvc(t *testing.T, server *http3.Server) string {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		header := http.Header{}
+		if server.SetQUICHeaders(header) == nil {
+			return header.Get("Alt-Svc")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("HTTP/3 server did not start")
+	return ""
+}
+
+func TestServeHTTP3_Loopback(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream.URL}},
+		endpoints:        []string{upstream.URL},
+	}
+	handler := http.HandlerFunc(g.routeHandler)
+	server, err := newHTTP3Server(handler, writeTestCertificate(t), &HTTP3Config{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	go g.serveHTTP3(server, &HTTP3Config{Address: "127.0.0.1:0"})
+	defer server.Close()
+
+	altSvc := waitForAltSvc(t, server)
+	port := regexp.MustCompile(`h3=":(\d+)"`).FindStringSubmatch(altSvc)
+	if port == nil {
+		t.Fatalf("Expected an h3 Alt-Svc entry, got %q", altSvc)
+	}
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	resp, err := (&http.Client{Transport: transport}).Get("https://127.0.0.1:" + port[1] + "/service1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ProtoMajor != 3 || string(body) != "hello from /service1" {
+		t.Errorf("Expected the routed response over HTTP/3, got %s %q", resp.Proto, body)
+	}
+
+	// Responses over TCP advertise the HTTP/3 listener
+	rr := httptest.NewRecorder()
+	advertiseHTTP3(handler, server).ServeHTTP(rr, httptest.NewRequest("GET", "/service1", nil))
+	if got := rr.Header().Get("Alt-Svc"); got != altSvc {
+		t.Errorf("Expected Alt-Svc %q on TCP responses, got %q", altSvc, got)
+	}
+}
+
+func TestNewHTTP3Server_RequiresTLS(t *testing.T) {
+	if _, err := newHTTP3Server(http.NotFoundHandler(), nil, &HTTP3Config{}); err == nil {
+		t.Errorf("Expected an error without a TLS config")
+	}
+}
+
+func TestAdvertiseHTTP3_AdvertisedPort(t *testing.T) {
+	server, err := newHTTP3Server(http.NotFoundHandler(), writeTestCertificate(t), &HTTP3Config{AdvertisedPort: 443})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	go server.Serve(mustListenUDP(t))
+	defer server.Close()
+
+	if altSvc := waitForAltSvc(t, server); !regexp.MustCompile(`^h3=":443"`).MatchString(altSvc) {
+		t.Errorf("Expected the advertised port in Alt-Svc, got %q", altSvc)
+	}
+}
+
+func mustListenUDP(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	return conn
+}
@@ -0,0 +1,237 @@
+package gateway
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	// defaultJWKSRefresh is how long fetched keys are trusted before they are fetched again.
+	defaultJWKSRefresh = 5 * time.Minute
+	// minJWKSRefetch bounds how often an unknown key ID can force a refetch.
+	minJWKSRefetch = 10 * time.Second
+	// jwksFetchTimeout bounds a single fetch of the key set.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type KeySet struct {
+	url       string
+	file      string
+	refresh   time.Duration
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// attemptedAt and err record the last fetch, successful or not, so a failing issuer
+	// is not asked again on every request
+	attemptedAt time.Time
+	err         error
+	fetching    chan struct{}
+	client      *http.Client
+	mux         sync.Mutex
+	log         *log.Logger
+}
+
+// NewKeySet initializes a KeySet loading JSON Web Keys from url, or from file if url is empty.
+// Keys are cached for refresh and fetched again early when an unknown key ID shows up,
+// so signing keys can be rotated at the issuer without restarting the gateway.
+func NewKeySet(url, file string, refresh time.Duration, log *log.Logger) *KeySet {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+	return &KeySet{
+		url:     url,
+		file:    file,
+		refresh: refresh,
+		client:  &http.Client{Timeout: jwksFetchTimeout},
+		log:     log,
+	}
+}
+
+// Key returns the public key with the given key ID.
+// An empty kid matches the only key of a single-key set.
+func (ks *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+
+	for {
+		_, known := ks.keys[kid]
+		if ks.keys != nil && time.Since(ks.fetchedAt) <= ks.refresh && (known || kid == "") {
+			break
+		}
+		if ks.fetching != nil {
+			// Keep serving known keys while another request refreshes the set
+			if known {
+				break
+			}
+			wait := ks.fetching
+			ks.mux.Unlock()
+			<-wait
+			ks.mux.Lock()
+			continue
+		}
+		// Failed fetches and unknown key IDs are retried at most every minJWKSRefetch
+		if time.Since(ks.attemptedAt) < minJWKSRefetch {
+			break
+		}
+		ks.fetch()
+	}
+
+	if ks.keys == nil {
+		return nil, ks.err
+	}
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, nil
+		}
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// fetch loads the key set without holding the lock, so that requests with known keys are
+// not blocked by a slow issuer. It must be called with the lock held.
+func (ks *KeySet) fetch() {
+	done := make(chan struct{})
+	ks.fetching = done
+	ks.attemptedAt = time.Now()
+	ks.mux.Unlock()
+
+	keys, err := ks.load()
+
+	ks.mux.Lock()
+	ks.fetching = nil
+	close(done)
+	if err != nil {
+		ks.err = err
+		// Keep serving the keys we have rather than failing every request
+		if ks.keys != nil {
+			ks.log.Sugar().Warnf("KeySet: Failed to refresh keys, using cached keys: %v", err)
+		}
+		return
+	}
+	ks.keys, ks.fetchedAt, ks.err = keys, time.Now(), nil
+}
+
+// load fetches and parses the key set.
+func (ks *KeySet) load() (map[string]crypto.PublicKey, error) {
+	data, err := ks.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			ks.log.Sugar().Warnf("KeySet: Skipping key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	ks.log.Sugar().Debugf("KeySet: Loaded %d keys", len(keys))
+	return keys, nil
+}
+
+// read returns the raw key set from the configured URL or file.
+func (ks *KeySet) read() ([]byte, error) {
+	if ks.url == "" {
+		return os.ReadFile(ks.file)
+	}
+
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS from %s: unexpected status %d", ks.url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// publicKey converts the JSON Web Key to an RSA or ECDSA public key.
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// jwksJSON renders the public halves of the given keys as a JSON Web Key Set.
+func jwksJSON(t *testing.T, keys map[string]interface{}) []byte {
+	t.Helper()
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: encode(k.N), E: encode(big.NewInt(int64(k.E)))})
+		case *ecdsa.PrivateKey:
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "EC", Kid: kid, Crv: "P-256", X: encode(k.X), Y: encode(k.Y)})
+		}
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("Failed to marshal JWKS: %v", err)
+	}
+	return data
+}
+
+func TestKeySetFromFile(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksJSON(t, map[string]interface{}{"rsa": rsaKey, "ec": ecKey}), 0o600); err != nil {
+		t.Fatalf("Failed to write JWKS: %v", err)
+	}
+
+	ks := NewKeySet("", path, 0, logger)
+	key, err := ks.Key("rsa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !rsaKey.PublicKey.Equal(key) {
+		t.Errorf("Expected RSA public key for kid rsa")
+	}
+
+	key, err = ks.Key("ec")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ecKey.PublicKey.Equal(key) {
+		t.Errorf("Expected EC public key for kid ec")
+	}
+
+	if _, err := ks.Key("missing"); err == nil {
+		t.Errorf("Expected error for unknown kid")
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var mux sync.Mutex
+	keys := map[string]interface{}{"old": oldKey}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		fetches++
+		w.Write(jwksJSON(t, keys))
+	}))
+	defer server.Close()
+
+	ks := NewKeySet(server.URL, "", time.Hour, logger)
+	if _, err := ks.Key("old"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The issuer rotates to a new key; the cached set is refetched once the minimum interval passes
+	mux.Lock()
+	keys = map[string]interface{}{"old": oldKey, "new": newKey}
+	mux.Unlock()
+	ks.attemptedAt = time.Now().Add(-minJWKSRefetch - time.Second)
+
+	key, err := ks.Key("new")
+	if err != nil {
+		t.Fatalf("Expected rotated key to be fetched, got %v", err)
+	}
+	if !newKey.PublicKey.Equal(key) {
+		t.Errorf("Expected the rotated public key")
+	}
+
+	// Unknown kids do not refetch again within the minimum interval
+	ks.Key("unknown")
+	if fetches != 2 {
+		t.Errorf("Expected 2 fetches, got %d", fetches)
+	}
+}
+
+func TestKeySetFailedFetchBackoff(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches int32
+	var failing atomic.Bool
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if failing.Load() {
+			<-release
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(jwksJSON(t, map[string]interface{}{"current": key}))
+	}))
+	defer server.Close()
+
+	ks := NewKeySet(server.URL, "", time.Hour, logger)
+	if _, err := ks.Key("current"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A slow, failing refresh does not hold up requests for known keys
+	failing.Store(true)
+	ks.attemptedAt = time.Now().Add(-minJWKSRefetch - time.Second)
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := ks.Key("rotated")
+		refreshed <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	known := make(chan error, 1)
+	go func() {
+		_, err := ks.Key("current")
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		if err != nil {
+			t.Errorf("Expected the cached key during a refresh, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected known keys to be served while the key set is fetched")
+	}
+	close(release)
+	if err := <-refreshed; err == nil {
+		t.Errorf("Expected an error for an unknown key ID")
+	}
+
+	// The failed attempt counts towards the minimum interval between fetches
+	ks.Key("rotated")
+	if fetches != 2 {
+		t.Errorf("Expected 2 fetches, got %d", fetches)
+	}
+}
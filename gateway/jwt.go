@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	log "go.uber.org/zap"
+)
+
+type JWTAuthenticator struct {
+	config     *JWTConfig
+	keys       *KeySet
+	algorithms []string
+	log        *log.Logger
+}
+
+// NewJWTAuthenticator initializes a JWTAuthenticator from config.
+// When no algorithms are configured it accepts HS256 with a secret and RS256/ES256 with a key set.
+func NewJWTAuthenticator(config *JWTConfig, log *log.Logger) (*JWTAuthenticator, error) {
+	if config.Secret == "" && config.JWKSURL == "" && config.JWKSFile == "" {
+		return nil, errors.New("jwt: one of secret, jwksURL or jwksFile is required")
+	}
+
+	auth := &JWTAuthenticator{
+		config:     config,
+		algorithms: config.Algorithms,
+		log:        log,
+	}
+	if config.JWKSURL != "" || config.JWKSFile != "" {
+		auth.keys = NewKeySet(config.JWKSURL, config.JWKSFile, config.JWKSRefresh, log)
+	}
+
+	if len(auth.algorithms) == 0 {
+		if config.Secret != "" {
+			auth.algorithms = append(auth.algorithms, "HS256")
+		}
+		if auth.keys != nil {
+			auth.algorithms = append(auth.algorithms, "RS256", "ES256")
+		}
+	}
+	return auth, nil
+}
+
+// Authenticate validates the bearer token on the request.
+func (ja *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	scheme, tokenString, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := ja.Verify(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Claims: claims, Headers: make(map[string]string)}
+	identity.Subject, _ = claims.GetSubject()
+	// Mapped headers are always present so that missing claims still clear client-supplied values
+	for claim, header := range ja.config.ClaimHeaders {
+		identity.Headers[header], _ = claimString(claims[claim])
+	}
+	return identity, nil
+}
+
+// Verify checks the token's signature, issuer, audience and expiry and returns its claims.
+func (ja *JWTAuthenticator) Verify(tokenString string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(ja.algorithms),
+		jwt.WithLeeway(ja.config.ClockSkew),
+		jwt.WithExpirationRequired(),
+	}
+	if ja.config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(ja.config.Issuer))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, ja.keyFunc, options...); err != nil {
+		return nil, err
+	}
+	if err := ja.checkAudience(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Challenge returns the WWW-Authenticate header value for bearer tokens.
+func (ja *JWTAuthenticator) Challenge() string {
+	return `Bearer realm="gateway"`
+}
+
+// keyFunc picks the verification key for the token's algorithm and key ID.
+func (ja *JWTAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if ja.config.Secret == "" {
+			return nil, errors.New("no secret configured for HMAC tokens")
+		}
+		return []byte(ja.config.Secret), nil
+	default:
+		if ja.keys == nil {
+			return nil, errors.New("no key set configured")
+		}
+		kid, _ := token.Header["kid"].(string)
+		return ja.keys.Key(kid)
+	}
+}
+
+// checkAudience requires the token to be issued for one of the configured audiences.
+func (ja *JWTAuthenticator) checkAudience(claims jwt.MapClaims) error {
+	if len(ja.config.Audiences) == 0 {
+		return nil
+	}
+
+	audiences, err := claims.GetAudience()
+	if err != nil {
+		return err
+	}
+	for _, aud := range audiences {
+		for _, allowed := range ja.config.Audiences {
+			if aud == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("token audience %v is not allowed", audiences)
+}
+
+// claimString renders a claim value as a header value. Lists are joined with commas.
+func claimString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := claimString(item); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ","), true
+	default:
+		return "", false
+	}
+}
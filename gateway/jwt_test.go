@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWTAuthenticator_JWKS(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwksJSON(t, map[string]interface{}{"rsa": rsaKey, "ec": ecKey}))
+	}))
+	defer server.Close()
+
+	auth, err := NewJWTAuthenticator(&JWTConfig{
+		Issuer:       "https://issuer.example.com",
+		Audiences:    []string{"gateway"},
+		JWKSURL:      server.URL,
+		ClaimHeaders: map[string]string{"sub": "X-User-ID", "groups": "X-User-Groups", "email": "X-User-Email"},
+	}, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{
+		"iss":    "https://issuer.example.com",
+		"aud":    "gateway",
+		"sub":    "user-1",
+		"groups": []string{"admin", "dev"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+
+	for name, token := range map[string]string{
+		"RS256": signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", claims),
+		"ES256": signToken(t, jwt.SigningMethodES256, ecKey, "ec", claims),
+	} {
+		identity, err := auth.Authenticate(bearerRequest(token))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if identity.Subject != "user-1" {
+			t.Errorf("%s: expected subject user-1, got %s", name, identity.Subject)
+		}
+		if identity.Headers["X-User-ID"] != "user-1" || identity.Headers["X-User-Groups"] != "admin,dev" {
+			t.Errorf("%s: unexpected claim headers %v", name, identity.Headers)
+		}
+		if value, ok := identity.Headers["X-User-Email"]; !ok || value != "" {
+			t.Errorf("%s: expected missing claim to map to an empty header, got %q", name, value)
+		}
+	}
+}
+
+func TestJWTAuthenticator_Rejections(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, jwksJSON(t, map[string]interface{}{"rsa": rsaKey}), 0o600)
+
+	auth, err := NewJWTAuthenticator(&JWTConfig{
+		Issuer:     "https://issuer.example.com",
+		Audiences:  []string{"gateway"},
+		Algorithms: []string{"RS256"},
+		ClockSkew:  time.Minute,
+		JWKSFile:   path,
+	}, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": "https://issuer.example.com", "aud": "gateway", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	withinSkew := valid()
+	withinSkew["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	if _, err := auth.Authenticate(bearerRequest(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", withinSkew))); err != nil {
+		t.Errorf("Expected token expired within the clock skew to be accepted, got %v", err)
+	}
+
+	expired := valid()
+	expired["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+	wrongIssuer := valid()
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := valid()
+	wrongAudience["aud"] = "other"
+	noExpiry := valid()
+	delete(noExpiry, "exp")
+
+	cases := map[string]*http.Request{
+		"missing token":  httptest.NewRequest("GET", "/service1", nil),
+		"expired":        bearerRequest(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", expired)),
+		"wrong issuer":   bearerRequest(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", wrongIssuer)),
+		"wrong audience": bearerRequest(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", wrongAudience)),
+		"no expiry":      bearerRequest(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", noExpiry)),
+		"wrong key":      bearerRequest(signToken(t, jwt.SigningMethodRS256, otherKey, "rsa", valid())),
+		"wrong alg":      bearerRequest(signToken(t, jwt.SigningMethodHS256, []byte("secret"), "rsa", valid())),
+	}
+	for name, req := range cases {
+		if _, err := auth.Authenticate(req); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+}
+
+func TestJWTAuthenticator_HS256(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	auth, err := NewJWTAuthenticator(&JWTConfig{Secret: "secret"}, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()}
+	if _, err := auth.Authenticate(bearerRequest(signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", claims))); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := auth.Authenticate(bearerRequest(signToken(t, jwt.SigningMethodHS256, []byte("other"), "", claims))); err == nil {
+		t.Errorf("Expected token signed with another secret to be rejected")
+	}
+}
+
+func TestNewJWTAuthenticator_NoKeys(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	if _, err := NewJWTAuthenticator(&JWTConfig{Issuer: "https://issuer.example.com"}, logger); err == nil {
+		t.Errorf("Expected error when no key source is configured")
+	}
+}
+
+func TestClaimString(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"alice", "alice"},
+		{float64(1700000000), "1700000000"},
+		{float64(123456789012), "123456789012"},
+		{1.5, "1.5"},
+		{true, "true"},
+		{[]interface{}{"admin", float64(42)}, "admin,42"},
+	}
+	for _, c := range cases {
+		if value, ok := claimString(c.value); !ok || value != c.expected {
+			t.Errorf("Expected %q for %v, got %q", c.expected, c.value, value)
+		}
+	}
+}
+
+func TestRouteHandler_JWT(t *testing.T) {
+	var upstreamUser string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamUser = r.Header.Get("X-User-ID")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	g := createTestGateway("")
+	auth, _ := NewJWTAuthenticator(&JWTConfig{Secret: "secret", ClaimHeaders: map[string]string{"sub": "X-User-ID"}}, g.log)
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+		endpoints:        []string{server.URL},
+		authenticator:    auth,
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/service1", nil)
+	req.Header.Set("X-User-ID", "spoofed")
+	g.routeHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized, got %d", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected WWW-Authenticate header to be set")
+	}
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()})
+	req = bearerRequest(token)
+	req.Header.Set("X-User-ID", "spoofed")
+	w = httptest.NewRecorder()
+	g.routeHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", w.Code)
+	}
+	if upstreamUser != "user-1" {
+		t.Errorf("Expected upstream to receive X-User-ID user-1, got %q", upstreamUser)
+	}
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultReadHeaderTimeout bounds reading request headers when no server timeout is configured,
+// so slow clients cannot hold connections open by trickling headers.
+const defaultReadHeaderTimeout = 10 * time.Second
+
+var (
+	// errResponseTooLarge reports an endpoint response over the service's maxResponseSize.
+	errResponseTooLarge = errors.New("response exceeds the maximum size")
+	// errRequestBodyIdle reports a client that stopped sending its request body.
+	errRequestBodyIdle = errors.New("request body idle timeout")
+	// errResponseBodyIdle reports an endpoint that stopped sending its response body.
+	errResponseBodyIdle = errors.New("response body idle timeout")
+)
+
+type RequestLimits struct {
+	maxRequestBodySize int64
+	maxResponseSize    int64
+	timeout            time.Duration
+	idleTimeout        time.Duration
+}
+
+// NewRequestLimits initializes RequestLimits from config.
+func NewRequestLimits(config *LimitsConfig) (*RequestLimits, error) {
+	if config.MaxRequestBodySize < 0 || config.MaxResponseSize < 0 || config.Timeout < 0 || config.IdleTimeout < 0 {
+		return nil, fmt.Errorf("limits: sizes and timeouts must not be negative")
+	}
+	return &RequestLimits{
+		maxRequestBodySize: config.MaxRequestBodySize,
+		maxResponseSize:    config.MaxResponseSize,
+		timeout:            config.Timeout,
+		idleTimeout:        config.IdleTimeout,
+	}, nil
+}
+
+// applyLimits bounds the request body and the time to serve r. It returns nil after writing a
+// 413 response for a body declared larger than allowed. The returned function must be called
+// once the request is served.
+func (g *Gateway) applyLimits(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) (*http.Request, context.CancelFunc) {
+	limits := service.limits
+	if max := limits.maxRequestBodySize; max > 0 {
+		if r.ContentLength > max {
+			g.log.Sugar().Infof("Request body of %d bytes for service %s exceeds %d bytes", r.ContentLength, service.serviceName, max)
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return nil, nil
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+	if limits.idleTimeout > 0 && r.Body != nil && r.Body != http.NoBody {
+		body := &clientIdleBody{ReadCloser: r.Body, controller: http.NewResponseController(w), timeout: limits.idleTimeout}
+		r = r.WithContext(context.WithValue(r.Context(), clientIdleBodyKey{}, body))
+		r.Body = body
+	}
+	if limits.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), limits.timeout)
+		return r.WithContext(ctx), cancel
+	}
+	return r, func() {}
+}
+
+// limitResponse applies the service's response limits to resp. Once resp's body has been
+// idle for the idle timeout, cancel is called to abort the upstream request.
+func (rl *RequestLimits) limitResponse(resp *http.Response, cancel context.CancelFunc) error {
+	if rl.maxResponseSize > 0 {
+		if resp.ContentLength > rl.maxResponseSize {
+			return fmt.Errorf("%w: %d bytes", errResponseTooLarge, resp.ContentLength)
+		}
+		resp.Body = &maxResponseBody{ReadCloser: resp.Body, remaining: rl.maxResponseSize}
+	}
+	if rl.idleTimeout > 0 {
+		resp.Body = newIdleResponseBody(resp.Body, rl.idleTimeout, cancel)
+	}
+	return nil
+}
+
+// clientIdleBody fails reads of the request body once the client has sent nothing for the
+// timeout, using the connection's read deadline.
+type clientIdleBody struct {
+	io.ReadCloser
+	controller *http.ResponseController
+	timeout    time.Duration
+	timedOut   atomic.Bool
+}
+
+type clientIdleBodyKey struct{}
+
+// requestBodyIdled reports whether the client of r stopped sending its body. The server
+// cancels the request once the read deadline passes, so the upstream request may fail with a
+// canceled context rather than the body's error.
+func requestBodyIdled(r *http.Request) bool {
+	body, ok := r.Context().Value(clientIdleBodyKey{}).(*clientIdleBody)
+	return ok && body.timedOut.Load()
+}
+
+func (b *clientIdleBody) Read(p []byte) (int, error) {
+	// Writers without deadline support, such as recorders in tests, read without a timeout
+	b.controller.SetReadDeadline(time.Now().Add(b.timeout))
+	n, err := b.ReadCloser.Read(p)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		b.timedOut.Store(true)
+		return n, fmt.Errorf("%w: no data for %v", errRequestBodyIdle, b.timeout)
+	}
+	if err != nil {
+		// The server keeps reading the connection in the background once the body is done,
+		// so a deadline left in place would cancel the request while the upstream is slow
+		b.controller.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// Close clears the read deadline of a body the upstream did not read in full. After a
+// timeout it stays, so the server cancels the request rather than waiting on the upstream.
+func (b *clientIdleBody) Close() error {
+	if !b.timedOut.Load() {
+		b.controller.SetReadDeadline(time.Time{})
+	}
+	return b.ReadCloser.Close()
+}
+
+// maxResponseBody fails once more than the allowed number of bytes have been read.
+type maxResponseBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *maxResponseBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		return int(b.remaining), errResponseTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// idleResponseBody aborts the upstream request once its body has been idle for the timeout.
+type idleResponseBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	mux     sync.Mutex
+	idle    bool
+}
+
+func newIdleResponseBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleResponseBody {
+	b := &idleResponseBody{ReadCloser: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.mux.Lock()
+		b.idle = true
+		b.mux.Unlock()
+		cancel()
+	})
+	return b
+}
+
+func (b *idleResponseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mux.Lock()
+	idle := b.idle
+	b.mux.Unlock()
+	if idle {
+		return n, fmt.Errorf("%w: no data for %v", errResponseBodyIdle, b.timeout)
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleResponseBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
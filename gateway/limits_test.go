@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createLimitsGateway routes service1 to upstream with the given limits.
+func createLimitsGateway(t *testing.T, upstream string, config *LimitsConfig) *Gateway {
+	limits, err := NewRequestLimits(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstream}},
+		endpoints:        []string{upstream},
+		limits:           limits,
+	}
+	return g
+}
+
+func TestRouteHandler_MaxRequestBodySize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+	g := createLimitsGateway(t, upstream.URL, &LimitsConfig{MaxRequestBodySize: 8})
+
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("POST", "/service1", strings.NewReader("small")))
+	if rr.Code != http.StatusOK || rr.Body.String() != "small" {
+		t.Errorf("Expected a body within the limit to be forwarded, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("POST", "/service1", strings.NewReader("far too large")))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d for a declared length over the limit, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/service1", io.MultiReader(strings.NewReader("far too large")))
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	g.routeHandler(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d for a streamed body over the limit, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
+
+func TestRouteHandler_MaxResponseSize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "" {
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer upstream.Close()
+	g := createLimitsGateway(t, upstream.URL, &LimitsConfig{MaxResponseSize: 10})
+	gateway := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL + "/service1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected status %d for a declared length over the limit, got %d", http.StatusBadGateway, resp.StatusCode)
+	}
+
+	if body, err := getAborted(gateway.URL + "/service1?stream=1"); err == nil || len(body) > 10 {
+		t.Errorf("Expected a streamed response over the limit to be cut short, got %d bytes and %v", len(body), err)
+	}
+}
+
+// getAborted fetches url, returning the body read and the error of a response aborted
+// before or after its headers.
+func getAborted(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func TestRouteHandler_Timeout(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(unblock)
+	g := createLimitsGateway(t, upstream.URL, &LimitsConfig{Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("GET", "/service1", nil))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status %d, got %d", http.StatusGatewayTimeout, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "service service1 did not respond") {
+		t.Errorf("Expected the timeout to name the service, got %q", rr.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the request to end at its timeout, took %v", elapsed)
+	}
+}
+
+func TestRouteHandler_ResponseBodyIdleTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(unblock)
+	g := createLimitsGateway(t, upstream.URL, &LimitsConfig{IdleTimeout: 100 * time.Millisecond})
+	gateway := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	defer gateway.Close()
+
+	start := time.Now()
+	if _, err := getAborted(gateway.URL + "/service1"); err == nil {
+		t.Errorf("Expected the stalled response to be aborted")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the idle response to be aborted, took %v", elapsed)
+	}
+}
+
+func TestRouteHandler_RequestBodyIdleTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+	}))
+	defer upstream.Close()
+	g := createLimitsGateway(t, upstream.URL, &LimitsConfig{IdleTimeout: 100 * time.Millisecond})
+	gateway := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	defer gateway.Close()
+
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer conn.Close()
+	// Declare a body but send only part of it
+	conn.Write([]byte("POST /service1 HTTP/1.1\r\nHost: gateway\r\nContent-Length: 100\r\n\r\npartial"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Expected a response to the stalled upload, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestTimeout, resp.StatusCode)
+	}
+}
+
+func TestRouteHandler_RequestBodyIdleTimeoutSlowUpstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		time.Sleep(400 * time.Millisecond)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+	g := createLimitsGateway(t, upstream.URL, &LimitsConfig{IdleTimeout: 100 * time.Millisecond})
+	gateway := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	defer gateway.Close()
+
+	resp, err := http.Post(gateway.URL+"/service1", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("Expected a slow upstream to answer once the body was sent, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestServe_ReadHeaderTimeout(t *testing.T) {
+	g := createTestGateway("")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go g.serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil, false, &ServerConfig{ReadHeaderTimeout: 100 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer conn.Close()
+	// A slowloris client trickles its headers and never finishes them
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: gateway\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the connection to be closed at the header timeout, took %v", elapsed)
+	}
+}
+
+func TestNewRequestLimits_Errors(t *testing.T) {
+	for _, config := range []*LimitsConfig{{MaxRequestBodySize: -1}, {MaxResponseSize: -1}, {Timeout: -time.Second}, {IdleTimeout: -time.Second}} {
+		if _, err := NewRequestLimits(config); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Route struct {
+	service    string
+	priority   int
+	pathPrefix string
+	methods    []string
+	host       string
+	headers    []valueMatcher
+	query      []valueMatcher
+}
+
+// valueMatcher checks a header or query parameter against one condition.
+type valueMatcher struct {
+	name  string
+	exact string
+	regex *regexp.Regexp
+	// present is set for presence conditions: true requires the value, false its absence
+	present *bool
+}
+
+// NewRoute initializes a Route from config.
+func NewRoute(config *RouteConfig) (*Route, error) {
+	if config.Service == "" {
+		return nil, fmt.Errorf("route: service is required")
+	}
+	match := config.Match
+	route := &Route{service: config.Service, priority: config.Priority, pathPrefix: match.PathPrefix, host: strings.ToLower(match.Host)}
+	if route.pathPrefix != "" && !strings.HasPrefix(route.pathPrefix, "/") {
+		return nil, fmt.Errorf("route to %s: pathPrefix must start with /", config.Service)
+	}
+	for _, method := range match.Methods {
+		route.methods = append(route.methods, strings.ToUpper(method))
+	}
+	for _, header := range match.Headers {
+		matcher, err := newValueMatcher(header)
+		if err != nil {
+			return nil, fmt.Errorf("route to %s: header %w", config.Service, err)
+		}
+		route.headers = append(route.headers, matcher)
+	}
+	for _, param := range match.Query {
+		matcher, err := newValueMatcher(param)
+		if err != nil {
+			return nil, fmt.Errorf("route to %s: query %w", config.Service, err)
+		}
+		route.query = append(route.query, matcher)
+	}
+	return route, nil
+}
+
+// newValueMatcher builds the matcher of a header or query condition, which must set exactly
+// one of exact, regex and present.
+func newValueMatcher(config ValueMatchConfig) (valueMatcher, error) {
+	matcher := valueMatcher{name: config.Name, exact: config.Exact, present: config.Present}
+	if config.Name == "" {
+		return matcher, fmt.Errorf("condition: name is required")
+	}
+	conditions := 0
+	if config.Exact != "" {
+		conditions++
+	}
+	if config.Regex != "" {
+		conditions++
+		re, err := regexp.Compile("^(?:" + config.Regex + ")$")
+		if err != nil {
+			return matcher, fmt.Errorf("%s: invalid regex: %w", config.Name, err)
+		}
+		matcher.regex = re
+	}
+	if config.Present != nil {
+		conditions++
+	}
+	if conditions != 1 {
+		return matcher, fmt.Errorf("%s: exactly one of exact, regex and present is required", config.Name)
+	}
+	return matcher, nil
+}
+
+// sortRoutes orders routes by descending priority, keeping the configured order for routes
+// of equal priority.
+func sortRoutes(routes []*Route) {
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].priority > routes[j].priority })
+}
+
+// Matches reports whether r meets all of the route's conditions.
+func (rt *Route) Matches(r *http.Request) bool {
+	if rt.pathPrefix != "" && !hasPathPrefix(r.URL.Path, rt.pathPrefix) {
+		return false
+	}
+	if len(rt.methods) > 0 && !containsString(rt.methods, r.Method) {
+		return false
+	}
+	if rt.host != "" && !matchHost(rt.host, strings.ToLower(stripPort(r.Host))) {
+		return false
+	}
+	for _, header := range rt.headers {
+		if !header.matches(r.Header.Values(header.name)) {
+			return false
+		}
+	}
+	if len(rt.query) > 0 {
+		query := r.URL.Query()
+		for _, param := range rt.query {
+			if !param.matches(query[param.name]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matches reports whether any of values meets the condition, or for presence conditions
+// whether there are values as required.
+func (vm valueMatcher) matches(values []string) bool {
+	if vm.present != nil {
+		return (len(values) > 0) == *vm.present
+	}
+	for _, value := range values {
+		if vm.regex != nil && vm.regex.MatchString(value) || vm.regex == nil && value == vm.exact {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix reports whether path is prefix or lies below it.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// matchHost reports whether host is pattern, or a direct subdomain of a "*." wildcard pattern.
+func matchHost(pattern, host string) bool {
+	if parent, ok := strings.CutPrefix(pattern, "*."); ok {
+		_, hostParent, found := strings.Cut(host, ".")
+		return found && hostParent == parent
+	}
+	return host == pattern
+}
+
+// matchedHost returns the host of r when the route matched on it, so that responses cached
+// for different hosts are kept apart. It returns "" for a nil route.
+func (rt *Route) matchedHost(r *http.Request) string {
+	if rt == nil || rt.host == "" {
+		return ""
+	}
+	return strings.ToLower(stripPort(r.Host))
+}
+
+// matchRoute returns the first route matching r, in priority order, and its service.
+func (g *Gateway) matchRoute(r *http.Request) (*Route, *GatewayServiceConfig, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for _, route := range g.routes {
+		if route.Matches(r) {
+			service, exists := g.serviceRegistry[route.service]
+			return route, service, exists
+		}
+	}
+	return nil, nil, false
+}
+
+// matchServicePrefix routes a path whose first segment names a service to that service, so
+// that sub-paths such as /serviceA/items reach serviceA.
+func (g *Gateway) matchServicePrefix(path string) (*GatewayServiceConfig, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	first, _, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !found {
+		return nil, false
+	}
+	service, exists := g.serviceRegistry[first]
+	return service, exists
+}
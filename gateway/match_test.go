@@ -0,0 +1,198 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestRoute(t *testing.T, config *RouteConfig) *Route {
+	route, err := NewRoute(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return route
+}
+
+func TestRoute_Matches(t *testing.T) {
+	present, absent := true, false
+	route := newTestRoute(t, &RouteConfig{Service: "acme", Match: MatchConfig{
+		PathPrefix: "/serviceA",
+		Methods:    []string{"get", "HEAD"},
+		Host:       "*.example.com",
+		Headers: []ValueMatchConfig{
+			{Name: "X-Tenant", Exact: "acme"},
+			{Name: "X-Version", Regex: "v[0-9]+"},
+			{Name: "Authorization", Present: &present},
+		},
+		Query: []ValueMatchConfig{{Name: "debug", Present: &absent}},
+	}})
+	matching := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://api.example.com:8080/serviceA/items", nil)
+		req.Header.Set("X-Tenant", "acme")
+		req.Header.Set("X-Version", "v2")
+		req.Header.Set("Authorization", "Bearer token")
+		return req
+	}
+	if !route.Matches(matching()) {
+		t.Errorf("Expected the request to match")
+	}
+
+	cases := map[string]func(*http.Request){
+		"path":             func(r *http.Request) { r.URL.Path = "/serviceAB/items" },
+		"method":           func(r *http.Request) { r.Method = "POST" },
+		"host":             func(r *http.Request) { r.Host = "example.com" },
+		"nested host":      func(r *http.Request) { r.Host = "a.api.example.com" },
+		"exact header":     func(r *http.Request) { r.Header.Set("X-Tenant", "acme-corp") },
+		"regex header":     func(r *http.Request) { r.Header.Set("X-Version", "v2-beta") },
+		"missing header":   func(r *http.Request) { r.Header.Del("Authorization") },
+		"absent parameter": func(r *http.Request) { r.URL.RawQuery = "debug=1" },
+	}
+	for name, change := range cases {
+		req := matching()
+		change(req)
+		if route.Matches(req) {
+			t.Errorf("Expected no match when the %s differs", name)
+		}
+	}
+}
+
+func TestRoute_QueryValues(t *testing.T) {
+	route := newTestRoute(t, &RouteConfig{Service: "reports", Match: MatchConfig{Query: []ValueMatchConfig{{Name: "format", Regex: "csv|xlsx"}}}})
+	for query, expected := range map[string]bool{"format=csv": true, "format=json&format=xlsx": true, "format=json": false, "": false} {
+		req := httptest.NewRequest("GET", "/serviceA?"+query, nil)
+		if got := route.Matches(req); got != expected {
+			t.Errorf("Expected match %v for %q, got %v", expected, query, got)
+		}
+	}
+}
+
+func TestNewRoute_Errors(t *testing.T) {
+	present := true
+	invalid := map[string]*RouteConfig{
+		"no service":     {Match: MatchConfig{Methods: []string{"GET"}}},
+		"relative path":  {Service: "a", Match: MatchConfig{PathPrefix: "serviceA"}},
+		"no condition":   {Service: "a", Match: MatchConfig{Headers: []ValueMatchConfig{{Name: "X-Tenant"}}}},
+		"two conditions": {Service: "a", Match: MatchConfig{Headers: []ValueMatchConfig{{Name: "X-Tenant", Exact: "acme", Present: &present}}}},
+		"unnamed":        {Service: "a", Match: MatchConfig{Query: []ValueMatchConfig{{Exact: "1"}}}},
+		"invalid regex":  {Service: "a", Match: MatchConfig{Query: []ValueMatchConfig{{Name: "q", Regex: "("}}}},
+	}
+	for name, config := range invalid {
+		if _, err := NewRoute(config); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestRouteHandler_MatchRoutes(t *testing.T) {
+	newService := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+	}
+	g := createTestGateway("")
+	for _, name := range []string{"serviceA", "serviceA-read", "serviceA-acme"} {
+		server := newService(name)
+		defer server.Close()
+		g.serviceRegistry[name] = &GatewayServiceConfig{
+			serviceName:      name,
+			loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+			endpoints:        []string{server.URL},
+		}
+	}
+	g.routes = []*Route{
+		newTestRoute(t, &RouteConfig{Service: "serviceA-read", Match: MatchConfig{PathPrefix: "/serviceA", Methods: []string{"GET"}}}),
+		newTestRoute(t, &RouteConfig{Service: "serviceA-acme", Priority: 10, Match: MatchConfig{PathPrefix: "/serviceA", Headers: []ValueMatchConfig{{Name: "X-Tenant", Exact: "acme"}}}}),
+	}
+	sortRoutes(g.routes)
+
+	cases := []struct {
+		method, tenant, expected string
+	}{
+		{"GET", "", "serviceA-read /serviceA/items"},
+		{"POST", "", "serviceA /serviceA/items"},
+		{"GET", "acme", "serviceA-acme /serviceA/items"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/serviceA/items", nil)
+		if c.tenant != "" {
+			req.Header.Set("X-Tenant", c.tenant)
+		}
+		rr := httptest.NewRecorder()
+		g.routeHandler(rr, req)
+		if rr.Body.String() != c.expected {
+			t.Errorf("Expected %q for %s with tenant %q, got %q", c.expected, c.method, c.tenant, rr.Body.String())
+		}
+	}
+}
+
+func TestRouteHandler_SubPath(t *testing.T) {
+	var upstreamPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+		endpoints:        []string{server.URL},
+	}
+
+	w := httptest.NewRecorder()
+	g.routeHandler(w, httptest.NewRequest("GET", "/service1/items/1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", w.Code)
+	}
+	if upstreamPath != "/service1/items/1" {
+		t.Errorf("Expected upstream path /service1/items/1, got %s", upstreamPath)
+	}
+}
+
+func TestLoadConfig_Routes(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(service string) {
+		content := `
+routes:
+  - service: serviceA-read
+    match:
+      methods: [GET]
+  - service: ` + service + `
+    priority: 5
+    match:
+      headers:
+        - name: X-Tenant
+          exact: acme
+services:
+  serviceA-read:
+    endpoints:
+      - http://localhost:8081
+    loadBalancer: round-robin
+  serviceA-acme:
+    endpoints:
+      - http://localhost:8082
+    loadBalancer: round-robin
+`
+		if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+
+	g := createTestGateway(configPath)
+	writeConfig("serviceA-acme")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if len(g.routes) != 2 || g.routes[0].service != "serviceA-acme" {
+		t.Errorf("Expected the higher priority route first, got %v", g.routes)
+	}
+
+	writeConfig("missing")
+	if err := g.loadConfig(); err == nil {
+		t.Errorf("Expected an error for a route to an unknown service")
+	}
+}
@@ -0,0 +1,280 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	// defaultMirrorMaxBodySize bounds the request body buffered for the shadow service.
+	defaultMirrorMaxBodySize = 1 << 20
+	// defaultMirrorTimeout bounds a mirrored request when no timeout is configured.
+	defaultMirrorTimeout = 10 * time.Second
+	// defaultMirrorMaxInFlight bounds the mirrored requests waiting on the shadow service.
+	defaultMirrorMaxInFlight = 100
+	// mirrorHeader tells the shadow service which service a mirrored request was sent to.
+	mirrorHeader = "X-Gateway-Mirror"
+)
+
+type Mirror struct {
+	service     string
+	percentage  float64
+	maxBodySize int64
+	timeout     time.Duration
+	inFlight    chan struct{}
+	stats       *MirrorStats
+	log         *log.Logger
+}
+
+// MirrorStats counts the outcome of mirrored requests. Statuses counts the requests by
+// "<primary status>/<shadow status>", such as "200/500".
+type MirrorStats struct {
+	Shadow     string           `json:"shadow"`
+	Mirrored   int64            `json:"mirrored"`
+	Matched    int64            `json:"matched"`
+	Mismatched int64            `json:"mismatched"`
+	Failed     int64            `json:"failed"`
+	Skipped    int64            `json:"skipped"`
+	Dropped    int64            `json:"dropped"`
+	Statuses   map[string]int64 `json:"statuses"`
+	mux        sync.Mutex
+}
+
+// NewMirror initializes a Mirror sending a copy of the service's requests to the shadow
+// service named in config.
+func NewMirror(config *MirrorConfig, log *log.Logger) (*Mirror, error) {
+	if config.Service == "" {
+		return nil, fmt.Errorf("mirror: service is required")
+	}
+	if config.Percentage <= 0 || config.Percentage > 100 {
+		return nil, fmt.Errorf("mirror: percentage must be between 0 and 100")
+	}
+	mirror := &Mirror{
+		service:     config.Service,
+		percentage:  config.Percentage,
+		maxBodySize: config.MaxBodySize,
+		timeout:     config.Timeout,
+		stats:       &MirrorStats{Shadow: config.Service, Statuses: make(map[string]int64)},
+		log:         log,
+	}
+	if mirror.maxBodySize <= 0 {
+		mirror.maxBodySize = defaultMirrorMaxBodySize
+	}
+	if mirror.timeout <= 0 {
+		mirror.timeout = defaultMirrorTimeout
+	}
+	maxInFlight := config.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMirrorMaxInFlight
+	}
+	mirror.inFlight = make(chan struct{}, maxInFlight)
+	return mirror, nil
+}
+
+// keepStats carries the statistics of the mirror a reloaded config replaces, as long as it
+// mirrored to the same shadow service.
+func (m *Mirror) keepStats(old *Mirror) {
+	if old != nil && old.service == m.service {
+		m.stats = old.stats
+	}
+}
+
+// Sample reports whether a request falls within the mirrored percentage.
+func (m *Mirror) Sample() bool {
+	return m.percentage >= 100 || rand.Float64()*100 < m.percentage
+}
+
+// mirrorBody records the request body as the primary request reads it.
+type mirrorBody struct {
+	io.ReadCloser
+	limit int64
+	mux   sync.Mutex
+	buf   bytes.Buffer
+	// complete is set once the body was read to the end within the limit
+	complete bool
+	overflow bool
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow {
+		b.complete = true
+	}
+	return n, err
+}
+
+// bytes returns the recorded body, or false when the primary request did not read all of it.
+func (b *mirrorBody) bytes() ([]byte, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Bytes(), b.complete
+}
+
+// statusRecorder remembers the status of the response written for the primary request.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+func (sr *statusRecorder) Flush() {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	http.NewResponseController(sr.ResponseWriter).Flush()
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// startMirror prepares r to be mirrored once the primary response is written to the returned
+// writer. The returned function sends the copy and must be called after the primary request
+// completes; it returns immediately.
+func (g *Gateway) startMirror(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) (http.ResponseWriter, *http.Request, func()) {
+	mirror := service.mirror
+	if r.ContentLength > mirror.maxBodySize {
+		mirror.stats.record(func(s *MirrorStats) { s.Skipped++ })
+		return w, r, func() {}
+	}
+	var body *mirrorBody
+	if r.ContentLength != 0 && r.Body != nil && r.Body != http.NoBody {
+		body = &mirrorBody{ReadCloser: r.Body, limit: mirror.maxBodySize}
+		r.Body = body
+	}
+	recorder := &statusRecorder{ResponseWriter: w}
+
+	return recorder, r, func() {
+		var content []byte
+		if body != nil {
+			var complete bool
+			if content, complete = body.bytes(); !complete {
+				// The primary request ended before its body was read, or the body was too large
+				mirror.stats.record(func(s *MirrorStats) { s.Skipped++ })
+				return
+			}
+			content = bytes.Clone(content)
+		}
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		select {
+		case mirror.inFlight <- struct{}{}:
+		default:
+			mirror.stats.record(func(s *MirrorStats) { s.Dropped++ })
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), mirror.timeout)
+		shadow := r.Clone(ctx)
+		go func() {
+			defer func() { <-mirror.inFlight }()
+			defer cancel()
+			g.sendMirror(shadow, content, body != nil, service.serviceName, mirror, status)
+		}()
+	}
+}
+
+// sendMirror sends a mirrored request to the shadow service, discards its response and
+// records how its status compares to the primary's.
+func (g *Gateway) sendMirror(r *http.Request, body []byte, hasBody bool, serviceName string, mirror *Mirror, primaryStatus int) {
+	if hasBody {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	} else {
+		r.Body = http.NoBody
+	}
+	r.Header.Set(mirrorHeader, serviceName)
+
+	g.lock.Lock()
+	shadow, exists := g.serviceRegistry[mirror.service]
+	g.lock.Unlock()
+	if !exists || shadow.loadBalancerType == nil {
+		g.log.Sugar().Infof("Mirror of service %s: shadow service %s is unavailable", serviceName, mirror.service)
+		mirror.stats.record(func(s *MirrorStats) { s.Mirrored++; s.Failed++ })
+		return
+	}
+
+	resp, release, err := g.forward(r, shadow)
+	if err != nil {
+		g.log.Sugar().Infof("Mirror of service %s to %s failed: %v", serviceName, mirror.service, err)
+		mirror.stats.record(func(s *MirrorStats) { s.Mirrored++; s.Failed++ })
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	release()
+
+	mirror.stats.record(func(s *MirrorStats) {
+		s.Mirrored++
+		if resp.StatusCode == primaryStatus {
+			s.Matched++
+		} else {
+			s.Mismatched++
+		}
+		s.Statuses[strconv.Itoa(primaryStatus)+"/"+strconv.Itoa(resp.StatusCode)]++
+	})
+	if resp.StatusCode != primaryStatus {
+		g.log.Sugar().Infof("Mirror of service %s: %s %s answered %d by %s and %d by %s", serviceName, r.Method, r.URL.Path, primaryStatus, serviceName, resp.StatusCode, mirror.service)
+	}
+}
+
+// record updates the statistics under their lock.
+func (s *MirrorStats) record(update func(*MirrorStats)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	update(s)
+}
+
+// snapshot returns a copy of the statistics that is safe to encode.
+func (s *MirrorStats) snapshot() *MirrorStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	copied := &MirrorStats{
+		Shadow:     s.Shadow,
+		Mirrored:   s.Mirrored,
+		Matched:    s.Matched,
+		Mismatched: s.Mismatched,
+		Failed:     s.Failed,
+		Skipped:    s.Skipped,
+		Dropped:    s.Dropped,
+		Statuses:   make(map[string]int64, len(s.Statuses)),
+	}
+	for key, count := range s.Statuses {
+		copied.Statuses[key] = count
+	}
+	return copied
+}
@@ -0,0 +1,252 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// createMirrorGateway routes service1 to primary and mirrors config.Percentage of its
+// requests to shadow.
+func createMirrorGateway(t *testing.T, primary, shadow string, config *MirrorConfig) (*Gateway, *Mirror) {
+	logger, _ := zap.NewProductionConfig().Build()
+	config.Service = "shadow"
+	mirror, err := NewMirror(config, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{primary}},
+		endpoints:        []string{primary},
+		mirror:           mirror,
+	}
+	g.serviceRegistry["shadow"] = &GatewayServiceConfig{
+		serviceName:      "shadow",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{shadow}},
+		endpoints:        []string{shadow},
+	}
+	return g, mirror
+}
+
+// waitForMirrored waits until count mirrored requests have completed.
+func waitForMirrored(t *testing.T, mirror *Mirror, count int64) *MirrorStats {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := mirror.stats.snapshot()
+		if stats.Mirrored >= count {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d mirrored requests, got %+v", count, stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRouteHandler_MirrorsRequestWithBody(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("primary:" + string(body)))
+	}))
+	defer primary.Close()
+	mirrored := make(chan *http.Request, 1)
+	mirroredBody := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- r
+		mirroredBody <- string(body)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("shadow"))
+	}))
+	defer shadow.Close()
+
+	g, mirror := createMirrorGateway(t, primary.URL, shadow.URL, &MirrorConfig{Percentage: 100})
+	req := httptest.NewRequest("POST", "/service1/orders?dry=1", strings.NewReader(`{"id":1}`))
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != `primary:{"id":1}` {
+		t.Errorf("Expected the primary response, got %d %q", rr.Code, rr.Body.String())
+	}
+	select {
+	case r := <-mirrored:
+		if r.Method != "POST" || r.URL.Path != "/service1/orders" || r.URL.RawQuery != "dry=1" {
+			t.Errorf("Expected the mirrored request to match the original, got %s %s", r.Method, r.URL)
+		}
+		if got := r.Header.Get(mirrorHeader); got != "service1" {
+			t.Errorf("Expected %s to name service1, got %q", mirrorHeader, got)
+		}
+		if got := <-mirroredBody; got != `{"id":1}` {
+			t.Errorf("Expected the mirrored body, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the request to be mirrored")
+	}
+
+	stats := waitForMirrored(t, mirror, 1)
+	if stats.Mismatched != 1 || stats.Matched != 0 || stats.Statuses["200/500"] != 1 {
+		t.Errorf("Expected one 200/500 mismatch, got %+v", stats)
+	}
+}
+
+func TestRouteHandler_MirrorDoesNotDelayPrimary(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primary.Close()
+	unblock := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer shadow.Close()
+	defer close(unblock)
+
+	g, mirror := createMirrorGateway(t, primary.URL, shadow.URL, &MirrorConfig{Percentage: 100, MaxInFlight: 1})
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		rr := httptest.NewRecorder()
+		g.routeHandler(rr, httptest.NewRequest("GET", "/service1", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status OK, got %d", rr.Code)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("Expected the primary response without waiting for the shadow, took %v", elapsed)
+		}
+	}
+	if stats := mirror.stats.snapshot(); stats.Dropped != 2 {
+		t.Errorf("Expected mirrors over maxInFlight to be dropped, got %+v", stats)
+	}
+}
+
+func TestRouteHandler_MirrorFlushesStreams(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+	}))
+	defer primary.Close()
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer shadow.Close()
+
+	g, _ := createMirrorGateway(t, primary.URL, shadow.URL, &MirrorConfig{Percentage: 100})
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("GET", "/service1/events", nil))
+	if !rr.Flushed || rr.Body.String() != "data: 1\n\n" {
+		t.Errorf("Expected the event stream to be flushed through the mirror, got %q (flushed %v)", rr.Body.String(), rr.Flushed)
+	}
+}
+
+func TestRouteHandler_MirrorTimeout(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer primary.Close()
+	unblock := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer shadow.Close()
+	defer close(unblock)
+
+	g, mirror := createMirrorGateway(t, primary.URL, shadow.URL, &MirrorConfig{Percentage: 100, Timeout: 50 * time.Millisecond})
+	g.routeHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/service1", nil))
+
+	if stats := waitForMirrored(t, mirror, 1); stats.Failed != 1 {
+		t.Errorf("Expected the slow mirror to fail, got %+v", stats)
+	}
+}
+
+func TestRouteHandler_MirrorSkipsLargeBodies(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer primary.Close()
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no mirrored request")
+	}))
+	defer shadow.Close()
+
+	g, mirror := createMirrorGateway(t, primary.URL, shadow.URL, &MirrorConfig{Percentage: 100, MaxBodySize: 4})
+	// One body with a known length, one streamed without
+	g.routeHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/service1", strings.NewReader("too large")))
+	req := httptest.NewRequest("POST", "/service1", io.MultiReader(strings.NewReader("too large")))
+	req.ContentLength = -1
+	g.routeHandler(httptest.NewRecorder(), req)
+
+	if stats := mirror.stats.snapshot(); stats.Skipped != 2 || stats.Mirrored != 0 {
+		t.Errorf("Expected both requests to be skipped, got %+v", stats)
+	}
+}
+
+func TestMirror_Sample(t *testing.T) {
+	logger, _ := zap.NewProductionConfig().Build()
+	mirror, _ := NewMirror(&MirrorConfig{Service: "shadow", Percentage: 25}, logger)
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if mirror.Sample() {
+			sampled++
+		}
+	}
+	if sampled < 2000 || sampled > 3000 {
+		t.Errorf("Expected about 25%% of requests to be sampled, got %d of 10000", sampled)
+	}
+
+	for _, percentage := range []float64{0, -1, 101} {
+		if _, err := NewMirror(&MirrorConfig{Service: "shadow", Percentage: percentage}, logger); err == nil {
+			t.Errorf("Expected an error for percentage %v", percentage)
+		}
+	}
+	if _, err := NewMirror(&MirrorConfig{Percentage: 10}, logger); err == nil {
+		t.Errorf("Expected an error without a shadow service")
+	}
+}
+
+func TestLoadConfig_Mirror(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(shadow string) {
+		content := `
+services:
+  serviceA:
+    endpoints:
+      - http://localhost:8081
+    loadBalancer: round-robin
+    mirror:
+      service: ` + shadow + `
+      percentage: 10
+  serviceA-canary:
+    endpoints:
+      - http://localhost:8082
+    loadBalancer: round-robin
+`
+		if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+
+	g := createTestGateway(configPath)
+	writeConfig("serviceA-canary")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	stats := g.serviceRegistry["serviceA"].mirror.stats
+	stats.record(func(s *MirrorStats) { s.Mirrored++ })
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if g.serviceRegistry["serviceA"].mirror.stats != stats {
+		t.Errorf("Expected the mirror statistics to survive a reload")
+	}
+
+	for _, shadow := range []string{"missing", "serviceA"} {
+		writeConfig(shadow)
+		if err := g.loadConfig(); err == nil {
+			t.Errorf("Expected an error for shadow service %q", shadow)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package gateway
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	defaultOIDCCookieName = "gateway_oidc"
+	defaultOIDCSessionTTL = 24 * time.Hour
+	// oidcStateTTL bounds how long a user may take to log in at the issuer.
+	oidcStateTTL = 10 * time.Minute
+	// oidcRequestTimeout bounds calls to the issuer's discovery and token endpoints.
+	oidcRequestTimeout = 10 * time.Second
+	// maxOIDCCookieSize is the largest cookie name and value browsers are relied on to keep.
+	maxOIDCCookieSize = 4096
+	// oidcDiscoveryBackoff is how long a failed discovery is reported before it is retried.
+	oidcDiscoveryBackoff = 10 * time.Second
+)
+
+// errOIDCSessionTooLarge reports a session that does not fit in a cookie.
+var errOIDCSessionTooLarge = errors.New("oidc: session too large for a cookie")
+
+// oidcSession is the state kept in the encrypted session cookie.
+type oidcSession struct {
+	Claims       map[string]interface{} `json:"claims"`
+	AccessToken  string                 `json:"at,omitempty"`
+	RefreshToken string                 `json:"rt,omitempty"`
+	TokenExpiry  time.Time              `json:"tokenExpiry"`
+	Expiry       time.Time              `json:"expiry"`
+}
+
+// oidcLoginState is kept in the encrypted state cookie while the user logs in at the issuer.
+type oidcLoginState struct {
+	State    string    `json:"state"`
+	Verifier string    `json:"verifier"`
+	Nonce    string    `json:"nonce"`
+	ReturnTo string    `json:"returnTo"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type OIDCHandler struct {
+	config       *OIDCConfig
+	callbackPath string
+	secure       bool
+	aead         cipher.AEAD
+	client       *http.Client
+	metadata     *oidcProviderMetadata
+	verifier     *JWTAuthenticator
+	// discoveredAt and discoverErr record the last discovery so a failing issuer is not
+	// retried on every request; discovering is closed when the discovery in progress ends
+	discoveredAt time.Time
+	discoverErr  error
+	discovering  chan struct{}
+	mux          sync.Mutex
+	log          *log.Logger
+}
+
+// NewOIDCHandler initializes an OIDCHandler. The redirect URL's path must route to the same
+// service so the callback reaches the handler.
+func NewOIDCHandler(config *OIDCConfig, log *log.Logger) (*OIDCHandler, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, clientID and redirectURL are required")
+	}
+	if config.CookieSecret == "" {
+		return nil, errors.New("oidc: cookieSecret is required")
+	}
+	redirect, err := url.Parse(config.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid redirectURL: %w", err)
+	}
+
+	// Derive a fixed-size AES-256 key from the configured secret
+	key := sha256.Sum256([]byte(config.CookieSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCHandler{
+		config:       config,
+		callbackPath: redirect.Path,
+		secure:       redirect.Scheme == "https",
+		aead:         aead,
+		client:       &http.Client{Timeout: oidcRequestTimeout},
+		log:          log,
+	}, nil
+}
+
+// Handle runs the login flow for a request reaching the service under pathPrefix, which
+// scopes the cookies. It returns the request carrying the user's identity, or nil if it has
+// already written a response: a redirect to the issuer, the callback's redirect back to the
+// original page, or an error.
+func (oh *OIDCHandler) Handle(w http.ResponseWriter, r *http.Request, pathPrefix string) *http.Request {
+	cookiePath := pathPrefix
+	if cookiePath == "" {
+		// Routes matching on host or headers alone reach the service under any path
+		cookiePath = "/"
+	}
+	if r.URL.Path == oh.callbackPath {
+		oh.handleCallback(w, r, cookiePath)
+		return nil
+	}
+
+	session, err := oh.readSession(r)
+	if err == nil && time.Now().After(session.TokenExpiry) {
+		err = oh.refresh(w, session, cookiePath)
+	}
+	if errors.Is(err, errOIDCSessionTooLarge) {
+		// A new login would produce the same session, so fail rather than loop through the issuer
+		oh.log.Sugar().Errorf("OIDCHandler: Failed to store session: %v", err)
+		http.Error(w, "Session too large", http.StatusInternalServerError)
+		return nil
+	}
+	if err != nil {
+		oh.log.Sugar().Debugf("OIDCHandler: No valid session: %v", err)
+		oh.startLogin(w, r, cookiePath)
+		return nil
+	}
+
+	return r.WithContext(withIdentity(r.Context(), oh.identity(session)))
+}
+
+// startLogin redirects browsers to the issuer with a fresh state, nonce and PKCE verifier.
+func (oh *OIDCHandler) startLogin(w http.ResponseWriter, r *http.Request, cookiePath string) {
+	// Only top-level navigations can follow the login redirect
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	metadata, err := oh.discover()
+	if err != nil {
+		oh.log.Sugar().Errorf("OIDCHandler: Discovery failed: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	returnTo := r.URL.RequestURI()
+	if strings.HasPrefix(returnTo, "//") {
+		// Never redirect to another host after login
+		returnTo = "/"
+	}
+	login := oidcLoginState{
+		State:    randomToken(),
+		Verifier: randomToken(),
+		Nonce:    randomToken(),
+		ReturnTo: returnTo,
+		Expiry:   time.Now().Add(oidcStateTTL),
+	}
+	if err := oh.setCookie(w, oh.stateCookieName(), cookiePath, login, oidcStateTTL); err != nil {
+		oh.log.Sugar().Errorf("OIDCHandler: Failed to store login state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oh.config.ClientID},
+		"redirect_uri":          {oh.config.RedirectURL},
+		"scope":                 {strings.Join(oh.scopes(), " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, metadata.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// handleCallback exchanges the authorization code for tokens and starts the session.
+func (oh *OIDCHandler) handleCallback(w http.ResponseWriter, r *http.Request, cookiePath string) {
+	var login oidcLoginState
+	if err := oh.readCookie(r, oh.stateCookieName(), &login); err != nil || time.Now().After(login.Expiry) {
+		http.Error(w, "Login session expired", http.StatusBadRequest)
+		return
+	}
+	oh.clearCookie(w, oh.stateCookieName(), cookiePath)
+
+	query := r.URL.Query()
+	if query.Get("state") != login.State {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		oh.log.Sugar().Infof("OIDCHandler: Issuer returned error %s: %s", errCode, query.Get("error_description"))
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := oh.exchange(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"redirect_uri":  {oh.config.RedirectURL},
+		"code_verifier": {login.Verifier},
+	})
+	if err != nil {
+		oh.log.Sugar().Infof("OIDCHandler: Code exchange failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := oh.verifyIDToken(tokens.IDToken)
+	if err != nil {
+		oh.log.Sugar().Infof("OIDCHandler: Invalid ID token: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.Nonce {
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	session := &oidcSession{Claims: oh.keptClaims(claims), Expiry: time.Now().Add(oh.sessionTTL())}
+	oh.applyTokens(session, tokens)
+	if err := oh.setCookie(w, oh.cookieName(), cookiePath, session, oh.sessionTTL()); err != nil {
+		oh.log.Sugar().Errorf("OIDCHandler: Failed to store session: %v", err)
+		message := "Internal server error"
+		if errors.Is(err, errOIDCSessionTooLarge) {
+			message = "Session too large"
+		}
+		http.Error(w, message, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, login.ReturnTo, http.StatusFound)
+}
+
+// refresh renews the session's tokens with its refresh token and reissues the cookie.
+func (oh *OIDCHandler) refresh(w http.ResponseWriter, session *oidcSession, cookiePath string) error {
+	if session.RefreshToken == "" {
+		return errors.New("session expired")
+	}
+
+	tokens, err := oh.exchange(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {session.RefreshToken},
+	})
+	if err != nil {
+		return fmt.Errorf("refreshing tokens: %w", err)
+	}
+	if tokens.IDToken != "" {
+		claims, err := oh.verifyIDToken(tokens.IDToken)
+		if err != nil {
+			return err
+		}
+		session.Claims = oh.keptClaims(claims)
+	}
+
+	oh.applyTokens(session, tokens)
+	return oh.setCookie(w, oh.cookieName(), cookiePath, session, time.Until(session.Expiry))
+}
+
+// exchange calls the token endpoint with the client's credentials.
+func (oh *OIDCHandler) exchange(form url.Values) (*oidcTokenResponse, error) {
+	metadata, err := oh.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form.Set("client_id", oh.config.ClientID)
+	if oh.config.ClientSecret != "" {
+		form.Set("client_secret", oh.config.ClientSecret)
+	}
+	resp, err := oh.client.PostForm(metadata.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, body)
+	}
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+func (oh *OIDCHandler) applyTokens(session *oidcSession, tokens *oidcTokenResponse) {
+	// The access token is only kept to be forwarded, keeping the cookie small otherwise
+	if oh.config.ForwardAccessToken {
+		session.AccessToken = tokens.AccessToken
+	}
+	if tokens.RefreshToken != "" {
+		session.RefreshToken = tokens.RefreshToken
+	}
+	expiresIn := time.Duration(tokens.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = time.Hour
+	}
+	session.TokenExpiry = time.Now().Add(expiresIn)
+	if session.TokenExpiry.After(session.Expiry) {
+		session.TokenExpiry = session.Expiry
+	}
+}
+
+// discover returns the issuer's OpenID provider metadata, fetching it on first use. The
+// fetch runs outside the lock, and a failed one is not retried for oidcDiscoveryBackoff
+// so an unreachable issuer does not hold up every login.
+func (oh *OIDCHandler) discover() (*oidcProviderMetadata, error) {
+	oh.mux.Lock()
+	defer oh.mux.Unlock()
+
+	for oh.metadata == nil {
+		if oh.discovering != nil {
+			wait := oh.discovering
+			oh.mux.Unlock()
+			<-wait
+			oh.mux.Lock()
+			continue
+		}
+		if oh.discoverErr != nil && time.Since(oh.discoveredAt) < oidcDiscoveryBackoff {
+			return nil, oh.discoverErr
+		}
+
+		done := make(chan struct{})
+		oh.discovering = done
+		oh.mux.Unlock()
+		metadata, verifier, err := oh.fetchMetadata()
+		oh.mux.Lock()
+		oh.discovering = nil
+		close(done)
+
+		oh.discoveredAt = time.Now()
+		if err != nil {
+			oh.discoverErr = err
+			return nil, err
+		}
+		oh.metadata, oh.verifier, oh.discoverErr = metadata, verifier, nil
+	}
+	return oh.metadata, nil
+}
+
+// fetchMetadata fetches the issuer's provider metadata and builds the ID token verifier.
+func (oh *OIDCHandler) fetchMetadata() (*oidcProviderMetadata, *JWTAuthenticator, error) {
+	resp, err := oh.client.Get(strings.TrimSuffix(oh.config.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("discovery returned status %d", resp.StatusCode)
+	}
+	var metadata oidcProviderMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, nil, err
+	}
+	if metadata.Issuer != oh.config.Issuer {
+		return nil, nil, fmt.Errorf("discovery returned issuer %q, expected %q", metadata.Issuer, oh.config.Issuer)
+	}
+
+	verifier, err := NewJWTAuthenticator(&JWTConfig{
+		Issuer:     oh.config.Issuer,
+		Audiences:  []string{oh.config.ClientID},
+		Algorithms: []string{"RS256", "ES256"},
+		ClockSkew:  time.Minute,
+		JWKSURL:    metadata.JWKSURI,
+	}, oh.log)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &metadata, verifier, nil
+}
+
+func (oh *OIDCHandler) verifyIDToken(idToken string) (map[string]interface{}, error) {
+	if idToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	if _, err := oh.discover(); err != nil {
+		return nil, err
+	}
+	return oh.verifier.Verify(idToken)
+}
+
+// keptClaims selects the claims stored in the session: the subject and the mapped claims.
+func (oh *OIDCHandler) keptClaims(claims map[string]interface{}) map[string]interface{} {
+	kept := map[string]interface{}{"sub": claims["sub"]}
+	for claim := range oh.config.ClaimHeaders {
+		if value, ok := claims[claim]; ok {
+			kept[claim] = value
+		}
+	}
+	return kept
+}
+
+// identity builds the caller's identity and upstream headers from the session.
+func (oh *OIDCHandler) identity(session *oidcSession) *Identity {
+	identity := &Identity{Claims: session.Claims, Headers: make(map[string]string)}
+	identity.Subject, _ = session.Claims["sub"].(string)
+	for claim, header := range oh.config.ClaimHeaders {
+		identity.Headers[header], _ = claimString(session.Claims[claim])
+	}
+	if oh.config.ForwardAccessToken && session.AccessToken != "" {
+		identity.Headers["Authorization"] = "Bearer " + session.AccessToken
+	}
+	return identity
+}
+
+func (oh *OIDCHandler) readSession(r *http.Request) (*oidcSession, error) {
+	var session oidcSession
+	if err := oh.readCookie(r, oh.cookieName(), &session); err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.Expiry) {
+		return nil, errors.New("session expired")
+	}
+	return &session, nil
+}
+
+// setCookie encrypts value with AES-GCM and stores it in the named cookie under path.
+// Values too large for browsers to keep are refused rather than silently dropped.
+func (oh *OIDCHandler) setCookie(w http.ResponseWriter, name, path string, value interface{}, maxAge time.Duration) error {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, oh.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// Bind the ciphertext to the cookie name so a state cookie cannot be replayed as a session
+	sealed := oh.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	encoded := base64.RawURLEncoding.EncodeToString(sealed)
+	if size := len(name) + len(encoded); size > maxOIDCCookieSize {
+		return fmt.Errorf("%w: %d bytes exceeds %d; map fewer claims or disable forwardAccessToken", errOIDCSessionTooLarge, size, maxOIDCCookieSize)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded,
+		Path:     path,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   oh.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// readCookie decrypts the named cookie into value.
+func (oh *OIDCHandler) readCookie(r *http.Request, name string, value interface{}) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < oh.aead.NonceSize() {
+		return errors.New("cookie too short")
+	}
+	nonce, ciphertext := sealed[:oh.aead.NonceSize()], sealed[oh.aead.NonceSize():]
+	plaintext, err := oh.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, value)
+}
+
+func (oh *OIDCHandler) clearCookie(w http.ResponseWriter, name, path string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Path: path, MaxAge: -1, Secure: oh.secure, HttpOnly: true})
+}
+
+func (oh *OIDCHandler) cookieName() string {
+	if oh.config.CookieName != "" {
+		return oh.config.CookieName
+	}
+	return defaultOIDCCookieName
+}
+
+func (oh *OIDCHandler) stateCookieName() string {
+	return oh.cookieName() + "_state"
+}
+
+func (oh *OIDCHandler) scopes() []string {
+	if len(oh.config.Scopes) > 0 {
+		return oh.config.Scopes
+	}
+	return []string{"openid", "profile", "email"}
+}
+
+func (oh *OIDCHandler) sessionTTL() time.Duration {
+	if oh.config.SessionTTL > 0 {
+		return oh.config.SessionTTL
+	}
+	return defaultOIDCSessionTTL
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,336 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIssuer is a minimal in-process OpenID provider supporting the
+// authorization code flow with PKCE and refresh tokens.
+type fakeIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	expiresIn int64
+	mux       sync.Mutex
+	codes     map[string]url.Values
+	refreshes int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	issuer := &fakeIssuer{key: key, expiresIn: 3600, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwksJSON(t, map[string]interface{}{"key": key}))
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		// The user logs in immediately and is sent back with a code
+		query := r.URL.Query()
+		code := randomToken()
+		issuer.mux.Lock()
+		issuer.codes[code] = query
+		issuer.mux.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+query.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		issuer.mux.Lock()
+		defer issuer.mux.Unlock()
+
+		nonce := ""
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			authorize, ok := issuer.codes[r.Form.Get("code")]
+			delete(issuer.codes, r.Form.Get("code"))
+			challenge := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if !ok || authorize.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			nonce = authorize.Get("nonce")
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh-token" {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			issuer.refreshes++
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   issuer.server.URL,
+			"aud":   "gateway",
+			"sub":   "user-1",
+			"email": "user@example.com",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-token",
+			"refresh_token": "refresh-token",
+			"id_token":      signToken(t, jwt.SigningMethodRS256, key, "key", claims),
+			"expires_in":    issuer.expiresIn,
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// browser follows redirects by hand so cookies set by the gateway can be inspected.
+type browser struct {
+	t       *testing.T
+	gateway *Gateway
+	cookies map[string]*http.Cookie
+}
+
+func (b *browser) get(target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	for _, cookie := range b.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	b.gateway.routeHandler(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+func newOIDCGateway(t *testing.T, issuer *fakeIssuer, upstream http.HandlerFunc) *Gateway {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	g := createTestGateway("")
+	oidc, err := NewOIDCHandler(&OIDCConfig{
+		Issuer:             issuer.server.URL,
+		ClientID:           "gateway",
+		RedirectURL:        "http://gateway.example.com/service1/oauth2/callback",
+		CookieSecret:       "cookie-secret",
+		ClaimHeaders:       map[string]string{"sub": "X-User-ID", "email": "X-User-Email"},
+		ForwardAccessToken: true,
+	}, g.log)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+		endpoints:        []string{server.URL},
+		oidc:             oidc,
+	}
+	return g
+}
+
+// login runs the full authorization code flow starting at target.
+func (b *browser) login(target string) *httptest.ResponseRecorder {
+	w := b.get(target)
+	if w.Code != http.StatusFound {
+		b.t.Fatalf("Expected redirect to the issuer, got %d", w.Code)
+	}
+
+	// The issuer immediately redirects back to the callback
+	resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}).Get(w.Header().Get("Location"))
+	if err != nil {
+		b.t.Fatalf("Failed to call the issuer: %v", err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	w = b.get(callback.RequestURI())
+	if w.Code != http.StatusFound || w.Header().Get("Location") != target {
+		b.t.Fatalf("Expected callback to redirect back to %s, got %d %s", target, w.Code, w.Header().Get("Location"))
+	}
+	return b.get(target)
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	var upstreamHeaders http.Header
+	g := newOIDCGateway(t, issuer, func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	})
+	b := &browser{t: t, gateway: g, cookies: make(map[string]*http.Cookie)}
+
+	w := b.get("/service1/page?x=1")
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if location.Query().Get("code_challenge_method") != "S256" || location.Query().Get("code_challenge") == "" {
+		t.Errorf("Expected a PKCE challenge in the authorization request, got %s", location)
+	}
+
+	w = b.login("/service1/page?x=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK after login, got %d", w.Code)
+	}
+	if upstreamHeaders.Get("X-User-ID") != "user-1" || upstreamHeaders.Get("X-User-Email") != "user@example.com" {
+		t.Errorf("Expected identity headers upstream, got %v", upstreamHeaders)
+	}
+	if upstreamHeaders.Get("Authorization") != "Bearer access-token" {
+		t.Errorf("Expected the access token to be forwarded, got %q", upstreamHeaders.Get("Authorization"))
+	}
+
+	cookie := b.cookies[defaultOIDCCookieName]
+	if cookie == nil || !cookie.HttpOnly || cookie.Path != "/service1" {
+		t.Errorf("Expected an HttpOnly session cookie scoped to the service, got %+v", cookie)
+	}
+}
+
+func TestOIDCCookiePathFollowsRoute(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	g := newOIDCGateway(t, issuer, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	g.routes = []*Route{
+		newTestRoute(t, &RouteConfig{Service: "service1", Match: MatchConfig{PathPrefix: "/app"}}),
+		newTestRoute(t, &RouteConfig{Service: "service1", Match: MatchConfig{Host: "login.example.com"}}),
+	}
+	b := &browser{t: t, gateway: g, cookies: make(map[string]*http.Cookie)}
+
+	b.get("/app/page")
+	if cookie := b.cookies[defaultOIDCCookieName+"_state"]; cookie == nil || cookie.Path != "/app" {
+		t.Errorf("Expected the state cookie scoped to the route prefix, got %+v", cookie)
+	}
+
+	req := httptest.NewRequest("GET", "/page", nil)
+	req.Host = "login.example.com"
+	w := httptest.NewRecorder()
+	g.routeHandler(w, req)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != "/" {
+		t.Errorf("Expected the state cookie of a host route scoped to /, got %+v", cookies)
+	}
+}
+
+func TestOIDCRefresh(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	// Tokens expire immediately, so the next request must refresh them
+	issuer.expiresIn = -1
+	g := newOIDCGateway(t, issuer, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	service := g.serviceRegistry["service1"]
+	b := &browser{t: t, gateway: g, cookies: make(map[string]*http.Cookie)}
+
+	b.login("/service1")
+	session, err := service.oidc.readSession(&http.Request{Header: http.Header{"Cookie": {b.cookies[defaultOIDCCookieName].String()}}})
+	if err != nil {
+		t.Fatalf("Unexpected error reading the session: %v", err)
+	}
+	session.TokenExpiry = time.Now().Add(-time.Second)
+	w := httptest.NewRecorder()
+	service.oidc.setCookie(w, defaultOIDCCookieName, "/service1", session, time.Hour)
+	b.cookies[defaultOIDCCookieName] = w.Result().Cookies()[0]
+
+	issuer.expiresIn = 3600
+	refreshesBefore := issuer.refreshes
+	if w := b.get("/service1"); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK after refresh, got %d", w.Code)
+	}
+	if issuer.refreshes != refreshesBefore+1 {
+		t.Errorf("Expected the tokens to be refreshed once, got %d refreshes", issuer.refreshes-refreshesBefore)
+	}
+}
+
+func TestOIDCRejectsTamperedCookies(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	g := newOIDCGateway(t, issuer, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	b := &browser{t: t, gateway: g, cookies: make(map[string]*http.Cookie)}
+
+	b.cookies[defaultOIDCCookieName] = &http.Cookie{Name: defaultOIDCCookieName, Value: "tampered"}
+	if w := b.get("/service1"); w.Code != http.StatusFound {
+		t.Errorf("Expected a tampered session to restart the login, got %d", w.Code)
+	}
+
+	// A callback without the matching state cookie is refused
+	delete(b.cookies, defaultOIDCCookieName+"_state")
+	if w := b.get("/service1/oauth2/callback?code=x&state=y"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/service1", nil)
+	w := httptest.NewRecorder()
+	g.routeHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected non-navigation requests without a session to get Unauthorized, got %d", w.Code)
+	}
+}
+
+func TestOIDCDiscoveryBackoff(t *testing.T) {
+	var discoveries int32
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&discoveries, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer issuer.Close()
+	g := createTestGateway("")
+	oidc, err := NewOIDCHandler(&OIDCConfig{
+		Issuer:       issuer.URL,
+		ClientID:     "gateway",
+		RedirectURL:  "http://gateway.example.com/service1/oauth2/callback",
+		CookieSecret: "cookie-secret",
+	}, g.log)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		oidc.Handle(w, httptest.NewRequest("GET", "/service1", nil), "/service1")
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("Expected status %d while the issuer is down, got %d", http.StatusBadGateway, w.Code)
+		}
+	}
+	if n := atomic.LoadInt32(&discoveries); n != 1 {
+		t.Errorf("Expected a failed discovery not to be retried within the backoff, got %d attempts", n)
+	}
+}
+
+func TestOIDCSessionTooLarge(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	g := newOIDCGateway(t, issuer, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	oidc := g.serviceRegistry["service1"].oidc
+
+	session := &oidcSession{Claims: map[string]interface{}{"sub": "user-1", "groups": strings.Repeat("g", 5000)}, Expiry: time.Now().Add(time.Hour)}
+	w := httptest.NewRecorder()
+	if err := oidc.setCookie(w, defaultOIDCCookieName, "/service1", session, time.Hour); !errors.Is(err, errOIDCSessionTooLarge) {
+		t.Errorf("Expected an oversized session to be refused, got %v", err)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no cookie for an oversized session, got %d", len(cookies))
+	}
+
+	// Sessions only keep the access token when it is forwarded
+	oidc.config.ForwardAccessToken = false
+	session = &oidcSession{Expiry: time.Now().Add(time.Hour)}
+	oidc.applyTokens(session, &oidcTokenResponse{AccessToken: "access-token", RefreshToken: "refresh-token"})
+	if session.AccessToken != "" || session.RefreshToken != "refresh-token" {
+		t.Errorf("Expected only the refresh token to be kept, got %+v", session)
+	}
+}
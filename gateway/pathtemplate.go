@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// pathTemplate is a google.api.http path template such as
+// /v1/{name=shelves/*/books/*}:publish.
+type pathTemplate struct {
+	segments []templateSegment
+	// variables are the field paths captured by the template, in order
+	variables []string
+	verb      string
+}
+
+// templateSegment matches one path segment: a literal, "*" for any segment or "**" for the
+// rest of the path. Segments inside a variable record the field path they capture.
+type templateSegment struct {
+	literal  string
+	variable string
+}
+
+func parsePathTemplate(template string) (*pathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("path template %q must start with /", template)
+	}
+	rest := template[1:]
+	t := &pathTemplate{}
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "}") && i > strings.LastIndex(rest, "/") {
+		rest, t.verb = rest[:i], rest[i+1:]
+	}
+
+	// Segments are separated by slashes outside of variables
+	var raw []string
+	depth, start := 0, 0
+	for i, c := range rest {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				raw = append(raw, rest[start:i])
+				start = i + 1
+			}
+		}
+		if depth < 0 || depth > 1 {
+			return nil, fmt.Errorf("path template %q has unbalanced braces", template)
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("path template %q has unbalanced braces", template)
+	}
+	raw = append(raw, rest[start:])
+
+	for _, segment := range raw {
+		if !strings.HasPrefix(segment, "{") {
+			t.segments = append(t.segments, templateSegment{literal: segment})
+			continue
+		}
+		if !strings.HasSuffix(segment, "}") {
+			return nil, fmt.Errorf("path template %q: invalid segment %q", template, segment)
+		}
+		variable, pattern, found := strings.Cut(segment[1:len(segment)-1], "=")
+		if !found {
+			pattern = "*"
+		}
+		if variable == "" || containsString(t.variables, variable) {
+			return nil, fmt.Errorf("path template %q: invalid variable %q", template, segment)
+		}
+		t.variables = append(t.variables, variable)
+		for _, literal := range strings.Split(pattern, "/") {
+			t.segments = append(t.segments, templateSegment{literal: literal, variable: variable})
+		}
+	}
+
+	for i, segment := range t.segments {
+		if segment.literal == "" || strings.ContainsAny(segment.literal, "{}") {
+			return nil, fmt.Errorf("path template %q has an empty or invalid segment", template)
+		}
+		if segment.literal == "**" && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("path template %q: ** must be the last segment", template)
+		}
+	}
+	return t, nil
+}
+
+// match matches an escaped request path against the template, returning the unescaped values
+// of its variables.
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	path = strings.TrimPrefix(path, "/")
+	if t.verb != "" {
+		i := strings.LastIndex(path, ":")
+		if i < 0 || i < strings.LastIndex(path, "/") || path[i+1:] != t.verb {
+			return nil, false
+		}
+		path = path[:i]
+	}
+	parts := strings.Split(path, "/")
+
+	captured := make(map[string][]string)
+	for i, segment := range t.segments {
+		if segment.literal == "**" {
+			if segment.variable != "" {
+				captured[segment.variable] = append(captured[segment.variable], parts[min(i, len(parts)):]...)
+			}
+			parts = parts[:min(i, len(parts))]
+			break
+		}
+		if i >= len(parts) || parts[i] == "" || (segment.literal != "*" && parts[i] != segment.literal) {
+			return nil, false
+		}
+		if segment.variable != "" {
+			captured[segment.variable] = append(captured[segment.variable], parts[i])
+		}
+	}
+	if n := len(t.segments); len(parts) != n && !(n > 0 && t.segments[n-1].literal == "**") {
+		return nil, false
+	}
+
+	variables := make(map[string]string, len(captured))
+	for variable, values := range captured {
+		value, err := url.PathUnescape(strings.Join(values, "/"))
+		if err != nil {
+			return nil, false
+		}
+		variables[variable] = value
+	}
+	return variables, true
+}
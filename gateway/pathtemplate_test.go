@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"testing"
+)
+
+func TestPathTemplate_Match(t *testing.T) {
+	cases := []struct {
+		template  string
+		path      string
+		variables map[string]string
+	}{
+		{"/v1/hello/{name}", "/v1/hello/world", map[string]string{"name": "world"}},
+		{"/v1/hello/{name}", "/v1/hello/world%20wide", map[string]string{"name": "world wide"}},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/{book.name=shelves/*}/books", "/v1/shelves/s1/books", map[string]string{"book.name": "shelves/s1"}},
+		{"/v1/files/{path=**}", "/v1/files/a/b/c.txt", map[string]string{"path": "a/b/c.txt"}},
+		{"/v1/files/**", "/v1/files", map[string]string{}},
+		{"/v1/{name=messages/*}:publish", "/v1/messages/m1:publish", map[string]string{"name": "messages/m1"}},
+		{"/v1/*/status", "/v1/anything/status", map[string]string{}},
+	}
+	for _, c := range cases {
+		template, err := parsePathTemplate(c.template)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", c.template, err)
+		}
+		variables, ok := template.match(c.path)
+		if !ok {
+			t.Errorf("Expected %s to match %s", c.path, c.template)
+			continue
+		}
+		if len(variables) != len(c.variables) {
+			t.Errorf("Expected variables %v for %s, got %v", c.variables, c.path, variables)
+		}
+		for name, value := range c.variables {
+			if variables[name] != value {
+				t.Errorf("Expected %s=%q for %s, got %q", name, value, c.path, variables[name])
+			}
+		}
+	}
+}
+
+func TestPathTemplate_NoMatch(t *testing.T) {
+	cases := []struct{ template, path string }{
+		{"/v1/hello/{name}", "/v1/hello/a/b"},
+		{"/v1/hello/{name}", "/v1/hello"},
+		{"/v1/{name=messages/*}:publish", "/v1/messages/m1"},
+		{"/v1/{name=messages/*}:publish", "/v1/messages/m1:cancel"},
+		{"/v1/*/status", "/v1//status"},
+	}
+	for _, c := range cases {
+		template, err := parsePathTemplate(c.template)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", c.template, err)
+		}
+		if _, ok := template.match(c.path); ok {
+			t.Errorf("Expected %s not to match %s", c.path, c.template)
+		}
+	}
+}
+
+func TestParsePathTemplate_Invalid(t *testing.T) {
+	for _, template := range []string{"v1/hello", "/v1/{name", "/v1/{name}}", "/v1/**/tail", "/v1//x", "/v1/{}", "/v1/{a}/{a}", "/v1/{a={b}}"} {
+		if _, err := parsePathTemplate(template); err == nil {
+			t.Errorf("Expected an error for %q", template)
+		}
+	}
+}
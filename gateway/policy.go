@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	log "go.uber.org/zap"
+)
+
+type policyRule struct {
+	name      string
+	allow     bool
+	methods   []string
+	consumers []string
+	groups    []string
+	claims    map[string][]string
+	headers   map[string][]string
+	networks  []*net.IPNet
+}
+
+type AuthorizationPolicy struct {
+	rules        []policyRule
+	defaultAllow bool
+	dryRun       bool
+	log          *log.Logger
+}
+
+// NewAuthorizationPolicy compiles the rules in config.
+// Requests matching no rule are denied unless the default action is "allow".
+func NewAuthorizationPolicy(config *AuthorizationConfig, log *log.Logger) (*AuthorizationPolicy, error) {
+	policy := &AuthorizationPolicy{dryRun: config.DryRun, log: log}
+	switch config.DefaultAction {
+	case "", "deny":
+	case "allow":
+		policy.defaultAllow = true
+	default:
+		return nil, fmt.Errorf("authorization: unknown default action %q", config.DefaultAction)
+	}
+
+	for i, ruleConfig := range config.Rules {
+		rule := policyRule{
+			name:      ruleConfig.Name,
+			consumers: ruleConfig.Consumers,
+			groups:    ruleConfig.Groups,
+			claims:    ruleConfig.Claims,
+			headers:   ruleConfig.Headers,
+		}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		switch ruleConfig.Action {
+		case "allow":
+			rule.allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("authorization: %s: unknown action %q", rule.name, ruleConfig.Action)
+		}
+
+		for _, method := range ruleConfig.Methods {
+			rule.methods = append(rule.methods, strings.ToUpper(method))
+		}
+		networks, err := parseCIDRs(ruleConfig.CIDRs)
+		if err != nil {
+			return nil, fmt.Errorf("authorization: %s: %w", rule.name, err)
+		}
+		rule.networks = networks
+		policy.rules = append(policy.rules, rule)
+	}
+	return policy, nil
+}
+
+// Evaluate returns whether the first rule matching r allows it, and the name of that rule.
+func (ap *AuthorizationPolicy) Evaluate(r *http.Request) (bool, string) {
+	identity := identityFromContext(r.Context())
+	ip := net.ParseIP(clientIP(r))
+	for _, rule := range ap.rules {
+		if rule.matches(r, identity, ip) {
+			return rule.allow, rule.name
+		}
+	}
+	return ap.defaultAllow, "default"
+}
+
+// matches reports whether every condition of the rule holds for the request.
+// Within a condition, any of the listed values is enough.
+func (rule *policyRule) matches(r *http.Request, identity *Identity, ip net.IP) bool {
+	if len(rule.methods) > 0 && !containsString(rule.methods, r.Method) {
+		return false
+	}
+	if len(rule.consumers) > 0 && (identity == nil || !containsString(rule.consumers, identity.Consumer)) {
+		return false
+	}
+	if len(rule.groups) > 0 && (identity == nil || !containsAny(rule.groups, identity.Groups)) {
+		return false
+	}
+	for claim, allowed := range rule.claims {
+		if identity == nil || !claimMatches(identity.Claims[claim], allowed) {
+			return false
+		}
+	}
+	for header, allowed := range rule.headers {
+		value := r.Header.Get(header)
+		if value == "" || (len(allowed) > 0 && !containsString(allowed, value)) {
+			return false
+		}
+	}
+	if len(rule.networks) > 0 && !inNetworks(ip, rule.networks) {
+		return false
+	}
+	return true
+}
+
+// claimMatches reports whether a claim equals one of allowed, or for list claims contains one.
+func claimMatches(claim interface{}, allowed []string) bool {
+	if list, ok := claim.([]interface{}); ok {
+		for _, item := range list {
+			if claimMatches(item, allowed) {
+				return true
+			}
+		}
+		return false
+	}
+	value, ok := claimString(claim)
+	return ok && containsString(allowed, value)
+}
+
+// authorize applies the service's authorization policy. In dry-run mode denials are
+// only logged. It writes a 403 response and returns false when the request is denied.
+func (g *Gateway) authorize(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) bool {
+	allowed, rule := service.policy.Evaluate(r)
+	if allowed {
+		return true
+	}
+
+	caller := clientIP(r)
+	if identity := identityFromContext(r.Context()); identity != nil && identity.Subject != "" {
+		caller = identity.Subject
+	}
+	if service.policy.dryRun {
+		g.log.Sugar().Warnf("Authorization dry run: would deny %s %s for %s on service %s by %s", r.Method, r.URL.Path, caller, service.serviceName, rule)
+		return true
+	}
+
+	g.log.Sugar().Infof("Authorization denied %s %s for %s on service %s by %s", r.Method, r.URL.Path, caller, service.serviceName, rule)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, value := range values {
+		if containsString(list, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func requestWithIdentity(method, remoteAddr string, identity *Identity) *http.Request {
+	req := httptest.NewRequest(method, "/service1", nil)
+	req.RemoteAddr = remoteAddr
+	if identity != nil {
+		req = req.WithContext(withIdentity(req.Context(), identity))
+	}
+	return req
+}
+
+func TestAuthorizationPolicy(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	policy, err := NewAuthorizationPolicy(&AuthorizationConfig{
+		Rules: []PolicyRule{
+			{Name: "block-banned", Action: "deny", CIDRs: []string{"203.0.113.7"}},
+			{Name: "admins-write", Action: "allow", Methods: []string{"post", "delete"}, Claims: map[string][]string{"roles": {"admin"}}},
+			{Name: "internal-read", Action: "allow", Methods: []string{"GET"}, Groups: []string{"internal"}},
+			{Name: "office-read", Action: "allow", Methods: []string{"GET"}, CIDRs: []string{"10.0.0.0/8"}, Headers: map[string][]string{"X-Env": {"prod"}}},
+		},
+	}, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	admin := &Identity{Subject: "alice", Claims: map[string]interface{}{"roles": []interface{}{"dev", "admin"}}}
+	dev := &Identity{Subject: "bob", Claims: map[string]interface{}{"roles": []interface{}{"dev"}}}
+	internal := &Identity{Consumer: "tool", Groups: []string{"internal"}}
+
+	officeRequest := requestWithIdentity("GET", "10.1.2.3:5000", nil)
+	officeRequest.Header.Set("X-Env", "prod")
+
+	cases := []struct {
+		name    string
+		req     *http.Request
+		allowed bool
+		rule    string
+	}{
+		{"admin writes", requestWithIdentity("POST", "192.0.2.1:5000", admin), true, "admins-write"},
+		{"dev writes", requestWithIdentity("POST", "192.0.2.1:5000", dev), false, "default"},
+		{"internal reads", requestWithIdentity("GET", "192.0.2.1:5000", internal), true, "internal-read"},
+		{"internal writes", requestWithIdentity("DELETE", "192.0.2.1:5000", internal), false, "default"},
+		{"office reads", officeRequest, true, "office-read"},
+		{"office reads without header", requestWithIdentity("GET", "10.1.2.3:5000", nil), false, "default"},
+		{"banned admin", requestWithIdentity("POST", "203.0.113.7:5000", admin), false, "block-banned"},
+	}
+	for _, c := range cases {
+		allowed, rule := policy.Evaluate(c.req)
+		if allowed != c.allowed || rule != c.rule {
+			t.Errorf("%s: expected (%v, %s), got (%v, %s)", c.name, c.allowed, c.rule, allowed, rule)
+		}
+	}
+}
+
+func TestNewAuthorizationPolicy_Invalid(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	cases := map[string]*AuthorizationConfig{
+		"unknown action":         {Rules: []PolicyRule{{Action: "maybe"}}},
+		"unknown default action": {DefaultAction: "maybe"},
+		"invalid cidr":           {Rules: []PolicyRule{{Action: "allow", CIDRs: []string{"10.0.0.0/99"}}}},
+	}
+	for name, config := range cases {
+		if _, err := NewAuthorizationPolicy(config, logger); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRouteHandler_AuthorizationDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	for dryRun, expected := range map[bool]int{false: http.StatusForbidden, true: http.StatusOK} {
+		g := createTestGateway("")
+		policy, _ := NewAuthorizationPolicy(&AuthorizationConfig{DryRun: dryRun, Rules: []PolicyRule{{Action: "allow", Methods: []string{"POST"}}}}, g.log)
+		g.serviceRegistry["service1"] = &GatewayServiceConfig{
+			serviceName:      "service1",
+			loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+			endpoints:        []string{server.URL},
+			policy:           policy,
+		}
+
+		w := httptest.NewRecorder()
+		g.routeHandler(w, httptest.NewRequest("GET", "/service1", nil))
+		if w.Code != expected {
+			t.Errorf("Dry run %v: expected status %d, got %d", dryRun, expected, w.Code)
+		}
+	}
+}
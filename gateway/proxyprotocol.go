@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// newProxyProtocolListener wraps ln so that connections from trusted sources may start with a
+// PROXY protocol v1 or v2 header, whose source address then becomes the connection's remote address.
+// Connections from other sources are served as plain HTTP, so a spoofed header is rejected as a bad request.
+func newProxyProtocolListener(ln net.Listener, config *ProxyProtocolConfig) (net.Listener, error) {
+	if len(config.TrustedSources) == 0 {
+		return nil, errors.New("proxyProtocol: trustedSources is required")
+	}
+	trusted, err := parseCIDRs(config.TrustedSources)
+	if err != nil {
+		return nil, fmt.Errorf("proxyProtocol: trustedSources: %w", err)
+	}
+
+	return &proxyproto.Listener{
+		Listener:          ln,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			// Errors from the policy would stop the server, so unknown addresses are simply untrusted
+			if addr, ok := upstream.(*net.TCPAddr); ok && inNetworks(addr.IP, trusted) {
+				return proxyproto.USE, nil
+			}
+			return proxyproto.SKIP, nil
+		},
+	}, nil
+}
+
+// proxyProtocolVersion parses the PROXY protocol version sent to a service's endpoints.
+func proxyProtocolVersion(version string) (byte, error) {
+	switch version {
+	case "v1", "1":
+		return 1, nil
+	case "v2", "2":
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("proxyProtocol: unknown version %q, use v1 or v2", version)
+	}
+}
+
+// newProxyProtocolClient returns a client that starts every upstream connection with a PROXY
+// header carrying the request's client address. Connections are not reused since each one
+// announces a single client.
+func newProxyProtocolClient(version byte) *http.Client {
+	dialer := &net.Dialer{}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		header := proxyproto.HeaderProxyFromAddrs(version, proxySourceAddr(ctx, conn), conn.RemoteAddr())
+		if _, err := header.WriteTo(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("writing PROXY header: %w", err)
+		}
+		return conn, nil
+	}
+	return &http.Client{Transport: transport}
+}
+
+// proxySourceAddr returns the client address announced upstream, falling back to the
+// gateway's own address for requests without a resolved client.
+func proxySourceAddr(ctx context.Context, conn net.Conn) net.Addr {
+	addr, ok := ctx.Value(clientAddressKey{}).(*clientAddress)
+	if !ok {
+		return conn.LocalAddr()
+	}
+	ip := net.ParseIP(addr.ip)
+	if ip == nil {
+		return conn.LocalAddr()
+	}
+	return &net.TCPAddr{IP: ip, Port: addr.port}
+}
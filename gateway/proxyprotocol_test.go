@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// serveRemoteAddr serves the remote address of each request on a PROXY protocol listener.
+func serveRemoteAddr(t *testing.T, config *ProxyProtocolConfig) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener, err := newProxyProtocolListener(ln, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	}))
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// sendWithHeader sends a GET request preceded by header, if any, and returns the response.
+func sendWithHeader(t *testing.T, addr string, header *proxyproto.Header) (int, string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if header != nil {
+		if _, err := header.WriteTo(conn); err != nil {
+			t.Fatalf("Failed to write PROXY header: %v", err)
+		}
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: gateway\r\nConnection: close\r\n\r\n"))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	listener := serveRemoteAddr(t, &ProxyProtocolConfig{TrustedSources: []string{"127.0.0.1"}})
+	source := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5555}
+	destination := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}
+
+	for _, version := range []byte{1, 2} {
+		status, body := sendWithHeader(t, listener.Addr().String(), proxyproto.HeaderProxyFromAddrs(version, source, destination))
+		if status != http.StatusOK || body != "198.51.100.7:5555" {
+			t.Errorf("Expected v%d client address 198.51.100.7:5555, got %d %s", version, status, body)
+		}
+	}
+
+	// The header is optional so that health checks can connect directly
+	if _, body := sendWithHeader(t, listener.Addr().String(), nil); !strings.HasPrefix(body, "127.0.0.1:") {
+		t.Errorf("Expected the peer address without a PROXY header, got %s", body)
+	}
+}
+
+func TestProxyProtocolListener_UntrustedSource(t *testing.T) {
+	listener := serveRemoteAddr(t, &ProxyProtocolConfig{TrustedSources: []string{"10.0.0.0/8"}})
+	source := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5555}
+	destination := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}
+
+	status, body := sendWithHeader(t, listener.Addr().String(), proxyproto.HeaderProxyFromAddrs(1, source, destination))
+	if status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a PROXY header from an untrusted source, got %d %s", status, body)
+	}
+}
+
+func TestNewProxyProtocolListener_Invalid(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	if _, err := newProxyProtocolListener(ln, &ProxyProtocolConfig{}); err == nil {
+		t.Errorf("Expected an error without trusted sources")
+	}
+	if _, err := newProxyProtocolListener(ln, &ProxyProtocolConfig{TrustedSources: []string{"not-an-ip"}}); err == nil {
+		t.Errorf("Expected an error for an invalid trusted source")
+	}
+}
+
+func TestProxyProtocolClient(t *testing.T) {
+	upstream := serveRemoteAddr(t, &ProxyProtocolConfig{TrustedSources: []string{"127.0.0.1"}})
+
+	for _, name := range []string{"v1", "v2"} {
+		version, err := proxyProtocolVersion(name)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/service1", nil)
+		r.RemoteAddr = "198.51.100.7:5555"
+		req, err := newUpstreamRequest(resolveClientIP(r, nil), "http://"+upstream.Addr().String()+"/service1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		resp, err := newProxyProtocolClient(version).Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "198.51.100.7:5555" {
+			t.Errorf("Expected upstream to see client 198.51.100.7:5555 over %s, got %s", name, body)
+		}
+	}
+
+	if _, err := proxyProtocolVersion("v3"); err == nil {
+		t.Errorf("Expected an error for an unknown PROXY protocol version")
+	}
+}
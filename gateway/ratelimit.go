@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+// sweepInterval is how often the in-memory store drops expired windows.
+const sweepInterval = time.Minute
+
+type RateLimiter struct {
+	store    RateLimitStore
+	requests int64
+	period   time.Duration
+	log      *log.Logger
+}
+
+// NewRateLimiter initializes a RateLimiter allowing requests per period, counted in store.
+func NewRateLimiter(store RateLimitStore, requests int64, period time.Duration, log *log.Logger) *RateLimiter {
+	return &RateLimiter{
+		store:    store,
+		requests: requests,
+		period:   period,
+		log:      log,
+	}
+}
+
+// Allow records a request for key and reports whether it is within the limit,
+// together with the remaining quota and the time until the window resets.
+// If the store is unavailable the request is allowed, so a store outage
+// does not take the gateway down with it.
+func (rl *RateLimiter) Allow(ctx context.Context, key string) (bool, int64, time.Duration) {
+	count, reset, err := rl.store.Increment(ctx, key, rl.period)
+	if err != nil {
+		rl.log.Sugar().Warnf("RateLimiter: store error for key %s, allowing request: %v", key, err)
+		return true, rl.requests, rl.period
+	}
+
+	remaining := rl.requests - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return count <= rl.requests, remaining, reset
+}
+
+// newRateLimitStore creates the rate limit store described by config.
+func newRateLimitStore(config RateLimitStoreConfig) (RateLimitStore, error) {
+	switch config.Type {
+	case "", "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "redis":
+		return NewRedisRateLimitStore(config.Address, config.Password, config.DB, config.Prefix), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store type: %s", config.Type)
+	}
+}
+
+type rateLimitWindow struct {
+	count   int64
+	expires time.Time
+}
+
+type MemoryRateLimitStore struct {
+	windows   map[string]*rateLimitWindow
+	lastSweep time.Time
+	mux       sync.Mutex
+}
+
+// NewMemoryRateLimitStore initializes a MemoryRateLimitStore.
+// Counts are local to the process, so limits apply per gateway replica.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows:   make(map[string]*rateLimitWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+// Increment records a hit for key using fixed windows of the given length.
+func (m *MemoryRateLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, w := range m.windows {
+			if !now.Before(w.expires) {
+				delete(m.windows, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.expires) {
+		w = &rateLimitWindow{expires: now.Add(window)}
+		m.windows[key] = w
+	}
+	w.count++
+	return w.count, w.expires.Sub(now), nil
+}
@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMemoryStoreIncrement(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	for i := int64(1); i <= 3; i++ {
+		count, reset, err := store.Increment(context.Background(), "key", time.Minute)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if count != i {
+			t.Errorf("Expected count %d, but got %d", i, count)
+		}
+		if reset <= 0 || reset > time.Minute {
+			t.Errorf("Expected reset within the window, but got %v", reset)
+		}
+	}
+
+	count, _, _ := store.Increment(context.Background(), "other", time.Minute)
+	if count != 1 {
+		t.Errorf("Expected keys to be counted separately, but got %d", count)
+	}
+}
+
+func TestMemoryStoreWindowReset(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	store.Increment(context.Background(), "key", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	count, _, _ := store.Increment(context.Background(), "key", 10*time.Millisecond)
+	if count != 1 {
+		t.Errorf("Expected count to reset after the window, but got %d", count)
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+	rl := NewRateLimiter(NewMemoryRateLimitStore(), 2, time.Minute, logger)
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := rl.Allow(context.Background(), "client"); !allowed {
+			t.Errorf("Request %d: expected to be allowed", i)
+		}
+	}
+	allowed, remaining, _ := rl.Allow(context.Background(), "client")
+	if allowed {
+		t.Errorf("Expected request over the limit to be rejected")
+	}
+	if remaining != 0 {
+		t.Errorf("Expected 0 remaining, but got %d", remaining)
+	}
+}
+
+func TestRouteHandler_RateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+		endpoints:        []string{server.URL},
+		rateLimiter:      NewRateLimiter(NewMemoryRateLimitStore(), 1, time.Minute, g.log),
+	}
+
+	w := httptest.NewRecorder()
+	g.routeHandler(w, httptest.NewRequest("GET", "/service1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	g.routeHandler(w, httptest.NewRequest("GET", "/service1", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status TooManyRequests, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected Retry-After header to be set")
+	}
+}
+
+func TestRouteHandler_RateLimitedPerConsumer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store, _ := NewConsumerStore([]ConsumerConfig{
+		{Name: "a", APIKeys: []string{sha256Hash("key-a")}},
+		{Name: "b", APIKeys: []string{sha256Hash("key-b")}},
+	})
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+		endpoints:        []string{server.URL},
+		authenticator:    NewAPIKeyAuthenticator(&APIKeyConfig{}, store),
+		rateLimiter:      NewRateLimiter(NewMemoryRateLimitStore(), 1, time.Minute, g.log),
+	}
+
+	// Both consumers share a client address but get their own quota
+	for _, test := range []struct {
+		key  string
+		code int
+	}{{"key-a", http.StatusOK}, {"key-b", http.StatusOK}, {"key-a", http.StatusTooManyRequests}} {
+		req := httptest.NewRequest("GET", "/service1", nil)
+		req.Header.Set(defaultAPIKeyHeader, test.key)
+		w := httptest.NewRecorder()
+		g.routeHandler(w, req)
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.key, test.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultRedisPrefix namespaces the gateway's keys when no prefix is configured.
+const defaultRedisPrefix = "gateway:ratelimit"
+
+// incrementScript bumps the counter for a window and starts its expiry whenever the counter
+// has none, atomically, so a counter left without a TTL (e.g. by a failed PEXPIRE or a
+// PERSIST) cannot block its caller forever.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+type RedisRateLimitStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisRateLimitStore initializes a RedisRateLimitStore talking to the server at address.
+// Any server speaking the Redis protocol with Lua scripting can be used.
+func NewRedisRateLimitStore(address, password string, db int, prefix string) *RedisRateLimitStore {
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	return &RedisRateLimitStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     address,
+			Password: password,
+			DB:       db,
+		}),
+		prefix: prefix,
+	}
+}
+
+// Increment records a hit for key using fixed windows of the given length.
+func (rs *RedisRateLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	result, err := incrementScript.Run(ctx, rs.client, []string{rs.prefix + ":" + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reset := time.Duration(result[1]) * time.Millisecond
+	if reset < 0 {
+		reset = window
+	}
+	return result[0], reset, nil
+}
+
+// Close closes the connection pool to the server.
+func (rs *RedisRateLimitStore) Close() error {
+	return rs.client.Close()
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+func TestRedisStoreIncrement(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(server.Addr(), "", 0, "")
+	defer store.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		count, reset, err := store.Increment(context.Background(), "key", time.Minute)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if count != i {
+			t.Errorf("Expected count %d, but got %d", i, count)
+		}
+		if reset <= 0 || reset > time.Minute {
+			t.Errorf("Expected reset within the window, but got %v", reset)
+		}
+	}
+
+	if !server.Exists(defaultRedisPrefix + ":key") {
+		t.Errorf("Expected key to be stored under the default prefix")
+	}
+}
+
+func TestRedisStoreWindowReset(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(server.Addr(), "", 0, "test")
+	defer store.Close()
+
+	store.Increment(context.Background(), "key", time.Minute)
+	server.FastForward(time.Minute)
+
+	count, _, err := store.Increment(context.Background(), "key", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected count to reset after the window, but got %d", count)
+	}
+}
+
+func TestRedisStoreRestoresMissingExpiry(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(server.Addr(), "", 0, "test")
+	defer store.Close()
+
+	// A counter that lost its TTL gets a new one on the next hit
+	server.Set("test:key", "5")
+	count, reset, err := store.Increment(context.Background(), "key", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 6 || reset != time.Minute {
+		t.Errorf("Expected count 6 with a reset of %v, but got %d and %v", time.Minute, count, reset)
+	}
+	if ttl := server.TTL("test:key"); ttl != time.Minute {
+		t.Errorf("Expected the counter to expire after the window, but got TTL %v", ttl)
+	}
+}
+
+// Two gateway replicas pointing at the same server share a single quota.
+func TestRedisStoreSharedAcrossReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+
+	storeA := NewRedisRateLimitStore(server.Addr(), "", 0, "")
+	defer storeA.Close()
+	storeB := NewRedisRateLimitStore(server.Addr(), "", 0, "")
+	defer storeB.Close()
+
+	replicaA := NewRateLimiter(storeA, 2, time.Minute, logger)
+	replicaB := NewRateLimiter(storeB, 2, time.Minute, logger)
+
+	replicaA.Allow(context.Background(), "client")
+	replicaB.Allow(context.Background(), "client")
+	if allowed, _, _ := replicaA.Allow(context.Background(), "client"); allowed {
+		t.Errorf("Expected the shared quota to be exhausted")
+	}
+}
+
+func TestRedisStoreUnavailableFailsOpen(t *testing.T) {
+	server := miniredis.RunT(t)
+	loggerConfig := zap.NewProductionConfig()
+	logger, _ := loggerConfig.Build()
+
+	store := NewRedisRateLimitStore(server.Addr(), "", 0, "")
+	defer store.Close()
+	server.Close()
+
+	rl := NewRateLimiter(store, 1, time.Minute, logger)
+	if allowed, _, _ := rl.Allow(context.Background(), "client"); !allowed {
+		t.Errorf("Expected request to be allowed when the store is unavailable")
+	}
+}
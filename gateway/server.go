@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serve runs the gateway's HTTP server on listener. With a TLS config the server negotiates
+// HTTP/2 with ALPN; without one it accepts cleartext HTTP/2 (h2c) when enabled.
+func (g *Gateway) serve(listener net.Listener, handler http.Handler, tlsConfig *TLSConfig, h2cEnabled bool, serverConfig *ServerConfig) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: defaultReadHeaderTimeout}
+	if serverConfig != nil && serverConfig.ReadHeaderTimeout > 0 {
+		server.ReadHeaderTimeout = serverConfig.ReadHeaderTimeout
+	}
+	if tlsConfig != nil {
+		config, err := newTLSConfig(tlsConfig)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = config
+		return server.ServeTLS(listener, "", "")
+	}
+	if h2cEnabled {
+		server.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	return server.Serve(listener)
+}
+
+// newTLSConfig loads the listener's certificate.
+func newTLSConfig(config *TLSConfig) (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("tls: certFile and keyFile are required")
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// newUpstreamClient returns the client used to reach a service's endpoints for the given
+// protocol. HTTP/2 clients multiplex requests over one connection per endpoint, while the
+// load balancer still picks an endpoint for every request.
+func newUpstreamClient(protocol string) (*http.Client, error) {
+	switch protocol {
+	case "", "http1":
+		return nil, nil
+	case "h2":
+		return &http.Client{Transport: &http2.Transport{}}, nil
+	case "h2c":
+		// h2c uses prior knowledge: the connection starts with the HTTP/2 preface
+		dialer := &net.Dialer{}
+		return &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, address string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+		}}, nil
+	default:
+		return nil, fmt.Errorf("protocol: unknown protocol %q, use http1, h2 or h2c", protocol)
+	}
+}
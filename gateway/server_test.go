@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and returns its TLS config.
+func writeTestCertificate(t *testing.T) *TLSConfig {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	dir := t.TempDir()
+	config := &TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	os.WriteFile(config.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(config.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return config
+}
+
+func TestServe_TLSNegotiatesHTTP2(t *testing.T) {
+	g := createTestGateway("")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go g.serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), writeTestCertificate(t), false, nil)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2 over TLS, got %s", resp.Proto)
+	}
+}
+
+func TestNewTLSConfig_Invalid(t *testing.T) {
+	if _, err := newTLSConfig(&TLSConfig{CertFile: "cert.pem"}); err == nil {
+		t.Errorf("Expected an error without a key file")
+	}
+	if _, err := newTLSConfig(&TLSConfig{CertFile: "missing.pem", KeyFile: "missing.pem"}); err == nil {
+		t.Errorf("Expected an error for missing files")
+	}
+}
+
+func TestNewUpstreamClient(t *testing.T) {
+	for _, protocol := range []string{"", "http1"} {
+		if client, err := newUpstreamClient(protocol); err != nil || client != nil {
+			t.Errorf("Expected the default client for %q, got %v, %v", protocol, client, err)
+		}
+	}
+	for _, protocol := range []string{"h2", "h2c"} {
+		if client, err := newUpstreamClient(protocol); err != nil || client == nil {
+			t.Errorf("Expected an HTTP/2 client for %q, got %v", protocol, err)
+		}
+	}
+	if _, err := newUpstreamClient("spdy"); err == nil {
+		t.Errorf("Expected an error for an unknown protocol")
+	}
+}
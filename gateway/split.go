@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+)
+
+// splitBuckets is the fixed range users are hashed into. The weights divide it into
+// consecutive ranges, so a user's bucket does not depend on the weights.
+const splitBuckets = 10000
+
+type TrafficSplit struct {
+	targets []splitTarget
+	// total is the sum of the target weights
+	total  int
+	header string
+	cookie string
+	sticky headerTemplate
+}
+
+// splitTarget is a service receiving a share of a split route's requests.
+type splitTarget struct {
+	service string
+	weight  int
+}
+
+// NewTrafficSplit initializes a TrafficSplit from config.
+func NewTrafficSplit(config *SplitConfig) (*TrafficSplit, error) {
+	if len(config.Services) == 0 {
+		return nil, fmt.Errorf("split: services are required")
+	}
+	split := &TrafficSplit{header: config.Header, cookie: config.Cookie}
+	seen := make(map[string]bool)
+	for _, target := range config.Services {
+		if target.Service == "" {
+			return nil, fmt.Errorf("split: service is required")
+		}
+		if seen[target.Service] {
+			return nil, fmt.Errorf("split: service %s is listed twice", target.Service)
+		}
+		if target.Weight < 0 {
+			return nil, fmt.Errorf("split: service %s: weight must not be negative", target.Service)
+		}
+		seen[target.Service] = true
+		split.targets = append(split.targets, splitTarget{service: target.Service, weight: target.Weight})
+		split.total += target.Weight
+	}
+	if split.total == 0 {
+		return nil, fmt.Errorf("split: at least one service needs a weight")
+	}
+	if config.Sticky != "" {
+		sticky, err := parseHeaderTemplate(config.Sticky)
+		if err != nil {
+			return nil, fmt.Errorf("split: sticky: %w", err)
+		}
+		split.sticky = sticky
+	}
+	return split, nil
+}
+
+// Services returns the names of the services the route is split across.
+func (ts *TrafficSplit) Services() []string {
+	services := make([]string, len(ts.targets))
+	for i, target := range ts.targets {
+		services[i] = target.service
+	}
+	return services
+}
+
+// Choose returns the service that handles r on the route. A version forced by the override
+// header or cookie wins; otherwise the service is picked by weight, by hashing the sticky
+// key so that a user keeps their version, or at random without one.
+func (ts *TrafficSplit) Choose(r *http.Request, route string) string {
+	if ts.header != "" {
+		if forced := r.Header.Get(ts.header); ts.has(forced) {
+			return forced
+		}
+	}
+	if ts.cookie != "" {
+		if cookie, err := r.Cookie(ts.cookie); err == nil && ts.has(cookie.Value) {
+			return cookie.Value
+		}
+	}
+
+	var bucket int64
+	if key := ts.stickyKey(r, route); key != "" {
+		hash := fnv.New64a()
+		hash.Write([]byte(key))
+		bucket = int64(hash.Sum64() % splitBuckets)
+	} else {
+		bucket = rand.Int63n(splitBuckets)
+	}
+	// Each service takes the range of buckets below its cumulative share. A user only changes
+	// service when a boundary next to their bucket moves, so shifting weight between two
+	// neighbouring services moves users between those two alone
+	var upper int64
+	for _, target := range ts.targets {
+		upper += int64(target.weight)
+		if bucket*int64(ts.total) < upper*splitBuckets {
+			return target.service
+		}
+	}
+	return ts.targets[len(ts.targets)-1].service
+}
+
+// stickyKey renders the key identifying the user of r, or "" when stickiness is off.
+func (ts *TrafficSplit) stickyKey(r *http.Request, route string) string {
+	if ts.sticky == nil {
+		return ""
+	}
+	return ts.sticky.render(r, route)
+}
+
+// has reports whether service is one of the split's services.
+func (ts *TrafficSplit) has(service string) bool {
+	for _, target := range ts.targets {
+		if target.service == service {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRoute returns the service chosen to handle r on a split route, or false when the
+// chosen service is no longer configured.
+func (g *Gateway) splitRoute(r *http.Request, route *GatewayServiceConfig) (*GatewayServiceConfig, bool) {
+	name := route.split.Choose(r, route.serviceName)
+	g.lock.Lock()
+	service, exists := g.serviceRegistry[name]
+	g.lock.Unlock()
+	if exists {
+		g.log.Sugar().Infof("Split route %s: request %s sent to service %s", route.serviceName, requestID(r), name)
+	}
+	return service, exists
+}
@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSplit(t *testing.T, config *SplitConfig) *TrafficSplit {
+	split, err := NewTrafficSplit(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return split
+}
+
+func TestTrafficSplit_Weights(t *testing.T) {
+	split := newTestSplit(t, &SplitConfig{Services: []SplitServiceConfig{{"serviceA-v1", 90}, {"serviceA-v2", 10}}})
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[split.Choose(httptest.NewRequest("GET", "/serviceA", nil), "serviceA")]++
+	}
+	if counts["serviceA-v2"] < 800 || counts["serviceA-v2"] > 1200 {
+		t.Errorf("Expected about 10%% of requests on serviceA-v2, got %v", counts)
+	}
+}
+
+func TestTrafficSplit_Overrides(t *testing.T) {
+	split := newTestSplit(t, &SplitConfig{
+		Services: []SplitServiceConfig{{"serviceA-v1", 100}, {"serviceA-v2", 0}},
+		Header:   "X-Version",
+		Cookie:   "version",
+	})
+
+	req := httptest.NewRequest("GET", "/serviceA", nil)
+	req.Header.Set("X-Version", "serviceA-v2")
+	if got := split.Choose(req, "serviceA"); got != "serviceA-v2" {
+		t.Errorf("Expected the header to force serviceA-v2, got %s", got)
+	}
+
+	req = httptest.NewRequest("GET", "/serviceA", nil)
+	req.AddCookie(&http.Cookie{Name: "version", Value: "serviceA-v2"})
+	if got := split.Choose(req, "serviceA"); got != "serviceA-v2" {
+		t.Errorf("Expected the cookie to force serviceA-v2, got %s", got)
+	}
+
+	req = httptest.NewRequest("GET", "/serviceA", nil)
+	req.Header.Set("X-Version", "serviceB")
+	if got := split.Choose(req, "serviceA"); got != "serviceA-v1" {
+		t.Errorf("Expected an unknown version to be ignored, got %s", got)
+	}
+}
+
+func TestTrafficSplit_Sticky(t *testing.T) {
+	config := &SplitConfig{Services: []SplitServiceConfig{{"serviceA-v1", 90}, {"serviceA-v2", 10}}, Sticky: "${header:X-User-Id}"}
+	split := newTestSplit(t, config)
+
+	assigned := make(map[string]string)
+	for i := 0; i < 500; i++ {
+		req := httptest.NewRequest("GET", "/serviceA", nil)
+		req.Header.Set("X-User-Id", fmt.Sprintf("user-%d", i))
+		assigned[req.Header.Get("X-User-Id")] = split.Choose(req, "serviceA")
+		if again := split.Choose(req, "serviceA"); again != assigned[req.Header.Get("X-User-Id")] {
+			t.Errorf("Expected user-%d to keep their service, got %s", i, again)
+		}
+	}
+
+	// Progressing the rollout keeps users already on serviceA-v2 there
+	config.Services = []SplitServiceConfig{{"serviceA-v1", 80}, {"serviceA-v2", 20}}
+	split = newTestSplit(t, config)
+	moved := 0
+	for user, service := range assigned {
+		req := httptest.NewRequest("GET", "/serviceA", nil)
+		req.Header.Set("X-User-Id", user)
+		got := split.Choose(req, "serviceA")
+		if service == "serviceA-v2" && got != "serviceA-v2" {
+			t.Errorf("Expected %s to stay on serviceA-v2, got %s", user, got)
+		}
+		if got != service {
+			moved++
+		}
+	}
+	if moved == 0 || moved > 100 {
+		t.Errorf("Expected about 10%% of users to move to serviceA-v2, got %d of 500", moved)
+	}
+
+	// Weights need not add up to the same total for users to keep their service
+	config.Services = []SplitServiceConfig{{"serviceA-v1", 4}, {"serviceA-v2", 1}}
+	split = newTestSplit(t, config)
+	for user, service := range assigned {
+		req := httptest.NewRequest("GET", "/serviceA", nil)
+		req.Header.Set("X-User-Id", user)
+		if got := split.Choose(req, "serviceA"); service == "serviceA-v2" && got != "serviceA-v2" {
+			t.Errorf("Expected %s to stay on serviceA-v2 after rescaling the weights, got %s", user, got)
+		}
+	}
+}
+
+func TestNewTrafficSplit_Errors(t *testing.T) {
+	invalid := map[string]*SplitConfig{
+		"no services":    {},
+		"no weight":      {Services: []SplitServiceConfig{{"a", 0}, {"b", 0}}},
+		"negative":       {Services: []SplitServiceConfig{{"a", 100}, {"b", -1}}},
+		"duplicate":      {Services: []SplitServiceConfig{{"a", 50}, {"a", 50}}},
+		"unnamed":        {Services: []SplitServiceConfig{{"", 100}}},
+		"invalid sticky": {Services: []SplitServiceConfig{{"a", 100}}, Sticky: "${unknown}"},
+	}
+	for name, config := range invalid {
+		if _, err := NewTrafficSplit(config); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestRouteHandler_Split(t *testing.T) {
+	newVersion := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+	}
+	v1, v2 := newVersion("v1"), newVersion("v2")
+	defer v1.Close()
+	defer v2.Close()
+
+	g := createTestGateway("")
+	g.serviceRegistry["serviceA"] = &GatewayServiceConfig{
+		serviceName: "serviceA",
+		split:       newTestSplit(t, &SplitConfig{Services: []SplitServiceConfig{{"serviceA-v1", 100}, {"serviceA-v2", 0}}, Header: "X-Version"}),
+	}
+	for name, server := range map[string]*httptest.Server{"serviceA-v1": v1, "serviceA-v2": v2} {
+		g.serviceRegistry[name] = &GatewayServiceConfig{
+			serviceName:      name,
+			loadBalancerType: &MockLoadBalancer{endpoints: []string{server.URL}},
+			endpoints:        []string{server.URL},
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("GET", "/serviceA/items", nil))
+	if rr.Body.String() != "v1 /serviceA/items" {
+		t.Errorf("Expected serviceA-v1 to serve the route's path, got %q", rr.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/serviceA/items", nil)
+	req.Header.Set("X-Version", "serviceA-v2")
+	rr = httptest.NewRecorder()
+	g.routeHandler(rr, req)
+	if rr.Body.String() != "v2 /serviceA/items" {
+		t.Errorf("Expected the header to force serviceA-v2, got %q", rr.Body.String())
+	}
+
+	delete(g.serviceRegistry, "serviceA-v1")
+	rr = httptest.NewRecorder()
+	g.routeHandler(rr, httptest.NewRequest("GET", "/serviceA/items", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d for a missing version, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+func TestLoadConfig_SplitReload(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(split string) {
+		content := `
+services:
+  serviceA:
+    split:
+` + split + `
+  serviceA-v1:
+    endpoints:
+      - http://localhost:8081
+    loadBalancer: round-robin
+  serviceA-v2:
+    endpoints:
+      - http://localhost:8082
+    loadBalancer: round-robin
+`
+		if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+
+	g := createTestGateway(configPath)
+	writeConfig("      services:\n        - {service: serviceA-v1, weight: 100}\n        - {service: serviceA-v2, weight: 0}")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if got := g.serviceRegistry["serviceA"].split.Choose(httptest.NewRequest("GET", "/serviceA", nil), "serviceA"); got != "serviceA-v1" {
+		t.Errorf("Expected serviceA-v1, got %s", got)
+	}
+
+	writeConfig("      services:\n        - {service: serviceA-v1, weight: 0}\n        - {service: serviceA-v2, weight: 100}")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if got := g.serviceRegistry["serviceA"].split.Choose(httptest.NewRequest("GET", "/serviceA", nil), "serviceA"); got != "serviceA-v2" {
+		t.Errorf("Expected the reloaded weights to send requests to serviceA-v2, got %s", got)
+	}
+
+	for _, invalid := range []string{
+		"      services:\n        - {service: missing, weight: 100}",
+		"      services:\n        - {service: serviceA, weight: 100}",
+	} {
+		writeConfig(invalid)
+		if err := g.loadConfig(); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
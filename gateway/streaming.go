@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flushInterval returns how often the response is flushed to the client: negative to flush
+// after every write, zero to leave buffering to the server. Server-Sent Events and gRPC
+// messages are always flushed immediately.
+func flushInterval(resp *http.Response, config *StreamingConfig) time.Duration {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" || mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+") {
+		return -1
+	}
+	if config == nil {
+		return 0
+	}
+	if config.FlushImmediately {
+		return -1
+	}
+	return config.FlushInterval
+}
+
+// copyResponse copies src to w, flushing according to interval. Failures reading src and
+// writing w are reported separately, since only the former leaves the client connected.
+func copyResponse(w io.Writer, src io.Reader, interval time.Duration) (readErr error, writeErr error) {
+	flush := flusherFor(w)
+	if flush != nil && interval > 0 {
+		periodic := &periodicFlusher{w: w, flushClient: flush, interval: interval}
+		defer periodic.stop()
+		w = periodic
+	}
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return nil, werr
+			}
+			if flush != nil && interval < 0 {
+				flush()
+			}
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+}
+
+// flusherFor returns a function flushing w to the client, or nil if w cannot be flushed.
+// Response writers are flushed through http.ResponseController, which finds the Flush
+// method of wrapped writers through their Unwrap method.
+func flusherFor(w io.Writer) func() error {
+	switch w := w.(type) {
+	case http.ResponseWriter:
+		return http.NewResponseController(w).Flush
+	case http.Flusher:
+		return func() error {
+			w.Flush()
+			return nil
+		}
+	}
+	return nil
+}
+
+// periodicFlusher flushes writes to the client at most interval after they are made.
+type periodicFlusher struct {
+	w           io.Writer
+	flushClient func() error
+	interval    time.Duration
+	timer       *time.Timer
+	pending     bool
+	mux         sync.Mutex
+}
+
+func (pf *periodicFlusher) Write(p []byte) (int, error) {
+	pf.mux.Lock()
+	defer pf.mux.Unlock()
+
+	n, err := pf.w.Write(p)
+	if err != nil || pf.pending {
+		return n, err
+	}
+	pf.pending = true
+	if pf.timer == nil {
+		pf.timer = time.AfterFunc(pf.interval, pf.flush)
+	} else {
+		pf.timer.Reset(pf.interval)
+	}
+	return n, nil
+}
+
+func (pf *periodicFlusher) flush() {
+	pf.mux.Lock()
+	defer pf.mux.Unlock()
+
+	if pf.pending {
+		pf.flushClient()
+		pf.pending = false
+	}
+}
+
+// stop cancels any scheduled flush; the server flushes the rest when the handler returns.
+func (pf *periodicFlusher) stop() {
+	pf.mux.Lock()
+	defer pf.mux.Unlock()
+
+	pf.pending = false
+	if pf.timer != nil {
+		pf.timer.Stop()
+	}
+}
@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlushInterval(t *testing.T) {
+	cases := []struct {
+		contentType string
+		config      *StreamingConfig
+		expected    time.Duration
+	}{
+		{"application/json", nil, 0},
+		{"text/event-stream", nil, -1},
+		{"text/event-stream; charset=utf-8", &StreamingConfig{FlushInterval: time.Second}, -1},
+		{"application/json", &StreamingConfig{FlushInterval: time.Second}, time.Second},
+		{"application/x-ndjson", &StreamingConfig{FlushImmediately: true}, -1},
+	}
+	for _, c := range cases {
+		resp := &http.Response{Header: http.Header{"Content-Type": []string{c.contentType}}}
+		if got := flushInterval(resp, c.config); got != c.expected {
+			t.Errorf("Expected flush interval %v for %q, got %v", c.expected, c.contentType, got)
+		}
+	}
+}
+
+// streamingUpstream writes the first event, then blocks until release is closed or the request is cancelled.
+func streamingUpstream(contentType string, release chan struct{}, cancelled chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+			w.Write([]byte("data: second\n\n"))
+		case <-r.Context().Done():
+			close(cancelled)
+		}
+	}))
+}
+
+func createStreamingGateway(upstreamURL string, config *StreamingConfig) *httptest.Server {
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{upstreamURL}},
+		endpoints:        []string{upstreamURL},
+		streaming:        config,
+	}
+	return httptest.NewServer(http.HandlerFunc(g.routeHandler))
+}
+
+func readFirstEvent(t *testing.T, ctx context.Context, url string) (*http.Response, string) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	line := make(chan string, 1)
+	go func() {
+		text, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- text
+	}()
+	select {
+	case text := <-line:
+		return resp, text
+	case <-time.After(2 * time.Second):
+		resp.Body.Close()
+		t.Fatalf("Expected the first event before the upstream response completed")
+		return nil, ""
+	}
+}
+
+func TestRouteHandler_StreamsServerSentEvents(t *testing.T) {
+	release, cancelled := make(chan struct{}), make(chan struct{})
+	upstream := streamingUpstream("text/event-stream", release, cancelled)
+	defer upstream.Close()
+	gateway := createStreamingGateway(upstream.URL, nil)
+	defer gateway.Close()
+
+	resp, first := readFirstEvent(t, context.Background(), gateway.URL+"/service1")
+	defer resp.Body.Close()
+	if first != "data: first\n" {
+		t.Errorf("Expected the first event, got %q", first)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Expected Content-Type text/event-stream, got %q", got)
+	}
+	close(release)
+}
+
+func TestRouteHandler_FlushInterval(t *testing.T) {
+	release, cancelled := make(chan struct{}), make(chan struct{})
+	upstream := streamingUpstream("application/x-ndjson", release, cancelled)
+	defer upstream.Close()
+	gateway := createStreamingGateway(upstream.URL, &StreamingConfig{FlushInterval: 20 * time.Millisecond})
+	defer gateway.Close()
+
+	resp, first := readFirstEvent(t, context.Background(), gateway.URL+"/service1")
+	defer resp.Body.Close()
+	if first != "data: first\n" {
+		t.Errorf("Expected the first chunk to be flushed, got %q", first)
+	}
+	close(release)
+}
+
+func TestRouteHandler_ClientDisconnectCancelsUpstream(t *testing.T) {
+	release, cancelled := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	upstream := streamingUpstream("text/event-stream", release, cancelled)
+	defer upstream.Close()
+	gateway := createStreamingGateway(upstream.URL, nil)
+	defer gateway.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, _ := readFirstEvent(t, ctx, gateway.URL+"/service1")
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected the upstream request to be cancelled when the client disconnected")
+	}
+}
+
+func TestCopyResponse(t *testing.T) {
+	rr := httptest.NewRecorder()
+	readErr, writeErr := copyResponse(rr, strings.NewReader("payload"), -1)
+	if readErr != nil || writeErr != nil {
+		t.Fatalf("Unexpected errors: %v, %v", readErr, writeErr)
+	}
+	if rr.Body.String() != "payload" || !rr.Flushed {
+		t.Errorf("Expected the flushed payload, got %q (flushed %v)", rr.Body.String(), rr.Flushed)
+	}
+}
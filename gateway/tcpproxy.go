@@ -0,0 +1,219 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	// tcpDialTimeout bounds connecting to an endpoint
+	tcpDialTimeout = 10 * time.Second
+	// clientHelloTimeout bounds reading the TLS ClientHello of an SNI-routed connection
+	clientHelloTimeout = 10 * time.Second
+)
+
+type TCPProxy struct {
+	name    string
+	address string
+	active  atomic.Int64
+	log     *log.Logger
+
+	mux    sync.RWMutex
+	routes *tcpRoutes
+}
+
+// tcpRoutes holds the reloadable part of a TCP listener's configuration.
+type tcpRoutes struct {
+	defaultRoute LoadBalancer
+	// sni maps lowercase server names, or wildcards such as *.example.com, to their endpoints
+	sni            map[string]LoadBalancer
+	maxConnections int
+	idleTimeout    time.Duration
+}
+
+// NewTCPProxy initializes a TCPProxy for the named listener from config.
+func NewTCPProxy(name string, config *TCPListenerConfig, log *log.Logger) (*TCPProxy, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("tcp %s: address is required", name)
+	}
+	routes := &tcpRoutes{maxConnections: config.MaxConnections, idleTimeout: config.IdleTimeout}
+	if len(config.Endpoints) > 0 {
+		lb, err := newHostPortLoadBalancer(config.LoadBalancer, config.Endpoints, log)
+		if err != nil {
+			return nil, fmt.Errorf("tcp %s: %w", name, err)
+		}
+		routes.defaultRoute = lb
+	}
+	if len(config.SNI) > 0 {
+		routes.sni = make(map[string]LoadBalancer)
+		for serverName, route := range config.SNI {
+			lb, err := newHostPortLoadBalancer(route.LoadBalancer, route.Endpoints, log)
+			if err != nil {
+				return nil, fmt.Errorf("tcp %s: sni %s: %w", name, serverName, err)
+			}
+			routes.sni[strings.ToLower(serverName)] = lb
+		}
+	}
+	if routes.defaultRoute == nil && routes.sni == nil {
+		return nil, fmt.Errorf("tcp %s: endpoints or sni routes are required", name)
+	}
+	return &TCPProxy{name: name, address: config.Address, routes: routes, log: log}, nil
+}
+
+// newHostPortLoadBalancer returns the named load balancer over host:port endpoints.
+func newHostPortLoadBalancer(name string, endpoints []string, log *log.Logger) (LoadBalancer, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("endpoints are required")
+	}
+	for _, endpoint := range endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return nil, fmt.Errorf("endpoint %q must be host:port", endpoint)
+		}
+	}
+	lb := newLoadBalancer(name, endpoints, log)
+	if lb == nil {
+		return nil, fmt.Errorf("unknown loadBalancer %q", name)
+	}
+	return lb, nil
+}
+
+// reload takes over the routes, limits and timeouts of a proxy built from a newer config.
+// The listening address only changes on restart.
+func (p *TCPProxy) reload(from *TCPProxy) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.routes = from.routes
+}
+
+// Serve accepts connections on listener and forwards each one to an endpoint until the
+// listener is closed.
+func (p *TCPProxy) Serve(listener net.Listener) error {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		p.mux.RLock()
+		routes := p.routes
+		p.mux.RUnlock()
+		if routes.maxConnections > 0 && p.active.Load() >= int64(routes.maxConnections) {
+			p.log.Sugar().Infof("TCP listener %s: rejecting connection from %s, %d connections open", p.name, client.RemoteAddr(), routes.maxConnections)
+			client.Close()
+			continue
+		}
+		p.active.Add(1)
+		go func() {
+			defer p.active.Add(-1)
+			p.handle(client, routes)
+		}()
+	}
+}
+
+// handle forwards one client connection to an endpoint chosen by its route's load balancer.
+func (p *TCPProxy) handle(client net.Conn, routes *tcpRoutes) {
+	defer client.Close()
+
+	lb := routes.defaultRoute
+	var clientReader io.Reader = client
+	if routes.sni != nil {
+		client.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+		serverName, hello, err := readClientHello(client)
+		client.SetReadDeadline(time.Time{})
+		if err != nil {
+			p.log.Sugar().Infof("TCP listener %s: %v from %s", p.name, err, client.RemoteAddr())
+			return
+		}
+		if route := routes.route(serverName); route != nil {
+			lb = route
+		}
+		if lb == nil {
+			p.log.Sugar().Infof("TCP listener %s: no route for server name %q from %s", p.name, serverName, client.RemoteAddr())
+			return
+		}
+		// The ClientHello is replayed to the endpoint, which terminates TLS
+		clientReader = io.MultiReader(bytes.NewReader(hello), client)
+	}
+
+	endpoint := nextEndpoint(lb, stripPort(client.RemoteAddr().String()))
+	if releaser, ok := lb.(EndpointReleaser); ok {
+		defer releaser.ReleaseEndpoint(endpoint)
+	}
+	if endpoint == "" {
+		p.log.Sugar().Infof("TCP listener %s: no endpoints available", p.name)
+		return
+	}
+	backend, err := net.DialTimeout("tcp", endpoint, tcpDialTimeout)
+	if err != nil {
+		p.log.Sugar().Infof("TCP listener %s: connecting to %s: %v", p.name, endpoint, err)
+		return
+	}
+
+	start := time.Now()
+	reason := tunnel(client, clientReader, backend, routes.idleTimeout, 0)
+	p.log.Sugar().Infof("TCP listener %s: closed connection from %s to %s after %v: %s", p.name, client.RemoteAddr(), endpoint, time.Since(start).Round(time.Millisecond), reason)
+}
+
+// route returns the load balancer for a server name, trying an exact match before a
+// wildcard for its parent domain.
+func (r *tcpRoutes) route(serverName string) LoadBalancer {
+	serverName = strings.ToLower(serverName)
+	if lb, ok := r.sni[serverName]; ok {
+		return lb
+	}
+	if _, parent, found := strings.Cut(serverName, "."); found {
+		return r.sni["*."+parent]
+	}
+	return nil
+}
+
+// errClientHelloRead stops the TLS handshake once the ClientHello has been parsed.
+var errClientHelloRead = errors.New("client hello read")
+
+// readClientHello reads a TLS ClientHello from conn without answering it, returning the
+// requested server name and the bytes read, which must be replayed to the endpoint.
+func readClientHello(conn net.Conn) (string, []byte, error) {
+	var hello bytes.Buffer
+	var serverName string
+	err := tls.Server(&sniffConn{Conn: conn, reader: io.TeeReader(conn, &hello)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = info.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	if !errors.Is(err, errClientHelloRead) {
+		return "", nil, fmt.Errorf("reading TLS ClientHello: %w", err)
+	}
+	return serverName, hello.Bytes(), nil
+}
+
+// sniffConn lets the TLS handshake read from a connection but not answer on it.
+type sniffConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *sniffConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
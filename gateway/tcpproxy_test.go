@@ -0,0 +1,213 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startNamedServer accepts connections, greets each with its name and echoes what it reads.
+// With a TLS config it terminates TLS first.
+func startNamedServer(t *testing.T, name string, config *tls.Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, name+"\n")
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startTCPProxy(t *testing.T, config *TCPListenerConfig) (*TCPProxy, string) {
+	logger, _ := zap.NewProductionConfig().Build()
+	config.Address = "127.0.0.1:0"
+	proxy, err := NewTCPProxy("test", config, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go proxy.Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+	return proxy, listener.Addr().String()
+}
+
+// greeting reads the first line the endpoint sends on conn.
+func greeting(t *testing.T, conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+func TestTCPProxy_BalancesConnections(t *testing.T) {
+	first := startNamedServer(t, "first", nil)
+	second := startNamedServer(t, "second", nil)
+	_, address := startTCPProxy(t, &TCPListenerConfig{Endpoints: []string{first, second}, LoadBalancer: "round-robin"})
+
+	var names []string
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		names = append(names, greeting(t, conn))
+		conn.Close()
+	}
+	if strings.Join(names, ",") != "first,second,first,second" {
+		t.Errorf("Expected connections to alternate between endpoints, got %v", names)
+	}
+}
+
+func TestTCPProxy_ForwardsBytes(t *testing.T) {
+	endpoint := startNamedServer(t, "echo", nil)
+	_, address := startTCPProxy(t, &TCPListenerConfig{Endpoints: []string{endpoint}, LoadBalancer: "least-connections"})
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader.ReadString('\n')
+	io.WriteString(conn, "PING\r\n")
+	if line, _ := reader.ReadString('\n'); line != "PING\r\n" {
+		t.Errorf("Expected the endpoint to echo PING, got %q", line)
+	}
+}
+
+func TestTCPProxy_MaxConnections(t *testing.T) {
+	endpoint := startNamedServer(t, "echo", nil)
+	_, address := startTCPProxy(t, &TCPListenerConfig{Endpoints: []string{endpoint}, LoadBalancer: "round-robin", MaxConnections: 1})
+
+	held, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer held.Close()
+	greeting(t, held)
+
+	rejected, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected a connection over the limit to be closed, got %v", err)
+	}
+}
+
+func TestTCPProxy_IdleTimeout(t *testing.T) {
+	endpoint := startNamedServer(t, "echo", nil)
+	_, address := startTCPProxy(t, &TCPListenerConfig{Endpoints: []string{endpoint}, LoadBalancer: "round-robin", IdleTimeout: 100 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer conn.Close()
+	greeting(t, conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("Expected an idle connection to be closed, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func TestTCPProxy_SNIPassthrough(t *testing.T) {
+	certFiles := writeTestCertificate(t)
+	cert, err := tls.LoadX509KeyPair(certFiles.CertFile, certFiles.KeyFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	api := startNamedServer(t, "api", serverConfig)
+	web := startNamedServer(t, "web", serverConfig)
+	_, address := startTCPProxy(t, &TCPListenerConfig{SNI: map[string]TCPRouteConfig{
+		"API.example.com": {Endpoints: []string{api}, LoadBalancer: "round-robin"},
+		"*.example.org":   {Endpoints: []string{web}, LoadBalancer: "round-robin"},
+	}})
+
+	cases := map[string]string{"api.example.com": "api", "www.example.org": "web"}
+	for serverName, expected := range cases {
+		conn, err := tls.Dial("tcp", address, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", serverName, err)
+		}
+		if got := greeting(t, conn); got != expected {
+			t.Errorf("Expected %s to be routed to %s, got %s", serverName, expected, got)
+		}
+		conn.Close()
+	}
+
+	if _, err := tls.Dial("tcp", address, &tls.Config{ServerName: "other.example.net", InsecureSkipVerify: true}); err == nil {
+		t.Errorf("Expected a server name without a route to be rejected")
+	}
+}
+
+func TestTCPRoutes_Route(t *testing.T) {
+	exact := &MockLoadBalancer{endpoints: []string{"exact:443"}}
+	wildcard := &MockLoadBalancer{endpoints: []string{"wildcard:443"}}
+	routes := &tcpRoutes{sni: map[string]LoadBalancer{"a.example.com": exact, "*.example.com": wildcard}}
+
+	cases := map[string]LoadBalancer{
+		"a.example.com":   exact,
+		"A.Example.com":   exact,
+		"b.example.com":   wildcard,
+		"a.b.example.com": nil,
+		"example.com":     nil,
+		"":                nil,
+	}
+	for serverName, expected := range cases {
+		if got := routes.route(serverName); got != expected {
+			t.Errorf("Expected route for %q to be %v, got %v", serverName, expected, got)
+		}
+	}
+}
+
+func TestNewTCPProxy_Errors(t *testing.T) {
+	logger, _ := zap.NewProductionConfig().Build()
+	invalid := map[string]*TCPListenerConfig{
+		"no address":      {Endpoints: []string{"127.0.0.1:5432"}},
+		"no routes":       {Address: ":5432"},
+		"no port":         {Address: ":5432", Endpoints: []string{"127.0.0.1"}},
+		"unknown lb":      {Address: ":5432", Endpoints: []string{"127.0.0.1:5432"}, LoadBalancer: "random"},
+		"empty sni route": {Address: ":443", SNI: map[string]TCPRouteConfig{"a.example.com": {LoadBalancer: "round-robin"}}},
+	}
+	for name, config := range invalid {
+		if _, err := NewTCPProxy("test", config, logger); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
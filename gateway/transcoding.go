@@ -0,0 +1,460 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxTranscodedMessage bounds transcoded request bodies and responses, matching gRPC's
+// default maximum message size.
+const maxTranscodedMessage = 4 << 20
+
+// errTranscodedBodyTooLarge is returned for request bodies that do not fit in a gRPC message.
+var errTranscodedBodyTooLarge = fmt.Errorf("request body exceeds %d bytes", maxTranscodedMessage)
+
+type Transcoder struct {
+	bindings []*httpBinding
+}
+
+// httpBinding maps an HTTP method and path template to a unary gRPC method.
+type httpBinding struct {
+	method   string
+	template *pathTemplate
+	// body is the request field the HTTP body is decoded into: "*" for the whole request
+	// message, or empty when the body is not used
+	body       string
+	rpc        protoreflect.MethodDescriptor
+	fullMethod string
+}
+
+// NewTranscoder loads the google.api.http annotations of the services in a descriptor set,
+// as written by protoc --include_imports --descriptor_set_out. When services are listed,
+// only their methods are transcoded.
+func NewTranscoder(config *TranscodingConfig) (*Transcoder, error) {
+	data, err := os.ReadFile(config.DescriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("transcoding: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("transcoding: parsing descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("transcoding: %w", err)
+	}
+
+	transcoder := &Transcoder{}
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len() && err == nil; i++ {
+			service := services.Get(i)
+			if len(config.Services) > 0 && !containsString(config.Services, string(service.FullName())) {
+				continue
+			}
+			methods := service.Methods()
+			for j := 0; j < methods.Len() && err == nil; j++ {
+				err = transcoder.addMethod(methods.Get(j))
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("transcoding: %w", err)
+	}
+	if len(transcoder.bindings) == 0 {
+		return nil, fmt.Errorf("transcoding: no google.api.http annotations found in %s", config.DescriptorSet)
+	}
+	return transcoder, nil
+}
+
+// addMethod adds the bindings of a method's google.api.http annotation, if it has one.
+func (t *Transcoder) addMethod(method protoreflect.MethodDescriptor) error {
+	rule, _ := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if rule == nil {
+		return nil
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("method %s: only unary methods can be transcoded", method.FullName())
+	}
+	for _, rule := range append([]*annotations.HttpRule{rule}, rule.AdditionalBindings...) {
+		binding, err := newHTTPBinding(method, rule)
+		if err != nil {
+			return fmt.Errorf("method %s: %w", method.FullName(), err)
+		}
+		t.bindings = append(t.bindings, binding)
+	}
+	return nil
+}
+
+func newHTTPBinding(method protoreflect.MethodDescriptor, rule *annotations.HttpRule) (*httpBinding, error) {
+	var httpMethod, path string
+	switch pattern := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		httpMethod, path = http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		httpMethod, path = http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		httpMethod, path = http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		httpMethod, path = http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		httpMethod, path = http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		httpMethod, path = pattern.Custom.Kind, pattern.Custom.Path
+	default:
+		return nil, fmt.Errorf("google.api.http annotation without a pattern")
+	}
+	if rule.ResponseBody != "" {
+		return nil, fmt.Errorf("response_body is not supported")
+	}
+
+	template, err := parsePathTemplate(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, variable := range template.variables {
+		if _, err := lookupField(method.Input(), variable); err != nil {
+			return nil, err
+		}
+	}
+	if rule.Body != "" && rule.Body != "*" {
+		if _, err := lookupField(method.Input(), rule.Body); err != nil {
+			return nil, err
+		}
+	}
+	return &httpBinding{
+		method:     httpMethod,
+		template:   template,
+		body:       rule.Body,
+		rpc:        method,
+		fullMethod: fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name()),
+	}, nil
+}
+
+// match returns the first binding for the method and path, with the path variables it captured.
+func (t *Transcoder) match(method, path string) (*httpBinding, map[string]string) {
+	for _, binding := range t.bindings {
+		if binding.method != method {
+			continue
+		}
+		if variables, ok := binding.template.match(path); ok {
+			return binding, variables
+		}
+	}
+	return nil, nil
+}
+
+// serveTranscoded calls the gRPC method bound to a REST request and answers with the result
+// as JSON. Paths are matched without prefix, the path of the route that led to the service.
+func (g *Gateway) serveTranscoded(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig, prefix string) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), strings.TrimSuffix(prefix, "/"))
+	if path == "" {
+		path = "/"
+	}
+	binding, variables := service.transcoder.match(r.Method, path)
+	if binding == nil {
+		writeTranscodingError(w, http.StatusNotFound, codes.NotFound, "no gRPC method bound to "+r.Method+" "+path)
+		return
+	}
+	message, err := binding.newRequest(r, variables)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, errTranscodedBodyTooLarge) {
+		writeTranscodingError(w, http.StatusRequestEntityTooLarge, codes.ResourceExhausted, err.Error())
+		return
+	}
+	if err != nil {
+		writeTranscodingError(w, http.StatusBadRequest, codes.InvalidArgument, err.Error())
+		return
+	}
+	payload, err := proto.Marshal(message)
+	if err != nil {
+		writeTranscodingError(w, http.StatusBadRequest, codes.InvalidArgument, err.Error())
+		return
+	}
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	req := r.Clone(r.Context())
+	req.Method = http.MethodPost
+	req.URL.Path, req.URL.RawPath, req.URL.RawQuery = binding.fullMethod, "", ""
+	req.Body = io.NopCloser(bytes.NewReader(frame))
+	req.ContentLength = int64(len(frame))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept-Encoding")
+
+	g.log.Sugar().Infof("Transcoding %s %s to %s", r.Method, path, binding.fullMethod)
+	resp, release, err := g.forward(req, service)
+	if err != nil {
+		g.forwardFailed(w, service.serviceName, err)
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTranscodedMessage+6))
+	if err != nil {
+		writeTranscodingError(w, http.StatusBadGateway, codes.Unavailable, "reading gRPC response: "+err.Error())
+		return
+	}
+	// Trailers-only responses carry the status in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	grpcMessage := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, grpcMessage = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		writeTranscodingError(w, http.StatusBadGateway, codes.Unknown, fmt.Sprintf("endpoint answered without a gRPC status (HTTP %d)", resp.StatusCode))
+		return
+	}
+	if codes.Code(code) != codes.OK {
+		if decoded, err := url.PathUnescape(grpcMessage); err == nil {
+			grpcMessage = decoded
+		}
+		writeTranscodingError(w, httpStatusFromCode(codes.Code(code)), codes.Code(code), grpcMessage)
+		return
+	}
+
+	reply := dynamicpb.NewMessage(binding.rpc.Output())
+	if err := unmarshalGRPCFrame(data, reply); err != nil {
+		writeTranscodingError(w, http.StatusBadGateway, codes.Internal, err.Error())
+		return
+	}
+	body, err := protojson.Marshal(reply)
+	if err != nil {
+		writeTranscodingError(w, http.StatusBadGateway, codes.Internal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	applyResponseRules(w, r, service)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// newRequest builds the gRPC request message from the HTTP body, path variables and query
+// parameters, in that order of precedence from lowest to highest.
+func (b *httpBinding) newRequest(r *http.Request, variables map[string]string) (proto.Message, error) {
+	message := dynamicpb.NewMessage(b.rpc.Input())
+	if b.body != "" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxTranscodedMessage+1))
+		if err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+		if len(data) > maxTranscodedMessage {
+			return nil, errTranscodedBodyTooLarge
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			// A body bound to a field is wrapped into the request message, which protojson
+			// can decode whatever the field's type
+			if b.body != "*" {
+				fields := strings.Split(b.body, ".")
+				for i := len(fields) - 1; i >= 0; i-- {
+					name, _ := json.Marshal(fields[i])
+					data = append(append(append([]byte("{"), name...), ':'), append(data, '}')...)
+				}
+			}
+			if err := protojson.Unmarshal(data, message); err != nil {
+				return nil, fmt.Errorf("decoding request body: %w", err)
+			}
+		}
+	}
+
+	if b.body != "*" {
+		for name, values := range r.URL.Query() {
+			if _, isVariable := variables[name]; isVariable || (b.body != "" && (name == b.body || strings.HasPrefix(name, b.body+"."))) {
+				continue
+			}
+			// Parameters that name no field are ignored, as grpc-gateway does
+			if _, err := lookupField(message.Descriptor(), name); err != nil {
+				continue
+			}
+			for _, value := range values {
+				if err := setField(message, name, value); err != nil {
+					return nil, fmt.Errorf("query parameter %s: %w", name, err)
+				}
+			}
+		}
+	}
+	for name, value := range variables {
+		if err := setField(message, name, value); err != nil {
+			return nil, fmt.Errorf("path variable %s: %w", name, err)
+		}
+	}
+	return message, nil
+}
+
+// lookupField resolves a dotted field path, by proto or JSON names, in a message type.
+func lookupField(message protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		field := message.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			field = message.Fields().ByJSONName(name)
+		}
+		if field == nil {
+			return nil, fmt.Errorf("field %s not found in %s", path, message.FullName())
+		}
+		if i == len(names)-1 {
+			return field, nil
+		}
+		if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+			return nil, fmt.Errorf("field %s: %s is not a message", path, name)
+		}
+		message = field.Message()
+	}
+	return nil, fmt.Errorf("empty field path")
+}
+
+// setField sets the field at a dotted path from its string form, appending to repeated fields.
+func setField(message protoreflect.Message, path string, value string) error {
+	field, err := lookupField(message.Descriptor(), path)
+	if err != nil {
+		return err
+	}
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		parent := message.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if parent == nil {
+			parent = message.Descriptor().Fields().ByJSONName(name)
+		}
+		message = message.Mutable(parent).Message()
+	}
+	if field.IsMap() {
+		return fmt.Errorf("map fields cannot be set from a string")
+	}
+	v, err := parseFieldValue(field, value)
+	if err != nil {
+		return err
+	}
+	if field.IsList() {
+		message.Mutable(field).List().Append(v)
+	} else {
+		message.Set(field, v)
+	}
+	return nil
+}
+
+// parseFieldValue parses the string form of a scalar or enum field.
+func parseFieldValue(field protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		n, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(n)), err
+	case protoreflect.DoubleKind:
+		n, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(n), err
+	case protoreflect.EnumKind:
+		if enum := field.Enum().Values().ByName(protoreflect.Name(value)); enum != nil {
+			return protoreflect.ValueOfEnum(enum.Number()), nil
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown %s value %q", field.Enum().FullName(), value)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("field %s of kind %s cannot be set from a string", field.Name(), field.Kind())
+	}
+}
+
+// unmarshalGRPCFrame decodes the single length-prefixed message of a unary gRPC response.
+func unmarshalGRPCFrame(data []byte, message proto.Message) error {
+	if len(data) < 5 {
+		return errors.New("gRPC response without a message")
+	}
+	if data[0] != 0 {
+		return errors.New("compressed gRPC responses are not supported")
+	}
+	length := binary.BigEndian.Uint32(data[1:5])
+	if length > maxTranscodedMessage || int(length) > len(data)-5 {
+		return fmt.Errorf("gRPC response message of %d bytes is truncated or too large", length)
+	}
+	return proto.Unmarshal(data[5:5+length], message)
+}
+
+// writeTranscodingError answers a transcoded request with a JSON google.rpc.Status.
+func writeTranscodingError(w http.ResponseWriter, status int, code codes.Code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{int(code), message})
+}
+
+// httpStatusFromCode maps a gRPC status code to the HTTP status of a transcoded response.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,269 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func field(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: kind.Enum(), Label: label.Enum()}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+func httpOptions(rule *annotations.HttpRule) *descriptorpb.MethodOptions {
+	options := &descriptorpb.MethodOptions{}
+	proto.SetExtension(options, annotations.E_Http, rule)
+	return options
+}
+
+// greeterDescriptor describes test.v1.Greeter with google.api.http annotations.
+func greeterDescriptor() *descriptorpb.FileDescriptorProto {
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/v1/greeter.proto"),
+		Package: proto.String("test.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Options"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("shout", 1, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+			}},
+			{Name: proto.String("HelloRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("times", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+				field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+				field("options", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".test.v1.Options"),
+			}},
+			{Name: proto.String("HelloReply"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("message", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name: proto.String("SayHello"), InputType: proto.String(".test.v1.HelloRequest"), OutputType: proto.String(".test.v1.HelloReply"),
+					Options: httpOptions(&annotations.HttpRule{
+						Pattern:            &annotations.HttpRule_Get{Get: "/v1/hello/{name}"},
+						AdditionalBindings: []*annotations.HttpRule{{Pattern: &annotations.HttpRule_Post{Post: "/v1/hello"}, Body: "*"}},
+					}),
+				},
+				{
+					Name: proto.String("UpdateGreeting"), InputType: proto.String(".test.v1.HelloRequest"), OutputType: proto.String(".test.v1.HelloReply"),
+					Options: httpOptions(&annotations.HttpRule{Pattern: &annotations.HttpRule_Patch{Patch: "/v1/greetings/{name}"}, Body: "options"}),
+				},
+				{
+					Name: proto.String("Internal"), InputType: proto.String(".test.v1.HelloRequest"), OutputType: proto.String(".test.v1.HelloReply"),
+				},
+			},
+		}},
+	}
+}
+
+func writeDescriptorSet(t *testing.T, files ...*descriptorpb.FileDescriptorProto) string {
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: files})
+	if err != nil {
+		t.Fatalf("Failed to marshal descriptor set: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "descriptors.pb")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write descriptor set: %v", err)
+	}
+	return path
+}
+
+// startGreeterServer serves test.v1.Greeter, describing each request in the reply.
+func startGreeterServer(t *testing.T) string {
+	file, err := protodesc.NewFile(greeterDescriptor(), nil)
+	if err != nil {
+		t.Fatalf("Failed to build descriptor: %v", err)
+	}
+	input := file.Messages().ByName("HelloRequest")
+	output := file.Messages().ByName("HelloReply")
+
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		req := dynamicpb.NewMessage(input)
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		name := req.Get(input.Fields().ByName("name")).String()
+		if name == "missing" {
+			return status.Error(codes.NotFound, "no greeting for missing")
+		}
+		var tags []string
+		list := req.Get(input.Fields().ByName("tags")).List()
+		for i := 0; i < list.Len(); i++ {
+			tags = append(tags, list.Get(i).String())
+		}
+		options := req.Get(input.Fields().ByName("options")).Message()
+		shout := options.Get(options.Descriptor().Fields().ByName("shout")).Bool()
+
+		reply := dynamicpb.NewMessage(output)
+		reply.Set(output.Fields().ByName("message"), protoreflect.ValueOfString(fmt.Sprintf("%s %s x%d %v shout=%v",
+			method, name, req.Get(input.Fields().ByName("times")).Int(), tags, shout)))
+		return stream.SendMsg(reply)
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return "http://" + listener.Addr().String()
+}
+
+func createTranscodingGateway(t *testing.T) (*httptest.Server, *Gateway) {
+	transcoder, err := NewTranscoder(&TranscodingConfig{DescriptorSet: writeDescriptorSet(t, greeterDescriptor())})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client, _ := newUpstreamClient("h2c")
+	endpoint := startGreeterServer(t)
+
+	g := createTestGateway("")
+	g.serviceRegistry["greeter"] = &GatewayServiceConfig{
+		serviceName:      "greeter",
+		loadBalancerType: &MockLoadBalancer{endpoints: []string{endpoint}},
+		endpoints:        []string{endpoint},
+		client:           client,
+		transcoder:       transcoder,
+	}
+	server := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	t.Cleanup(server.Close)
+	return server, g
+}
+
+func TestRouteHandler_Transcoding(t *testing.T) {
+	gateway, _ := createTranscodingGateway(t)
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		expected           string
+	}{
+		{"GET", "/greeter/v1/hello/world%20wide?times=3&tags=a&tags=b&unknown=1", "", http.StatusOK,
+			`/test.v1.Greeter/SayHello world wide x3 [a b] shout=false`},
+		{"POST", "/greeter/v1/hello", `{"name":"bob","options":{"shout":true}}`, http.StatusOK,
+			`/test.v1.Greeter/SayHello bob x0 [] shout=true`},
+		{"PATCH", "/greeter/v1/greetings/ann?times=2", `{"shout":true}`, http.StatusOK,
+			`/test.v1.Greeter/UpdateGreeting ann x2 [] shout=true`},
+		{"GET", "/greeter/v1/hello/missing", "", http.StatusNotFound, "no greeting for missing"},
+		{"DELETE", "/greeter/v1/hello/world", "", http.StatusNotFound, "no gRPC method bound to DELETE /v1/hello/world"},
+		{"GET", "/greeter/v1/hello/world?times=many", "", http.StatusBadRequest, "query parameter times"},
+		{"POST", "/greeter/v1/hello", `{"name":`, http.StatusBadRequest, "decoding request body"},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, gateway.URL+c.path, strings.NewReader(c.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		if resp.StatusCode != c.status {
+			t.Errorf("Expected status %d for %s %s, got %d (%v)", c.status, c.method, c.path, resp.StatusCode, body)
+			continue
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Expected a JSON response for %s %s, got %q", c.method, c.path, got)
+		}
+		text, _ := body["message"].(string)
+		if c.status == http.StatusOK && text != c.expected || c.status != http.StatusOK && !strings.Contains(text, c.expected) {
+			t.Errorf("Expected message %q for %s %s, got %v", c.expected, c.method, c.path, body)
+		}
+	}
+}
+
+func TestRouteHandler_TranscodingRoutes(t *testing.T) {
+	gateway, g := createTranscodingGateway(t)
+	split, _ := NewTrafficSplit(&SplitConfig{Services: []SplitServiceConfig{{Service: "greeter", Weight: 1}}})
+	g.serviceRegistry["greeter-next"] = &GatewayServiceConfig{serviceName: "greeter-next", split: split}
+	route, _ := NewRoute(&RouteConfig{Service: "greeter", Match: MatchConfig{PathPrefix: "/api/greeter/"}})
+	g.routes = []*Route{route}
+	limits, _ := NewRequestLimits(&LimitsConfig{MaxRequestBodySize: 8})
+	g.serviceRegistry["greeter"].limits = limits
+
+	cases := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/greeter-next/v1/hello/world", "", http.StatusOK},
+		{"GET", "/api/greeter/v1/hello/world", "", http.StatusOK},
+		{"POST", "/greeter/v1/hello", `{"name":"a long name"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, gateway.URL+c.path, strings.NewReader(c.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("Expected status %d for %s %s, got %d %s", c.status, c.method, c.path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestNewTranscoder_Invalid(t *testing.T) {
+	if _, err := NewTranscoder(&TranscodingConfig{DescriptorSet: "missing.pb"}); err == nil {
+		t.Errorf("Expected an error for a missing descriptor set")
+	}
+
+	path := writeDescriptorSet(t, greeterDescriptor())
+	if _, err := NewTranscoder(&TranscodingConfig{DescriptorSet: path, Services: []string{"test.v1.Other"}}); err == nil {
+		t.Errorf("Expected an error when no listed service has annotations")
+	}
+
+	file := greeterDescriptor()
+	file.Service[0].Method[0].Options = httpOptions(&annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/hello/{nickname}"}})
+	if _, err := NewTranscoder(&TranscodingConfig{DescriptorSet: writeDescriptorSet(t, file)}); err == nil {
+		t.Errorf("Expected an error for a path variable naming no field")
+	}
+
+	file = greeterDescriptor()
+	file.Service[0].Method[0].ServerStreaming = proto.Bool(true)
+	if _, err := NewTranscoder(&TranscodingConfig{DescriptorSet: writeDescriptorSet(t, file)}); err == nil {
+		t.Errorf("Expected an error for a streaming method")
+	}
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	cases := map[codes.Code]int{
+		codes.OK:               http.StatusOK,
+		codes.InvalidArgument:  http.StatusBadRequest,
+		codes.NotFound:         http.StatusNotFound,
+		codes.Unauthenticated:  http.StatusUnauthorized,
+		codes.Unavailable:      http.StatusServiceUnavailable,
+		codes.DeadlineExceeded: http.StatusGatewayTimeout,
+		codes.DataLoss:         http.StatusInternalServerError,
+	}
+	for code, expected := range cases {
+		if got := httpStatusFromCode(code); got != expected {
+			t.Errorf("Expected %d for %v, got %d", expected, code, got)
+		}
+	}
+}
@@ -1,24 +1,488 @@
 package gateway
 
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
 // LoadBalancer interface defines the methods that a load balancer should implement.
 type LoadBalancer interface {
 	NextEndpoint() string
 }
 
+// EndpointReleaser is implemented by load balancers that track requests in flight per endpoint.
+// ReleaseEndpoint is called once a request to an endpoint returned by NextEndpoint completes.
+type EndpointReleaser interface {
+	ReleaseEndpoint(endpoint string)
+}
+
+// KeyedLoadBalancer is implemented by load balancers that pick endpoints by a key, such as
+// the client address, so that requests with the same key reach the same endpoint.
+type KeyedLoadBalancer interface {
+	EndpointFor(key string) string
+}
+
+// RateLimitStore interface defines the methods that a rate limit store should implement.
+// Stores shared between gateway replicas make every replica count against the same quota.
+type RateLimitStore interface {
+	// Increment records a hit for key in the current window of the given length and
+	// returns the number of hits in that window and the time left until it resets.
+	Increment(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+}
+
+// CacheStore interface defines the methods that a response cache store should implement.
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string) bool
+	// Purge removes all entries whose key starts with prefix and returns how many were removed.
+	Purge(prefix string) int
+}
+
+// Authenticator interface defines the methods that an authentication backend should implement.
+type Authenticator interface {
+	// Authenticate verifies the credentials on the request and returns the caller's identity.
+	Authenticate(r *http.Request) (*Identity, error)
+	// Challenge returns the WWW-Authenticate header value sent with a 401 response.
+	Challenge() string
+}
+
 // Config represents the configuration for the gateway.
 type Config struct {
-	Services map[string]ServiceConfig `yaml:"services"`
+	RateLimitStore RateLimitStoreConfig         `yaml:"rateLimitStore"`
+	CacheStore     CacheStoreConfig             `yaml:"cacheStore"`
+	Admin          *AdminConfig                 `yaml:"admin"`
+	ProxyProtocol  *ProxyProtocolConfig         `yaml:"proxyProtocol"`
+	TLS            *TLSConfig                   `yaml:"tls"`
+	H2C            bool                         `yaml:"h2c"`
+	HTTP3          *HTTP3Config                 `yaml:"http3"`
+	Server         *ServerConfig                `yaml:"server"`
+	TCP            map[string]TCPListenerConfig `yaml:"tcp"`
+	UDP            map[string]UDPListenerConfig `yaml:"udp"`
+	TrustedProxies []string                     `yaml:"trustedProxies"`
+	IPFilter       *IPFilterConfig              `yaml:"ipFilter"`
+	SecretsFile    string                       `yaml:"secretsFile"`
+	Consumers      []ConsumerConfig             `yaml:"consumers"`
+	Services       map[string]ServiceConfig     `yaml:"services"`
+	// Routes send requests meeting their match conditions to a service, ahead of routing by
+	// the first path segment.
+	Routes []RouteConfig `yaml:"routes"`
 }
 
-// ServiceConfig represents the configuration for a service.
-type ServiceConfig struct {
+// RouteConfig represents the routing of requests meeting every condition of match to a
+// service. Routes are tried by descending priority, in configured order for equal priorities;
+// requests matching none are routed by their first path segment.
+type RouteConfig struct {
+	Service  string      `yaml:"service"`
+	Priority int         `yaml:"priority"`
+	Match    MatchConfig `yaml:"match"`
+}
+
+// MatchConfig represents the conditions a request must meet; unset conditions match any
+// request. Host may start with a "*." wildcard for one level of subdomains.
+type MatchConfig struct {
+	PathPrefix string             `yaml:"pathPrefix"`
+	Methods    []string           `yaml:"methods"`
+	Host       string             `yaml:"host"`
+	Headers    []ValueMatchConfig `yaml:"headers"`
+	Query      []ValueMatchConfig `yaml:"query"`
+}
+
+// ValueMatchConfig represents a condition on a header or query parameter: an exact value, a
+// regex the whole value must match, or its presence (true) or absence (false).
+type ValueMatchConfig struct {
+	Name    string `yaml:"name"`
+	Exact   string `yaml:"exact"`
+	Regex   string `yaml:"regex"`
+	Present *bool  `yaml:"present"`
+}
+
+// ProxyProtocolConfig represents PROXY protocol handling on the gateway's listener.
+type ProxyProtocolConfig struct {
+	TrustedSources    []string      `yaml:"trustedSources"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+}
+
+// TLSConfig represents the certificate the gateway's listener serves. TLS listeners
+// negotiate HTTP/2 with ALPN.
+type TLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// HTTP3Config represents the gateway's optional HTTP/3 (QUIC) listener, which serves the
+// listener's TLS certificate. AdvertisedPort overrides the UDP port announced in Alt-Svc
+// for clients that reach the gateway on a different port.
+type HTTP3Config struct {
+	Address        string `yaml:"address"`
+	AdvertisedPort int    `yaml:"advertisedPort"`
+}
+
+// ServerConfig represents the limits of the gateway's HTTP listener. Clients must send their
+// request headers within readHeaderTimeout (10s by default). Read when the gateway starts.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+}
+
+// TCPListenerConfig represents a layer 4 listener forwarding byte streams to endpoints given
+// as host:port. With sni routes, TLS connections are routed by the server name in their
+// ClientHello without being terminated, falling back to endpoints when no route matches.
+// The address is only read when the gateway starts.
+type TCPListenerConfig struct {
+	Address        string                    `yaml:"address"`
+	Endpoints      []string                  `yaml:"endpoints"`
+	LoadBalancer   string                    `yaml:"loadBalancer"`
+	SNI            map[string]TCPRouteConfig `yaml:"sni"`
+	MaxConnections int                       `yaml:"maxConnections"`
+	IdleTimeout    time.Duration             `yaml:"idleTimeout"`
+}
+
+// TCPRouteConfig represents the endpoints of a server name on a TLS passthrough listener.
+// Server names may start with a "*." wildcard.
+type TCPRouteConfig struct {
 	Endpoints    []string `yaml:"endpoints"`
 	LoadBalancer string   `yaml:"loadBalancer"`
 }
 
+// UDPListenerConfig represents a listener forwarding datagrams to endpoints given as host:port.
+// Each client address keeps its endpoint for the session, which ends once no datagram flows
+// in either direction for sessionTimeout (30s by default). With the consistent-hash load
+// balancer, clients keep their endpoint across sessions too.
+// The address is only read when the gateway starts.
+type UDPListenerConfig struct {
+	Address        string        `yaml:"address"`
+	Endpoints      []string      `yaml:"endpoints"`
+	LoadBalancer   string        `yaml:"loadBalancer"`
+	SessionTimeout time.Duration `yaml:"sessionTimeout"`
+	MaxSessions    int           `yaml:"maxSessions"`
+}
+
+// CORSConfig represents the cross-origin requests allowed for a service.
+type CORSConfig struct {
+	AllowedOrigins        []string      `yaml:"allowedOrigins"`
+	AllowedOriginPatterns []string      `yaml:"allowedOriginPatterns"`
+	AllowedMethods        []string      `yaml:"allowedMethods"`
+	AllowedHeaders        []string      `yaml:"allowedHeaders"`
+	ExposedHeaders        []string      `yaml:"exposedHeaders"`
+	AllowCredentials      bool          `yaml:"allowCredentials"`
+	MaxAge                time.Duration `yaml:"maxAge"`
+}
+
+// HeadersConfig represents the header rules applied to a service's requests and responses.
+// Response rules also apply to the errors the gateway writes for the service.
+type HeadersConfig struct {
+	Request  *HeaderRulesConfig `yaml:"request"`
+	Response *HeaderRulesConfig `yaml:"response"`
+}
+
+// HeaderRulesConfig represents headers to remove, set or add, in that order.
+// Values may use ${client_ip}, ${request_id}, ${service}, ${method}, ${path}, ${query},
+// ${header:<name>}, ${claim:<name>} and ${env:<name>}.
+type HeaderRulesConfig struct {
+	Add    map[string]string `yaml:"add"`
+	Set    map[string]string `yaml:"set"`
+	Remove []string          `yaml:"remove"`
+}
+
+// CompressionConfig represents the compression of a service's responses.
+// Algorithms are listed in order of preference; content types ending in "/" match any subtype.
+type CompressionConfig struct {
+	Algorithms   []string `yaml:"algorithms"`
+	MinSize      int64    `yaml:"minSize"`
+	ContentTypes []string `yaml:"contentTypes"`
+}
+
+// CacheStoreConfig represents the store shared by the response caches of all services.
+type CacheStoreConfig struct {
+	Type     string `yaml:"type"`
+	MaxBytes int64  `yaml:"maxBytes"`
+	Dir      string `yaml:"dir"`
+}
+
+// CacheConfig represents the response caching of a service.
+// TTL, when set, overrides the freshness lifetime given by the upstream.
+type CacheConfig struct {
+	TTL                  time.Duration `yaml:"ttl"`
+	StaleWhileRevalidate time.Duration `yaml:"staleWhileRevalidate"`
+	MaxEntrySize         int64         `yaml:"maxEntrySize"`
+}
+
+// CoalesceConfig represents the collapsing of identical concurrent GET requests to a service.
+// Requests rendering the same key template and accepting the same encodings share one upstream
+// request. The default key is "${path}?${query}", and requests with credentials or cookies are
+// not coalesced under it; a configured key must include the caller when responses depend on it.
+// Responses varying on headers other than Accept-Encoding are not shared.
+type CoalesceConfig struct {
+	Key             string `yaml:"key"`
+	MaxResponseSize int64  `yaml:"maxResponseSize"`
+}
+
+// SplitConfig represents the splitting of a route's requests across other services by weight.
+// The route's own access controls and rate limits apply before splitting, and the chosen
+// service forwards the request with its endpoints and settings. A request naming one of the
+// services in the override header or cookie is sent to it. With a sticky key template, such
+// as "${claim:sub}", each user keeps their service while the weights stay the same; shifting
+// weight between two neighbouring services only moves users between those two.
+type SplitConfig struct {
+	Services []SplitServiceConfig `yaml:"services"`
+	Header   string               `yaml:"header"`
+	Cookie   string               `yaml:"cookie"`
+	Sticky   string               `yaml:"sticky"`
+}
+
+// SplitServiceConfig represents a service's share of a split route.
+type SplitServiceConfig struct {
+	Service string `yaml:"service"`
+	Weight  int    `yaml:"weight"`
+}
+
+// LimitsConfig represents the bounds on a service's requests; zero values are unlimited.
+// Request bodies over maxRequestBodySize are answered with 413, responses over
+// maxResponseSize with 502 or cut short once streaming, and requests not served within
+// timeout with 504. idleTimeout aborts requests whose request or response body sends no data
+// for that long. Upgrade tunnels use the upgrade timeouts instead.
+type LimitsConfig struct {
+	MaxRequestBodySize int64         `yaml:"maxRequestBodySize"`
+	MaxResponseSize    int64         `yaml:"maxResponseSize"`
+	Timeout            time.Duration `yaml:"timeout"`
+	IdleTimeout        time.Duration `yaml:"idleTimeout"`
+}
+
+// MirrorConfig represents the shadowing of a percentage of a service's requests to another
+// service. Mirrored requests are sent once the primary response is complete and their
+// responses are discarded; bodies over maxBodySize (1MiB by default) are not mirrored.
+type MirrorConfig struct {
+	Service     string        `yaml:"service"`
+	Percentage  float64       `yaml:"percentage"`
+	MaxBodySize int64         `yaml:"maxBodySize"`
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxInFlight int           `yaml:"maxInFlight"`
+}
+
+// UpgradeConfig represents the tunnelling of WebSocket and other HTTP Upgrade connections to a service.
+// Zero timeouts leave connections open until either side closes them.
+type UpgradeConfig struct {
+	Protocols   []string      `yaml:"protocols"`
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	MaxDuration time.Duration `yaml:"maxDuration"`
+}
+
+// StreamingConfig represents how a service's responses are flushed to the client.
+// Server-Sent Events (text/event-stream) are always flushed immediately.
+type StreamingConfig struct {
+	FlushInterval    time.Duration `yaml:"flushInterval"`
+	FlushImmediately bool          `yaml:"flushImmediately"`
+}
+
+// TranscodingConfig represents the REST/JSON to gRPC transcoding of a service, driven by the
+// google.api.http annotations in a descriptor set. When services are listed, only their
+// methods are transcoded.
+type TranscodingConfig struct {
+	DescriptorSet string   `yaml:"descriptorSet"`
+	Services      []string `yaml:"services"`
+}
+
+// AdminConfig represents the gateway's administrative API.
+type AdminConfig struct {
+	Address string `yaml:"address"`
+}
+
+// IPFilterConfig represents the client networks allowed or denied access.
+type IPFilterConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// SecretsConfig represents the contents of the separate secrets file.
+type SecretsConfig struct {
+	Consumers []ConsumerConfig `yaml:"consumers"`
+}
+
+// ConsumerConfig represents a known caller and its hashed credentials.
+type ConsumerConfig struct {
+	Name     string   `yaml:"name"`
+	Groups   []string `yaml:"groups"`
+	APIKeys  []string `yaml:"apiKeys"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+// RateLimitStoreConfig represents the configuration for the store backing rate limits.
+type RateLimitStoreConfig struct {
+	Type     string `yaml:"type"`
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Prefix   string `yaml:"prefix"`
+}
+
+// ServiceConfig represents the configuration for a service.
+type ServiceConfig struct {
+	Endpoints     []string             `yaml:"endpoints"`
+	LoadBalancer  string               `yaml:"loadBalancer"`
+	RateLimit     *RateLimitConfig     `yaml:"rateLimit"`
+	Concurrency   *ConcurrencyConfig   `yaml:"concurrency"`
+	Auth          *AuthConfig          `yaml:"auth"`
+	ExtAuthz      *ExtAuthzConfig      `yaml:"extAuthz"`
+	OIDC          *OIDCConfig          `yaml:"oidc"`
+	Authorization *AuthorizationConfig `yaml:"authorization"`
+	IPFilter      *IPFilterConfig      `yaml:"ipFilter"`
+	CORS          *CORSConfig          `yaml:"cors"`
+	Headers       *HeadersConfig       `yaml:"headers"`
+	Compression   *CompressionConfig   `yaml:"compression"`
+	Cache         *CacheConfig         `yaml:"cache"`
+	Coalesce      *CoalesceConfig      `yaml:"coalesce"`
+	Upgrade       *UpgradeConfig       `yaml:"upgrade"`
+	Streaming     *StreamingConfig     `yaml:"streaming"`
+	Mirror        *MirrorConfig        `yaml:"mirror"`
+	Split         *SplitConfig         `yaml:"split"`
+	Limits        *LimitsConfig        `yaml:"limits"`
+	// ProxyProtocol is the PROXY protocol version ("v1" or "v2") sent to the endpoints, if any.
+	ProxyProtocol string `yaml:"proxyProtocol"`
+	// Protocol is the protocol spoken to the endpoints: http1 (the default), h2 or h2c.
+	Protocol string `yaml:"protocol"`
+	// GRPCServices are the gRPC services ("package.Service") or methods
+	// ("package.Service/Method") routed to this service.
+	GRPCServices []string `yaml:"grpcServices"`
+	// GRPCWeb translates gRPC-Web calls from browsers into gRPC calls to the endpoints.
+	GRPCWeb     bool               `yaml:"grpcWeb"`
+	Transcoding *TranscodingConfig `yaml:"transcoding"`
+}
+
+// RateLimitConfig represents the rate limit configuration for a service.
+type RateLimitConfig struct {
+	Requests int64         `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+}
+
+// ConcurrencyConfig represents the in-flight request limits for a service and its endpoints.
+type ConcurrencyConfig struct {
+	MaxInFlight         int             `yaml:"maxInFlight"`
+	MaxEndpointInFlight int             `yaml:"maxEndpointInFlight"`
+	QueueSize           int             `yaml:"queueSize"`
+	QueueTimeout        time.Duration   `yaml:"queueTimeout"`
+	Adaptive            *AdaptiveConfig `yaml:"adaptive"`
+}
+
+// AdaptiveConfig represents the bounds and tuning of an adaptive concurrency limit.
+type AdaptiveConfig struct {
+	MinLimit         int           `yaml:"minLimit"`
+	MaxLimit         int           `yaml:"maxLimit"`
+	LatencyThreshold time.Duration `yaml:"latencyThreshold"`
+	Backoff          float64       `yaml:"backoff"`
+}
+
+// AuthConfig represents the authentication configuration for a service.
+// A request is accepted if any of the configured methods authenticates it.
+type AuthConfig struct {
+	JWT    *JWTConfig       `yaml:"jwt"`
+	APIKey *APIKeyConfig    `yaml:"apiKey"`
+	Basic  *BasicAuthConfig `yaml:"basic"`
+}
+
+// JWTConfig represents the JWT validation settings for a service.
+// Keys come from Secret for HMAC algorithms, or from a JWKS URL or file otherwise.
+type JWTConfig struct {
+	Issuer       string            `yaml:"issuer"`
+	Audiences    []string          `yaml:"audiences"`
+	Algorithms   []string          `yaml:"algorithms"`
+	ClockSkew    time.Duration     `yaml:"clockSkew"`
+	Secret       string            `yaml:"secret"`
+	JWKSURL      string            `yaml:"jwksURL"`
+	JWKSFile     string            `yaml:"jwksFile"`
+	JWKSRefresh  time.Duration     `yaml:"jwksRefresh"`
+	ClaimHeaders map[string]string `yaml:"claimHeaders"`
+}
+
+// APIKeyConfig represents the API key settings for a service.
+// Consumers restricts the service to the named consumers when set.
+type APIKeyConfig struct {
+	Header     string   `yaml:"header"`
+	QueryParam string   `yaml:"queryParam"`
+	Consumers  []string `yaml:"consumers"`
+}
+
+// BasicAuthConfig represents the HTTP Basic authentication settings for a service.
+// Consumers restricts the service to the named consumers when set.
+type BasicAuthConfig struct {
+	Realm     string   `yaml:"realm"`
+	Consumers []string `yaml:"consumers"`
+}
+
+// ExtAuthzConfig represents the external authorization service consulted before proxying.
+// FailureMode is "closed" (the default) to reject requests when the service is unavailable,
+// or "open" to let them through.
+type ExtAuthzConfig struct {
+	URL             string        `yaml:"url"`
+	Timeout         time.Duration `yaml:"timeout"`
+	FailureMode     string        `yaml:"failureMode"`
+	ForwardHeaders  []string      `yaml:"forwardHeaders"`
+	UpstreamHeaders []string      `yaml:"upstreamHeaders"`
+	CacheTTL        time.Duration `yaml:"cacheTTL"`
+}
+
+// OIDCConfig represents the browser login flow for a service.
+// RedirectURL is the externally visible callback URL and must route to the same service,
+// e.g. https://gateway.example.com/serviceA/oauth2/callback.
+type OIDCConfig struct {
+	Issuer             string            `yaml:"issuer"`
+	ClientID           string            `yaml:"clientID"`
+	ClientSecret       string            `yaml:"clientSecret"`
+	RedirectURL        string            `yaml:"redirectURL"`
+	Scopes             []string          `yaml:"scopes"`
+	CookieName         string            `yaml:"cookieName"`
+	CookieSecret       string            `yaml:"cookieSecret"`
+	SessionTTL         time.Duration     `yaml:"sessionTTL"`
+	ClaimHeaders       map[string]string `yaml:"claimHeaders"`
+	ForwardAccessToken bool              `yaml:"forwardAccessToken"`
+}
+
+// AuthorizationConfig represents the access rules for a service, evaluated in order.
+// In dry-run mode denials are logged but not enforced.
+type AuthorizationConfig struct {
+	DryRun        bool         `yaml:"dryRun"`
+	DefaultAction string       `yaml:"defaultAction"`
+	Rules         []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule represents a single allow or deny rule. A rule matches when all of its
+// conditions hold; an empty header value list only requires the header to be present.
+type PolicyRule struct {
+	Name      string              `yaml:"name"`
+	Action    string              `yaml:"action"`
+	Methods   []string            `yaml:"methods"`
+	Consumers []string            `yaml:"consumers"`
+	Groups    []string            `yaml:"groups"`
+	Claims    map[string][]string `yaml:"claims"`
+	Headers   map[string][]string `yaml:"headers"`
+	CIDRs     []string            `yaml:"cidrs"`
+}
+
 // GatewayServiceConfig represents the configuration for a service in the gateway.
 type GatewayServiceConfig struct {
 	serviceName      string
 	loadBalancerType LoadBalancer
 	endpoints        []string
+	rateLimiter      *RateLimiter
+	concurrency      *ConcurrencyLimiter
+	endpointLimits   map[string]*ConcurrencyLimiter
+	authenticator    Authenticator
+	extAuthz         *ExtAuthzClient
+	oidc             *OIDCHandler
+	policy           *AuthorizationPolicy
+	ipFilter         *IPFilter
+	client           *http.Client
+	cors             *CORSPolicy
+	headers          *HeaderRules
+	compressor       *Compressor
+	cache            *ResponseCache
+	coalescer        *Coalescer
+	upgrade          *UpgradeProxy
+	streaming        *StreamingConfig
+	grpcWeb          bool
+	transcoder       *Transcoder
+	mirror           *Mirror
+	split            *TrafficSplit
+	limits           *RequestLimits
 }
@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	// defaultUDPSessionTimeout ends client sessions without datagrams in either direction
+	defaultUDPSessionTimeout = 30 * time.Second
+	// maxDatagramSize fits any UDP payload
+	maxDatagramSize = 64 << 10
+)
+
+type UDPProxy struct {
+	name    string
+	address string
+	log     *log.Logger
+
+	mux    sync.RWMutex
+	routes *udpRoutes
+
+	sessionsMux sync.Mutex
+	sessions    map[string]*udpSession
+}
+
+// udpRoutes holds the reloadable part of a UDP listener's configuration.
+type udpRoutes struct {
+	lb             LoadBalancer
+	sessionTimeout time.Duration
+	maxSessions    int
+}
+
+// udpSession ties a client address to the endpoint its datagrams are forwarded to. Replies
+// from the endpoint are sent back to the client from the listener's address.
+type udpSession struct {
+	client   net.Addr
+	endpoint string
+	backend  net.Conn
+	// lastActive is the Unix time in nanoseconds of the last datagram in either direction
+	lastActive atomic.Int64
+}
+
+// NewUDPProxy initializes a UDPProxy for the named listener from config.
+func NewUDPProxy(name string, config *UDPListenerConfig, log *log.Logger) (*UDPProxy, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("udp %s: address is required", name)
+	}
+	lb, err := newHostPortLoadBalancer(config.LoadBalancer, config.Endpoints, log)
+	if err != nil {
+		return nil, fmt.Errorf("udp %s: %w", name, err)
+	}
+	routes := &udpRoutes{lb: lb, sessionTimeout: config.SessionTimeout, maxSessions: config.MaxSessions}
+	if routes.sessionTimeout <= 0 {
+		routes.sessionTimeout = defaultUDPSessionTimeout
+	}
+	return &UDPProxy{name: name, address: config.Address, routes: routes, sessions: make(map[string]*udpSession), log: log}, nil
+}
+
+// reload takes over the endpoints, limits and timeouts of a proxy built from a newer config.
+// Open sessions keep their endpoint; the listening address only changes on restart.
+func (p *UDPProxy) reload(from *UDPProxy) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.routes = from.routes
+}
+
+// Serve reads datagrams from listener and forwards each one to the endpoint of its client's
+// session until the listener is closed.
+func (p *UDPProxy) Serve(listener net.PacketConn) error {
+	defer p.closeSessions()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := listener.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		session, err := p.session(listener, client)
+		if err != nil {
+			p.log.Sugar().Infof("UDP listener %s: dropping datagram from %s: %v", p.name, client, err)
+			continue
+		}
+		session.lastActive.Store(time.Now().UnixNano())
+		if _, err := session.backend.Write(buf[:n]); err != nil {
+			p.log.Sugar().Infof("UDP listener %s: forwarding to %s: %v", p.name, session.endpoint, err)
+		}
+	}
+}
+
+// session returns the session of client, starting one with an endpoint chosen by the load
+// balancer when the client has none.
+func (p *UDPProxy) session(listener net.PacketConn, client net.Addr) (*udpSession, error) {
+	p.sessionsMux.Lock()
+	defer p.sessionsMux.Unlock()
+	if session, ok := p.sessions[client.String()]; ok {
+		return session, nil
+	}
+
+	p.mux.RLock()
+	routes := p.routes
+	p.mux.RUnlock()
+	if routes.maxSessions > 0 && len(p.sessions) >= routes.maxSessions {
+		return nil, fmt.Errorf("%d sessions open", routes.maxSessions)
+	}
+	endpoint := nextEndpoint(routes.lb, stripPort(client.String()))
+	release := func() {
+		if releaser, ok := routes.lb.(EndpointReleaser); ok {
+			releaser.ReleaseEndpoint(endpoint)
+		}
+	}
+	if endpoint == "" {
+		release()
+		return nil, errors.New("no endpoints available")
+	}
+	backend, err := net.Dial("udp", endpoint)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	session := &udpSession{client: client, endpoint: endpoint, backend: backend}
+	session.lastActive.Store(time.Now().UnixNano())
+	p.sessions[client.String()] = session
+	p.log.Sugar().Infof("UDP listener %s: started session from %s to %s", p.name, client, endpoint)
+	go func() {
+		defer release()
+		p.reply(listener, session, routes.sessionTimeout)
+	}()
+	return session, nil
+}
+
+// reply sends datagrams from the endpoint of session back to its client until the session
+// has seen no datagram in either direction for timeout, then ends the session.
+func (p *UDPProxy) reply(listener net.PacketConn, session *udpSession, timeout time.Duration) {
+	defer p.endSession(session)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		idleSince := time.Unix(0, session.lastActive.Load())
+		if time.Since(idleSince) >= timeout {
+			return
+		}
+		session.backend.SetReadDeadline(idleSince.Add(timeout))
+		n, err := session.backend.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			// The endpoint is unreachable, closed, or the listener is shutting down
+			return
+		}
+		session.lastActive.Store(time.Now().UnixNano())
+		if _, err := listener.WriteTo(buf[:n], session.client); err != nil {
+			p.log.Sugar().Infof("UDP listener %s: replying to %s: %v", p.name, session.client, err)
+			return
+		}
+	}
+}
+
+// endSession closes a session so the client's next datagram starts a new one.
+func (p *UDPProxy) endSession(session *udpSession) {
+	p.sessionsMux.Lock()
+	if p.sessions[session.client.String()] == session {
+		delete(p.sessions, session.client.String())
+	}
+	p.sessionsMux.Unlock()
+	session.backend.Close()
+	p.log.Sugar().Infof("UDP listener %s: ended session from %s to %s", p.name, session.client, session.endpoint)
+}
+
+// closeSessions closes the endpoint connections of all sessions, ending them.
+func (p *UDPProxy) closeSessions() {
+	p.sessionsMux.Lock()
+	defer p.sessionsMux.Unlock()
+	for _, session := range p.sessions {
+		session.backend.Close()
+	}
+}
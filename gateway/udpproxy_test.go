@@ -0,0 +1,224 @@
+package gateway
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startUDPEchoServer answers each datagram with its name and the datagram.
+func startUDPEchoServer(t *testing.T, name string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte(name+":"+string(buf[:n])), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func startUDPProxy(t *testing.T, config *UDPListenerConfig) (*UDPProxy, string) {
+	logger, _ := zap.NewProductionConfig().Build()
+	config.Address = "127.0.0.1:0"
+	proxy, err := NewUDPProxy("test", config, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	listener, err := net.ListenPacket("udp", config.Address)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go proxy.Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+	return proxy, listener.LocalAddr().String()
+}
+
+// exchange sends a datagram on conn and returns the reply.
+func exchange(t *testing.T, conn net.Conn, message string) string {
+	conn.Write([]byte(message))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxDatagramSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestUDPProxy_SessionAffinity(t *testing.T) {
+	first := startUDPEchoServer(t, "first")
+	second := startUDPEchoServer(t, "second")
+	_, address := startUDPProxy(t, &UDPListenerConfig{Endpoints: []string{first, second}, LoadBalancer: "round-robin"})
+
+	clientA, _ := net.Dial("udp", address)
+	defer clientA.Close()
+	clientB, _ := net.Dial("udp", address)
+	defer clientB.Close()
+
+	if got := exchange(t, clientA, "query"); got != "first:query" {
+		t.Errorf("Expected first:query, got %q", got)
+	}
+	if got := exchange(t, clientB, "query"); got != "second:query" {
+		t.Errorf("Expected second:query, got %q", got)
+	}
+	for i := 0; i < 3; i++ {
+		if got := exchange(t, clientA, "again"); got != "first:again" {
+			t.Errorf("Expected the client to keep its endpoint, got %q", got)
+		}
+	}
+}
+
+func TestUDPProxy_SessionTimeout(t *testing.T) {
+	first := startUDPEchoServer(t, "first")
+	second := startUDPEchoServer(t, "second")
+	proxy, address := startUDPProxy(t, &UDPListenerConfig{Endpoints: []string{first, second}, LoadBalancer: "round-robin", SessionTimeout: 100 * time.Millisecond})
+
+	client, _ := net.Dial("udp", address)
+	defer client.Close()
+	if got := exchange(t, client, "query"); got != "first:query" {
+		t.Errorf("Expected first:query, got %q", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		proxy.sessionsMux.Lock()
+		open := len(proxy.sessions)
+		proxy.sessionsMux.Unlock()
+		if open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the idle session to end")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := exchange(t, client, "query"); got != "second:query" {
+		t.Errorf("Expected a new session on the next endpoint, got %q", got)
+	}
+}
+
+func TestUDPProxy_ConsistentHashAcrossSessions(t *testing.T) {
+	endpoints := []string{startUDPEchoServer(t, "first"), startUDPEchoServer(t, "second"), startUDPEchoServer(t, "third")}
+	_, address := startUDPProxy(t, &UDPListenerConfig{Endpoints: endpoints, LoadBalancer: "consistent-hash"})
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		// Each client port is a new session from the same IP
+		client, _ := net.Dial("udp", address)
+		name, _, _ := strings.Cut(exchange(t, client, "log"), ":")
+		names = append(names, name)
+		client.Close()
+	}
+	if names[0] != names[1] || names[1] != names[2] {
+		t.Errorf("Expected sessions from one IP to reach one endpoint, got %v", names)
+	}
+}
+
+func TestUDPProxy_MaxSessions(t *testing.T) {
+	endpoint := startUDPEchoServer(t, "echo")
+	_, address := startUDPProxy(t, &UDPListenerConfig{Endpoints: []string{endpoint}, LoadBalancer: "round-robin", MaxSessions: 1})
+
+	held, _ := net.Dial("udp", address)
+	defer held.Close()
+	exchange(t, held, "query")
+
+	dropped, _ := net.Dial("udp", address)
+	defer dropped.Close()
+	dropped.Write([]byte("query"))
+	dropped.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := dropped.Read(make([]byte, 64)); err == nil {
+		t.Errorf("Expected datagrams over the session limit to be dropped")
+	}
+}
+
+func TestNewUDPProxy_Errors(t *testing.T) {
+	logger, _ := zap.NewProductionConfig().Build()
+	invalid := map[string]*UDPListenerConfig{
+		"no address":   {Endpoints: []string{"127.0.0.1:53"}, LoadBalancer: "round-robin"},
+		"no endpoints": {Address: ":53", LoadBalancer: "round-robin"},
+		"no port":      {Address: ":53", Endpoints: []string{"127.0.0.1"}, LoadBalancer: "round-robin"},
+		"unknown lb":   {Address: ":53", Endpoints: []string{"127.0.0.1:53"}, LoadBalancer: "random"},
+	}
+	for name, config := range invalid {
+		if _, err := NewUDPProxy("test", config, logger); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestLoadConfig_UDPListenerReload(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(endpoint string) {
+		content := "udp:\n  dns:\n    address: \":5353\"\n    endpoints:\n      - " + endpoint + "\n    loadBalancer: consistent-hash\n"
+		if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+
+	g := createTestGateway(configPath)
+	writeConfig("10.0.0.1:53")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	proxy := g.udpProxies["dns"]
+	if proxy == nil {
+		t.Fatalf("Expected the dns UDP listener to be configured")
+	}
+
+	writeConfig("10.0.0.2:53")
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	if g.udpProxies["dns"] != proxy {
+		t.Errorf("Expected the running UDP listener to be reloaded in place")
+	}
+	if got := nextEndpoint(proxy.routes.lb, "192.0.2.1"); got != "10.0.0.2:53" {
+		t.Errorf("Expected the reloaded endpoint 10.0.0.2:53, got %s", got)
+	}
+}
+
+func TestLoadConfig_ListenerChanges(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+	initial := "udp:\n  dns:\n    address: \":5353\"\n    endpoints: [\"10.0.0.1:53\"]\n    loadBalancer: round-robin\n"
+
+	g := createTestGateway(configPath)
+	writeConfig(initial)
+	if err := g.loadConfig(); err != nil {
+		t.Fatalf("Unexpected error during loadConfig: %v", err)
+	}
+	g.listening = true
+
+	changes := map[string]string{
+		"moved":   "udp:\n  dns:\n    address: \":5354\"\n    endpoints: [\"10.0.0.1:53\"]\n    loadBalancer: round-robin\n",
+		"removed": "services: {}\n",
+		"added":   initial + "tcp:\n  postgres:\n    address: \":5432\"\n    endpoints: [\"10.0.0.1:5432\"]\n    loadBalancer: round-robin\n",
+	}
+	for name, content := range changes {
+		writeConfig(content)
+		if err := g.loadConfig(); err == nil {
+			t.Errorf("%s: expected the reload to be rejected", name)
+		}
+	}
+	if proxy := g.udpProxies["dns"]; proxy == nil || proxy.address != ":5353" {
+		t.Errorf("Expected the running dns listener to be kept")
+	}
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUpgradeProtocols are tunnelled when no protocols are configured.
+var defaultUpgradeProtocols = []string{"websocket"}
+
+// upgradeType returns the protocol requested by an Upgrade request, lowercased, or "" for other requests.
+func upgradeType(header http.Header) string {
+	for _, value := range header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return strings.ToLower(header.Get("Upgrade"))
+			}
+		}
+	}
+	return ""
+}
+
+type UpgradeProxy struct {
+	protocols   []string
+	idleTimeout time.Duration
+	maxDuration time.Duration
+}
+
+// NewUpgradeProxy initializes an UpgradeProxy from config.
+func NewUpgradeProxy(config *UpgradeConfig) *UpgradeProxy {
+	proxy := &UpgradeProxy{idleTimeout: config.IdleTimeout, maxDuration: config.MaxDuration}
+	protocols := config.Protocols
+	if len(protocols) == 0 {
+		protocols = defaultUpgradeProtocols
+	}
+	for _, protocol := range protocols {
+		proxy.protocols = append(proxy.protocols, strings.ToLower(protocol))
+	}
+	return proxy
+}
+
+// Allows reports whether connections may be upgraded to protocol.
+func (up *UpgradeProxy) Allows(protocol string) bool {
+	return containsString(up.protocols, protocol) || containsString(up.protocols, "*")
+}
+
+// serveUpgrade forwards an Upgrade request to an endpoint and, once the endpoint switches
+// protocols, tunnels bytes between the client and the endpoint until either side closes,
+// the connection idles or its maximum duration is reached.
+func (g *Gateway) serveUpgrade(w http.ResponseWriter, r *http.Request, service *GatewayServiceConfig) {
+	protocol := upgradeType(r.Header)
+	if !service.upgrade.Allows(protocol) {
+		g.log.Sugar().Infof("Upgrade to %q is not allowed for service %s", protocol, service.serviceName)
+		http.Error(w, "Upgrade not allowed", http.StatusBadRequest)
+		return
+	}
+
+	resp, release, err := g.forward(r, service)
+	if err != nil {
+		g.forwardFailed(w, service.serviceName, err)
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The endpoint declined the upgrade
+		g.writeResponse(w, r, service, resp)
+		return
+	}
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), protocol) {
+		g.log.Sugar().Infof("Endpoint of service %s switched to an unexpected protocol %q", service.serviceName, resp.Header.Get("Upgrade"))
+		http.Error(w, "Service unavailable", http.StatusBadGateway)
+		return
+	}
+
+	client, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		g.log.Sugar().Infof("Failed to take over connection for upgrade: %v", err)
+		http.Error(w, "Upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+	// Server read and write deadlines meant for HTTP requests must not cut the tunnel short
+	client.SetDeadline(time.Time{})
+
+	header := resp.Header.Clone()
+	if service.headers != nil {
+		service.headers.ApplyResponse(header, r)
+	}
+	fmt.Fprintf(buffered, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	header.Write(buffered)
+	buffered.WriteString("\r\n")
+	if err := buffered.Flush(); err != nil {
+		g.log.Sugar().Infof("Failed to complete upgrade: %v", err)
+		return
+	}
+
+	g.log.Sugar().Infof("Tunnelling %s connection for service %s", protocol, service.serviceName)
+	start := time.Now()
+	reason := tunnel(client, buffered.Reader, backend, service.upgrade.idleTimeout, service.upgrade.maxDuration)
+	g.log.Sugar().Infof("Closed %s connection for service %s after %v: %s", protocol, service.serviceName, time.Since(start).Round(time.Millisecond), reason)
+}
+
+// tunnel copies bytes in both directions until one side closes or a timeout fires, then closes
+// both sides and returns why the tunnel ended. Data the client sent along with its request is
+// read from clientReader. Zero timeouts are disabled.
+func tunnel(client io.ReadWriteCloser, clientReader io.Reader, backend io.ReadWriteCloser, idleTimeout, maxDuration time.Duration) string {
+	var once sync.Once
+	done := make(chan string, 1)
+	closeBoth := func(reason string) {
+		once.Do(func() {
+			done <- reason
+			client.Close()
+			backend.Close()
+		})
+	}
+
+	var idle *time.Timer
+	if idleTimeout > 0 {
+		idle = time.AfterFunc(idleTimeout, func() { closeBoth("idle timeout") })
+		defer idle.Stop()
+	}
+	if maxDuration > 0 {
+		limit := time.AfterFunc(maxDuration, func() { closeBoth("maximum duration reached") })
+		defer limit.Stop()
+	}
+
+	pipe := func(dst io.Writer, src io.Reader, side string) {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				if idle != nil {
+					idle.Reset(idleTimeout)
+				}
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					closeBoth(side + " write failed")
+					return
+				}
+			}
+			if err != nil {
+				closeBoth(side + " closed")
+				return
+			}
+		}
+	}
+	go pipe(backend, clientReader, "client")
+	go pipe(client, backend, "endpoint")
+	return <-done
+}
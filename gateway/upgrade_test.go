@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// createUpgradeGateway serves a gateway whose service1 tunnels upgrades to handler.
+func createUpgradeGateway(t *testing.T, config *UpgradeConfig, lb LoadBalancer, handler http.HandlerFunc) (*Gateway, *httptest.Server, string) {
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+	if lb == nil {
+		lb = &MockLoadBalancer{endpoints: []string{upstream.URL}}
+	}
+
+	g := createTestGateway("")
+	g.serviceRegistry["service1"] = &GatewayServiceConfig{
+		serviceName:      "service1",
+		loadBalancerType: lb,
+		endpoints:        []string{upstream.URL},
+		upgrade:          NewUpgradeProxy(config),
+	}
+	server := httptest.NewServer(http.HandlerFunc(g.routeHandler))
+	t.Cleanup(server.Close)
+	return g, server, upstream.URL
+}
+
+// echoWebSocket echoes WebSocket messages until the connection closes.
+func echoWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(messageType, message)
+	}
+}
+
+func TestRouteHandler_WebSocket(t *testing.T) {
+	_, server, _ := createUpgradeGateway(t, &UpgradeConfig{}, nil, echoWebSocket)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/service1/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial through the gateway: %v", err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected status 101, got %d", resp.StatusCode)
+	}
+
+	for _, message := range []string{"hello", "world"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		_, echoed, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if string(echoed) != message {
+			t.Errorf("Expected echo %q, got %q", message, echoed)
+		}
+	}
+}
+
+// dialUpgrade sends a raw Upgrade request for protocol and returns the connection and response.
+func dialUpgrade(t *testing.T, server *httptest.Server, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.Write([]byte("GET /service1 HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: " + protocol + "\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return conn, reader, resp
+}
+
+// echoUpgrade switches to the requested protocol and echoes raw bytes.
+func echoUpgrade(w http.ResponseWriter, r *http.Request) {
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + r.Header.Get("Upgrade") + "\r\n\r\n")
+	buffered.Flush()
+	io.Copy(conn, buffered)
+}
+
+func TestRouteHandler_GenericUpgrade(t *testing.T) {
+	_, server, _ := createUpgradeGateway(t, &UpgradeConfig{Protocols: []string{"echo"}}, nil, echoUpgrade)
+
+	conn, reader, resp := dialUpgrade(t, server, "echo")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("Expected a switch to echo, got %d %v", resp.StatusCode, resp.Header)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected the echoed bytes, got %q %v", buf, err)
+	}
+
+	refused, _, resp := dialUpgrade(t, server, "websocket")
+	defer refused.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a protocol that is not allowed, got %d", resp.StatusCode)
+	}
+}
+
+func TestRouteHandler_UpgradeTimeouts(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *UpgradeConfig
+		// keepAlive sends data more often than the idle timeout
+		keepAlive bool
+	}{
+		{"idle timeout", &UpgradeConfig{Protocols: []string{"echo"}, IdleTimeout: 100 * time.Millisecond}, false},
+		{"max duration", &UpgradeConfig{Protocols: []string{"echo"}, IdleTimeout: 100 * time.Millisecond, MaxDuration: 300 * time.Millisecond}, true},
+	}
+	for _, c := range cases {
+		_, server, _ := createUpgradeGateway(t, c.config, nil, echoUpgrade)
+		conn, reader, _ := dialUpgrade(t, server, "echo")
+
+		start := time.Now()
+		stop := make(chan struct{})
+		if c.keepAlive {
+			go func() {
+				ticker := time.NewTicker(30 * time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						conn.Write([]byte("."))
+					case <-stop:
+						return
+					}
+				}
+			}()
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err := io.Copy(io.Discard, reader)
+		elapsed := time.Since(start)
+		close(stop)
+		conn.Close()
+
+		// Writes racing the close may reset the connection instead of ending it cleanly
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			t.Errorf("%s: Expected the gateway to close the tunnel, got %v", c.name, err)
+		}
+		if c.keepAlive && elapsed < 250*time.Millisecond {
+			t.Errorf("%s: Expected an active tunnel to stay open until its maximum duration, closed after %v", c.name, elapsed)
+		}
+	}
+}
+
+func TestRouteHandler_UpgradeLeastConnections(t *testing.T) {
+	lb := NewLeastConnections(nil, createTestGateway("").log)
+	_, server, upstreamURL := createUpgradeGateway(t, &UpgradeConfig{}, lb, echoWebSocket)
+	lb.SetEndpoints([]string{upstreamURL})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/service1", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial through the gateway: %v", err)
+	}
+	if count := connections(lb, upstreamURL); count != 1 {
+		t.Errorf("Expected the open tunnel to count as 1 connection, got %d", count)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for connections(lb, upstreamURL) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := connections(lb, upstreamURL); count != 0 {
+		t.Errorf("Expected the closed tunnel to be released, got %d connections", count)
+	}
+}
+
+func connections(lb *LeastConnections, endpoint string) int {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	return lb.connCount[endpoint]
+}